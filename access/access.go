@@ -0,0 +1,89 @@
+// access package authenticates callers of gRPC methods with access tokens or API keys and
+// authorizes them with policies declared next to the handlers.
+//
+// Users authenticate with access tokens issued by the Auth service in the Authorization: Bearer
+// header. API keys in the X-Api-Key header authenticate admins, e.g. other services or operators.
+//
+// Admins are only callers with API keys. Users have no roles and access tokens carry no role
+// claim, so a user is never an admin and can't be made one.
+
+package access
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// UserID is subject of the access token, it is empty for API keys.
+	UserID string
+	Email  string
+	// APIKey is name of the API key the caller authenticated with.
+	APIKey string
+	// Admin may call every method. Only API keys authenticate admins.
+	Admin bool
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns ctx of a request made by p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// Caller returns principal of the request handled in ctx. It is set by Interceptor for
+// authenticated callers, even of public methods.
+func Caller(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Policy reports whether principal p may call a method with request req. p is nil for
+// unauthenticated callers and req is nil for streams.
+type Policy func(p *Principal, req interface{}) bool
+
+// Public allows everyone.
+func Public(p *Principal, req interface{}) bool {
+	return true
+}
+
+// Authenticated allows every authenticated caller.
+func Authenticated(p *Principal, req interface{}) bool {
+	return p != nil
+}
+
+// Admin allows only admins.
+func Admin(p *Principal, req interface{}) bool {
+	return p != nil && p.Admin
+}
+
+// Self allows admins and the user whose ID is the id field of the request.
+func Self(p *Principal, req interface{}) bool {
+	if Admin(p, req) {
+		return true
+	}
+
+	r, ok := req.(interface{ GetId() string })
+	return ok && p != nil && p.UserID != "" && p.UserID == r.GetId()
+}
+
+// ServicePolicies returns policy for every method of the service.
+func ServicePolicies(desc *grpc.ServiceDesc, policy Policy) map[string]Policy {
+	policies := map[string]Policy{}
+	for _, m := range desc.Methods {
+		policies[FullMethod(desc, m.MethodName)] = policy
+	}
+	for _, s := range desc.Streams {
+		policies[FullMethod(desc, s.StreamName)] = policy
+	}
+
+	return policies
+}
+
+// FullMethod returns full name of the method of the service, e.g. /users.Users/GetUser.
+func FullMethod(desc *grpc.ServiceDesc, method string) string {
+	return "/" + desc.ServiceName + "/" + strings.TrimPrefix(method, "/")
+}
@@ -0,0 +1,57 @@
+package access
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKeys maps hex encoded SHA-256 hashes of API keys to their names.
+type APIKeys map[string]string
+
+// HashAPIKey returns hash under which key is stored. Keys are random, so they don't need a salt
+// nor a slow hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadAPIKeys reads file whose lines contain name of an API key and hash of the key separated
+// by whitespace. Empty lines and lines starting with # are skipped.
+func LoadAPIKeys(path string) (APIKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading api keys: %w", err)
+	}
+
+	keys := APIKeys{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("api keys line %d: expected name and hash", line)
+		}
+		hash, err := hex.DecodeString(fields[1])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("api keys line %d: invalid sha-256 hash", line)
+		}
+		keys[hex.EncodeToString(hash)] = fields[0]
+	}
+
+	return keys, scanner.Err()
+}
+
+// name returns name of the key, or false if it is unknown.
+func (k APIKeys) name(key string) (string, bool) {
+	name, ok := k[HashAPIKey(key)]
+	return name, ok
+}
@@ -0,0 +1,121 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/toncek345/userservice/tokens"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys of credentials. The HTTP gateway forwards the Authorization header, the X-Api-Key
+// header has to be forwarded by its header matcher.
+const (
+	AuthorizationMetadataKey = "authorization"
+	APIKeyMetadataKey        = "x-api-key"
+)
+
+var (
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Interceptor authenticates callers with bearer access tokens or API keys, adds their principal
+// to the context and rejects calls which the policy of the method doesn't allow. Unauthenticated
+// callers get Unauthenticated, others PermissionDenied.
+type Interceptor struct {
+	// Keys verify access tokens, which are rejected if it is nil.
+	Keys *tokens.KeySet
+	// Issuer is the required iss claim of access tokens.
+	Issuer string
+	// APIKeys authenticate admins, e.g. other services.
+	APIKeys APIKeys
+	// Policies by full method name, methods without a policy are allowed only to admins.
+	Policies map[string]Policy
+}
+
+// authenticate returns admin principal for a valid API key, or principal of the user for a
+// valid access token, which is never an admin.
+func (i *Interceptor) authenticate(ctx context.Context) (*Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if keys := md.Get(APIKeyMetadataKey); len(keys) > 0 {
+		name, ok := i.APIKeys.name(keys[0])
+		if !ok {
+			return nil, errInvalidCredentials
+		}
+
+		return &Principal{APIKey: name, Admin: true}, nil
+	}
+
+	authorization := md.Get(AuthorizationMetadataKey)
+	if len(authorization) == 0 {
+		return nil, errMissingCredentials
+	}
+	scheme, token, ok := strings.Cut(authorization[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || i.Keys == nil {
+		return nil, errInvalidCredentials
+	}
+
+	claims, err := i.Keys.Parse(strings.TrimSpace(token))
+	if err != nil || claims.Issuer != i.Issuer || claims.Subject == "" {
+		return nil, errInvalidCredentials
+	}
+
+	return &Principal{UserID: claims.Subject, Email: claims.Email}, nil
+}
+
+// authorize returns ctx with principal of the caller, or status error if the call isn't
+// allowed. Invalid credentials are accepted by public methods, e.g. an expired token sent to
+// Login.
+func (i *Interceptor) authorize(ctx context.Context, method string, req interface{}) (context.Context, error) {
+	policy, ok := i.Policies[method]
+	if !ok {
+		policy = Admin
+	}
+
+	p, err := i.authenticate(ctx)
+	if p != nil {
+		ctx = WithPrincipal(ctx, p)
+	}
+	if policy(p, req) {
+		return ctx, nil
+	}
+	if p == nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return nil, status.Error(codes.PermissionDenied, "permission denied")
+}
+
+func (i *Interceptor) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := i.authorize(ctx, info.FullMethod, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (i *Interceptor) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := i.authorize(ss.Context(), info.FullMethod, nil)
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &principalStream{ServerStream: ss, ctx: ctx})
+}
+
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,240 @@
+// certs package serves TLS with certificates which are reloaded from disk and verifies client
+// certificates of mutual TLS.
+//
+// Certificate, key and client CA files are checked for changes on TLS handshakes, so rotated
+// certificates are used by new connections without a restart. Existing connections keep the
+// certificate they were established with.
+
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// DefaultCheckInterval is how often files are checked for changes if Reloader.CheckInterval is 0.
+const DefaultCheckInterval = 10 * time.Second
+
+// Reloader provides TLS configs of the server and of the gateway. Load must be called before
+// the configs are used.
+type Reloader struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS, clients must present a certificate issued by one of the
+	// CAs in the file.
+	ClientCAFile string
+	// CheckInterval limits how often files are checked for changes, DefaultCheckInterval is
+	// used if it is 0.
+	CheckInterval time.Duration
+	// Logger logs failed reloads, slog.Default is used if it is nil.
+	Logger *slog.Logger
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamp     string
+	checked   time.Time
+	// loaded contains every server certificate loaded since the start, gateway connections
+	// established before a reload keep presenting the previous one.
+	loaded map[string]bool
+}
+
+// fileStamp identifies versions of the files by their size and modification time.
+func (r *Reloader) fileStamp() (string, error) {
+	stamp := ""
+	for _, path := range []string{r.CertFile, r.KeyFile, r.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d-%d;", fi.Size(), fi.ModTime().UnixNano())
+	}
+
+	return stamp, nil
+}
+
+// Load reads the certificate, its key and client CAs.
+func (r *Reloader) Load() error {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return fmt.Errorf("checking certificate files: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("reading client CAs: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in client CA file %s", r.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	if r.loaded == nil {
+		r.loaded = map[string]bool{}
+	}
+	r.loaded[string(cert.Certificate[0])] = true
+	r.clientCAs = clientCAs
+	r.stamp = stamp
+	r.checked = time.Now()
+
+	return nil
+}
+
+// current returns the certificate and client CAs, they are reloaded first if files changed.
+// Files which fail to load are logged and the previous ones are kept.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	interval := r.CheckInterval
+	if interval == 0 {
+		interval = DefaultCheckInterval
+	}
+
+	r.mu.Lock()
+	if time.Since(r.checked) < interval {
+		defer r.mu.Unlock()
+		return r.cert, r.clientCAs
+	}
+	r.checked = time.Now()
+	loaded := r.stamp
+	r.mu.Unlock()
+
+	// Files are replaced one by one, so they are loaded again on the next check if they
+	// changed while being read.
+	stamp, err := r.fileStamp()
+	if err == nil && stamp != loaded {
+		err = r.Load()
+		if err == nil {
+			r.logger().Info("certificates reloaded", "cert_file", r.CertFile)
+		}
+	}
+	if err != nil {
+		r.logger().Warn("reloading certificates failed", "error", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.clientCAs
+}
+
+func (r *Reloader) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
+	}
+
+	return r.Logger
+}
+
+// isOwn reports whether raw is the server certificate.
+func (r *Reloader) isOwn(raw []byte) bool {
+	cert, _ := r.current()
+	return bytes.Equal(cert.Certificate[0], raw)
+}
+
+// wasOwn reports whether raw is the server certificate or was one before a reload. New
+// connections are verified with isOwn, so only connections established while raw was the
+// server certificate present it.
+func (r *Reloader) wasOwn(raw []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loaded[string(raw)]
+}
+
+// ServerConfig returns config of listeners. With client CAs, clients must present a
+// certificate issued by them or the server certificate, which is used by the gateway.
+func (r *Reloader) ServerConfig() *tls.Config {
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+
+	if r.ClientCAFile != "" {
+		// Client CAs can be reloaded, so certificates are verified by verifyClient instead of
+		// the ClientCAs pool of the config.
+		c.ClientAuth = tls.RequireAnyClientCert
+		c.VerifyPeerCertificate = r.verifyClient
+	}
+
+	return c
+}
+
+func (r *Reloader) verifyClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("client certificate is required")
+	}
+	if r.isOwn(rawCerts[0]) {
+		return nil
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("parsing client certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	_, clientCAs := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         clientCAs,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return fmt.Errorf("verifying client certificate: %w", err)
+	}
+
+	return nil
+}
+
+// GatewayConfig returns config of the connection from the gateway to the gRPC listener. The
+// gateway dials a loopback address which needn't be among names of the certificate, so the
+// server is verified by presenting the same certificate as the gateway.
+func (r *Reloader) GatewayConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Verification is done by VerifyPeerCertificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !r.isOwn(rawCerts[0]) {
+				return errors.New("grpc server presented unexpected certificate")
+			}
+			return nil
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+}
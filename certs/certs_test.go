@@ -0,0 +1,246 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/certs"
+	"github.com/toncek345/userservice/certs/certstest"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// handshake connects client to server and returns certificate presented by the server.
+func handshake(server, client *tls.Config) (*x509.Certificate, error) {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		return nil, err
+	}
+	defer lis.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		serverErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		<-serverErr
+		return nil, err
+	}
+	defer conn.Close()
+	// Client certificate is verified after the client finishes its handshake with TLS 1.3.
+	if err := <-serverErr; err != nil {
+		return nil, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "ca")
+	cert, key := ca.Issue(t, "server-1")
+	r := &certs.Reloader{
+		CertFile:      certstest.WriteFile(t, dir, "tls.crt", cert),
+		KeyFile:       certstest.WriteFile(t, dir, "tls.key", key),
+		CheckInterval: time.Nanosecond,
+	}
+	if err := r.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.PEM)
+	client := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	expectServer := func(name string) {
+		t.Helper()
+
+		cert, err := handshake(r.ServerConfig(), client)
+		if err != nil {
+			t.Fatalf("handshake: %s", err)
+		}
+		if cert.Subject.CommonName != name {
+			t.Fatalf("expected certificate %s, got %s", name, cert.Subject.CommonName)
+		}
+	}
+
+	expectServer("server-1")
+
+	// Modification times of files written in quick succession can be equal.
+	touch := func(path string) {
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatalf("touch: %s", err)
+		}
+	}
+	cert, key = ca.Issue(t, "server-2")
+	touch(certstest.WriteFile(t, dir, "tls.crt", cert))
+	touch(certstest.WriteFile(t, dir, "tls.key", key))
+	expectServer("server-2")
+
+	// Broken files are ignored.
+	touch(certstest.WriteFile(t, dir, "tls.key", []byte("garbage")))
+	expectServer("server-2")
+}
+
+func TestClientVerification(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "ca")
+	clientCA := certstest.NewCA(t, "client ca")
+	serverCert, serverKey := ca.Issue(t, "server")
+	r := &certs.Reloader{
+		CertFile:     certstest.WriteFile(t, dir, "tls.crt", serverCert),
+		KeyFile:      certstest.WriteFile(t, dir, "tls.key", serverKey),
+		ClientCAFile: certstest.WriteFile(t, dir, "client-ca.crt", clientCA.PEM),
+	}
+	if err := r.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+
+	keyPair := func(certPEM, keyPEM []byte) []tls.Certificate {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatalf("key pair: %s", err)
+		}
+		return []tls.Certificate{cert}
+	}
+
+	tests := []struct {
+		name    string
+		certs   []tls.Certificate
+		isError bool
+	}{
+		{
+			name:  "issued by client CA",
+			certs: keyPair(clientCA.Issue(t, "client")),
+		},
+		{
+			name:  "server certificate",
+			certs: keyPair(serverCert, serverKey),
+		},
+		{
+			name:    "issued by other CA",
+			certs:   keyPair(ca.Issue(t, "client")),
+			isError: true,
+		},
+		{
+			name:    "no certificate",
+			isError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := handshake(r.ServerConfig(), &tls.Config{InsecureSkipVerify: true, Certificates: test.certs})
+			if test.isError != (err != nil) {
+				t.Fatalf("expected error %t, got %v", test.isError, err)
+			}
+		})
+	}
+}
+
+func TestGatewayConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "ca")
+	cert, key := ca.Issue(t, "server")
+	r := &certs.Reloader{
+		CertFile: certstest.WriteFile(t, dir, "tls.crt", cert),
+		KeyFile:  certstest.WriteFile(t, dir, "tls.key", key),
+	}
+	if err := r.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+
+	if _, err := handshake(r.ServerConfig(), r.GatewayConfig()); err != nil {
+		t.Fatalf("gateway handshake: %s", err)
+	}
+
+	// Other servers aren't trusted even if they have a valid certificate.
+	cert, key = ca.Issue(t, "other")
+	other := &certs.Reloader{
+		CertFile: certstest.WriteFile(t, dir, "other.crt", cert),
+		KeyFile:  certstest.WriteFile(t, dir, "other.key", key),
+	}
+	if err := other.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if _, err := handshake(other.ServerConfig(), r.GatewayConfig()); err == nil {
+		t.Fatal("gateway trusted other server")
+	}
+}
+
+func TestInterceptorAfterReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "ca")
+	parse := func(certPEM []byte) *x509.Certificate {
+		block, _ := pem.Decode(certPEM)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("parsing certificate: %s", err)
+		}
+		return cert
+	}
+
+	oldCert, oldKey := ca.Issue(t, "server-1")
+	r := &certs.Reloader{
+		CertFile:      certstest.WriteFile(t, dir, "tls.crt", oldCert),
+		KeyFile:       certstest.WriteFile(t, dir, "tls.key", oldKey),
+		CheckInterval: time.Nanosecond,
+	}
+	if err := r.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	newCert, newKey := ca.Issue(t, "server-2")
+	certstest.WriteFile(t, dir, "tls.crt", newCert)
+	certstest.WriteFile(t, dir, "tls.key", newKey)
+	if err := r.Load(); err != nil {
+		t.Fatalf("reload: %s", err)
+	}
+
+	clientCert, _ := ca.Issue(t, "http-client")
+	identity := func(peerCert *x509.Certificate) string {
+		t.Helper()
+
+		ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{peerCert}},
+		}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(certs.ForwardedCertMetadataKey, string(parse(clientCert).Raw)))
+		name := ""
+		interceptor := &certs.Interceptor{Reloader: r}
+		interceptor.Unary(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			if id, ok := certs.ClientIdentity(ctx); ok {
+				name = id.CommonName
+			}
+			return nil, nil
+		})
+		return name
+	}
+
+	// Gateway connection established before the reload presents the previous certificate.
+	if name := identity(parse(oldCert)); name != "http-client" {
+		t.Fatalf("expected forwarded identity with previous certificate, got %q", name)
+	}
+	if name := identity(parse(newCert)); name != "http-client" {
+		t.Fatalf("expected forwarded identity with current certificate, got %q", name)
+	}
+	// Other clients can't forward identities.
+	grpcClientCert, _ := ca.Issue(t, "grpc-client")
+	if name := identity(parse(grpcClientCert)); name != "grpc-client" {
+		t.Fatalf("expected identity of the client, got %q", name)
+	}
+}
@@ -0,0 +1,109 @@
+package certs
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ForwardedCertMetadataKey is the gRPC metadata key in which the gateway forwards certificate
+// of the HTTP client. It is trusted only from connections of the gateway.
+const ForwardedCertMetadataKey = "x-forwarded-client-cert-bin"
+
+// Identity of a client verified by mutual TLS.
+type Identity struct {
+	// CommonName is common name of the certificate subject.
+	CommonName string
+	DNSNames   []string
+	// URIs are URI names of the certificate, e.g. SPIFFE IDs.
+	URIs        []string
+	Certificate *x509.Certificate
+}
+
+func newIdentity(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+
+	return id
+}
+
+type identityKey struct{}
+
+// ClientIdentity returns identity of the client of the request handled in ctx. It is set by
+// Interceptor for clients of the gRPC listener and of the HTTP gateway.
+func ClientIdentity(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// GatewayMetadata is runtime.WithMetadata annotator of the gateway which forwards verified
+// client certificate of the HTTP request.
+func GatewayMetadata(ctx context.Context, r *http.Request) metadata.MD {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return metadata.Pairs(ForwardedCertMetadataKey, string(r.TLS.PeerCertificates[0].Raw))
+}
+
+// Interceptor adds identity of verified clients to context of gRPC requests.
+type Interceptor struct {
+	Reloader *Reloader
+}
+
+func (i *Interceptor) withIdentity(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return ctx
+	}
+
+	cert := tlsInfo.State.PeerCertificates[0]
+	if !i.Reloader.wasOwn(cert.Raw) {
+		return context.WithValue(ctx, identityKey{}, newIdentity(cert))
+	}
+
+	// Request comes from the gateway, which verified the HTTP client. The gateway forwards a
+	// single certificate, more values mean that the client managed to add its own.
+	forwarded := metadata.ValueFromIncomingContext(ctx, ForwardedCertMetadataKey)
+	if len(forwarded) != 1 {
+		return ctx
+	}
+	cert, err := x509.ParseCertificate([]byte(forwarded[0]))
+	if err != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, identityKey{}, newIdentity(cert))
+}
+
+func (i *Interceptor) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(i.withIdentity(ctx), req)
+}
+
+func (i *Interceptor) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &identityStream{ServerStream: ss, ctx: i.withIdentity(ss.Context())})
+}
+
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
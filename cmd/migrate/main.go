@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/toncek345/userservice/config"
+	"github.com/toncek345/userservice/migrations"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+const usage = `Usage: migrate [flags] <command>
+
+Commands:
+  up            apply all pending migrations
+  down          revert the last applied migration
+  status        list migrations and whether they are applied
+  to <version>  migrate up or down to the given version (0 reverts everything)
+
+Flags, which are shared with the server configuration:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	// Migrations use the database settings of the server, so that both share the config.
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("config: %s", err)
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sqlx.Open(cfg.DB.Driver, cfg.DB.DSN)
+	if err != nil {
+		log.Fatalf("db open: %s", err)
+	}
+	defer db.Close()
+
+	all, err := migrations.EmbeddedFor(cfg.DB.Driver)
+	if err != nil {
+		log.Fatalf("loading migrations: %s", err)
+	}
+	migrator := &migrations.Migrator{DB: db, Migrations: all}
+	ctx := context.Background()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		version, perr := strconv.ParseInt(flag.Arg(1), 10, 64)
+		if perr != nil {
+			log.Fatalf("invalid version %q", flag.Arg(1))
+		}
+		err = migrator.To(ctx, version)
+	case "status":
+		var statuses []*migrations.Status
+		statuses, err = migrator.Status(ctx)
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-30s %s\n", s.Version, s.Name, applied)
+		}
+	default:
+		log.Printf("unknown command %q", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s: %s", flag.Arg(0), err)
+	}
+
+	if version, err := migrator.Version(ctx); err == nil {
+		log.Printf("schema version: %d", version)
+	}
+}
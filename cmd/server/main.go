@@ -1,54 +1,274 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/toncek345/userservice/access"
+	"github.com/toncek345/userservice/certs"
+	"github.com/toncek345/userservice/config"
+	"github.com/toncek345/userservice/logging"
+	"github.com/toncek345/userservice/metrics"
+	"github.com/toncek345/userservice/migrations"
 	"github.com/toncek345/userservice/server"
+	"github.com/toncek345/userservice/server/health"
+	"github.com/toncek345/userservice/server/interceptors"
 	"github.com/toncek345/userservice/service"
 	"github.com/toncek345/userservice/storage"
+	"github.com/toncek345/userservice/tokens"
+	"github.com/toncek345/userservice/tracing"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
+	"golang.org/x/exp/slog"
+	_ "modernc.org/sqlite"
 )
 
+// Backoff of database connection retries on startup.
+const (
+	connectMinBackoff = 100 * time.Millisecond
+	connectMaxBackoff = 5 * time.Second
+)
+
+// fatal logs msg with args and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func newUserStorage(cfg *config.DB, db *sqlx.DB, logger *slog.Logger) (storage.UserStorage, error) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	switch cfg.Driver {
+	case "postgres":
+		return &storage.UserStorageSQL{DB: db, Logger: logger}, nil
+	case "sqlite":
+		// SQLite allows a single writer, serializing connections avoids busy errors.
+		db.SetMaxOpenConns(1)
+		return &storage.UserStorageSQLite{DB: db, Logger: logger}, nil
+	}
+
+	return nil, fmt.Errorf("unknown db driver %q", cfg.Driver)
+}
+
+func newTokenStorage(driver string, db *sqlx.DB, logger *slog.Logger) (storage.RefreshTokenStorage, error) {
+	switch driver {
+	case "postgres":
+		return &storage.RefreshTokenStorageSQL{DB: db, Logger: logger}, nil
+	case "sqlite":
+		return &storage.RefreshTokenStorageSQLite{DB: db, Logger: logger}, nil
+	}
+
+	return nil, fmt.Errorf("unknown db driver %q", driver)
+}
+
 func main() {
-	dbOpts := "host=localhost user=user password=password dbname=database sslmode=disable"
-	if os.Getenv("ENV") == "compose" {
-		dbOpts = "host=postgres user=user password=password dbname=database sslmode=disable"
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := fs.Bool("print-config", false, "print configuration with secrets redacted and exit")
+	cfg, err := config.Load(fs, os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("config: %s", err)
 	}
 
-	db, err := sqlx.Open("postgres", dbOpts)
+	if *printConfig {
+		if err := cfg.Redacted().WriteYAML(os.Stdout); err != nil {
+			log.Fatalf("print config: %s", err)
+		}
+		return
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		log.Fatal("db is not available")
+		log.Fatalf("logger: %s", err)
 	}
+	slog.SetDefault(logger)
 
-	userStorage := &storage.UserStorageSQL{
-		DB: db,
+	var tlsReloader *certs.Reloader
+	if cfg.TLS.Enabled() {
+		tlsReloader = &certs.Reloader{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+			Logger:       logger,
+		}
+		if err := tlsReloader.Load(); err != nil {
+			fatal("loading tls certificates failed", "error", err)
+		}
 	}
-	userService := &service.UserServiceImpl{
-		UserStorage: userStorage,
+
+	shutdownTracing, err := tracing.Setup(context.Background(), &tracing.Options{
+		Exporter:     cfg.Tracing.Exporter,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
+		SampleRatio:  cfg.Tracing.SampleRatio,
+		ServiceName:  "userservice",
+	})
+	if err != nil {
+		fatal("tracing setup failed", "error", err)
 	}
 
-	s, err := server.NewServer(9000, 9001, userService)
+	db, err := sqlx.Open(cfg.DB.Driver, cfg.DB.DSN)
 	if err != nil {
-		log.Fatalf("new server: %s", err)
+		fatal("db open failed", "error", err)
 	}
 
+	userStorage, err := newUserStorage(&cfg.DB, db, logger)
+	if err != nil {
+		fatal("creating user storage failed", "error", err)
+	}
+
+	userServiceImpl := &service.UserServiceImpl{
+		PasswordCost: cfg.BcryptCost,
+		Logger:       logger,
+	}
+	var userService service.UserService = userServiceImpl
+
+	var m *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		m = metrics.New()
+		m.RegisterDB(db.DB, cfg.DB.Driver)
+		userStorage = &metrics.UserStorage{UserStorage: userStorage, Metrics: m}
+		userServiceImpl.PasswordHashDuration = m.PasswordHashDuration
+		userService = &metrics.UserService{UserService: userServiceImpl, Metrics: m}
+	}
+	userServiceImpl.UserStorage = userStorage
+
+	tokenStorage, err := newTokenStorage(cfg.DB.Driver, db, logger)
+	if err != nil {
+		fatal("creating token storage failed", "error", err)
+	}
+
+	tokenKeys := &tokens.KeySet{
+		Algorithm:        cfg.Auth.SigningAlgorithm,
+		RotationInterval: cfg.Auth.KeyRotation,
+		Retention:        cfg.Auth.AccessTokenTTL,
+		Dir:              cfg.Auth.KeysDir,
+		Logger:           logger,
+	}
+	if err := tokenKeys.Load(); err != nil {
+		fatal("loading token signing keys failed", "error", err)
+	}
+
+	var apiKeys access.APIKeys
+	if cfg.Auth.APIKeysFile != "" {
+		apiKeys, err = access.LoadAPIKeys(cfg.Auth.APIKeysFile)
+		if err != nil {
+			fatal("loading api keys failed", "error", err)
+		}
+	}
+
+	authService := &service.AuthServiceImpl{
+		UserService:     userService,
+		TokenStorage:    tokenStorage,
+		Keys:            tokenKeys,
+		Issuer:          cfg.Auth.Issuer,
+		AccessTokenTTL:  cfg.Auth.AccessTokenTTL,
+		RefreshTokenTTL: cfg.Auth.RefreshTokenTTL,
+		Logger:          logger,
+	}
+
+	healthChecks := &health.Registry{}
+	healthChecks.Register(&health.Check{
+		Name:     "db",
+		Checker:  health.CheckerFunc(db.PingContext),
+		Timeout:  cfg.Health.CheckTimeout,
+		CacheTTL: cfg.Health.CacheTTL,
+	})
+
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:         cfg.GRPCAddr,
+		HTTPAddr:         cfg.HTTPAddr,
+		SinglePort:       cfg.SinglePort,
+		InProcessGateway: cfg.InProcessGateway,
+		HTTPReadTimeout:  cfg.Timeouts.HTTPRead,
+		HTTPWriteTimeout: cfg.Timeouts.HTTPWrite,
+		HTTPIdleTimeout:  cfg.Timeouts.HTTPIdle,
+		HealthChecks:     healthChecks,
+		Reflection:       cfg.GRPCReflection,
+		Logger:           logger,
+		DisableAccessLog: !cfg.Requests.AccessLog,
+		Deadlines: interceptors.Deadlines{
+			Default:   cfg.Requests.DefaultTimeout,
+			Max:       cfg.Requests.MaxTimeout,
+			StreamMax: cfg.Requests.MaxStreamDuration,
+		},
+		MaxRequestSize: cfg.Requests.MaxSize,
+		Metrics:        m,
+		TLS:            tlsReloader,
+		Auth:           authService,
+		TokenKeys:      tokenKeys,
+		Access: &access.Interceptor{
+			Keys:    tokenKeys,
+			Issuer:  cfg.Auth.Issuer,
+			APIKeys: apiKeys,
+		},
+	}, userService)
+	if err != nil {
+		fatal("creating server failed", "error", err)
+	}
+
+	// Servers are started before the database is available, so that health reports the service
+	// as not ready instead of the process crashing.
 	go func() {
-		log.Println("Starting grpc server")
-		log.Printf("server run exited: %s\n", s.Start())
-	}()
-	// TODO: there should be some synchronization between because GRPC needs to be running in order to be reachable from reverse proxy.
-	time.Sleep(500 * time.Millisecond)
-	go func() {
-		log.Println("Starting http server")
-		log.Printf("server run exited: %s\n", s.StartHTTP())
+		logger.Info("starting grpc server", "addr", cfg.GRPCAddr, "single_port", cfg.SinglePort)
+		logger.Info("grpc server exited", "error", s.Start())
 	}()
 
+	startCtx, startCancel := context.WithTimeout(context.Background(), cfg.DB.ConnectTimeout)
+	defer startCancel()
+
+	if err := s.WaitGRPCServing(startCtx); err != nil {
+		fatal("grpc server didn't start", "error", err)
+	}
+	if !cfg.SinglePort {
+		go func() {
+			logger.Info("starting http server", "addr", cfg.HTTPAddr)
+			logger.Info("http server exited", "error", s.StartHTTP())
+		}()
+	}
+
+	if err := storage.WaitForDB(startCtx, db, connectMinBackoff, connectMaxBackoff); err != nil {
+		fatal("db is not available", "error", err)
+	}
+
+	if cfg.CheckSchema {
+		all, err := migrations.EmbeddedFor(cfg.DB.Driver)
+		if err != nil {
+			fatal("loading migrations failed", "error", err)
+		}
+
+		migrator := &migrations.Migrator{DB: db, Migrations: all}
+		if err := migrator.CheckCurrent(startCtx); err != nil {
+			fatal("schema check failed", "error", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if cfg.Purge.Retention > 0 {
+		purgeWorker := &service.PurgeWorker{
+			UserStorage:  userStorage,
+			TokenStorage: tokenStorage,
+			Retention:    cfg.Purge.Retention,
+			Interval:     cfg.Purge.Interval,
+			Logger:       logger,
+		}
+		go purgeWorker.Run(ctx)
+	}
+
+	s.SetReady(true)
+	logger.Info("service is ready")
+
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM,
 		syscall.SIGHUP,
@@ -56,6 +276,14 @@ func main() {
 		syscall.SIGQUIT)
 
 	<-signalChan
-	log.Println("shutting down...")
-	s.Stop()
+	logger.Info("shutting down")
+	cancel()
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
+	defer stopCancel()
+	s.Stop(stopCtx)
+
+	if err := shutdownTracing(stopCtx); err != nil {
+		logger.Warn("exporting remaining spans failed", "error", err)
+	}
 }
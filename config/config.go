@@ -0,0 +1,586 @@
+// config package loads configuration of the service.
+//
+// Every setting can be given in a YAML config file, as an environment variable and as a
+// command line flag. Later sources override earlier ones: defaults, config file, environment
+// and flags. Flag and environment variable names are derived from the setting key in the config
+// file, e.g. db.max_open_conns is set with the -db-max-open-conns flag and the
+// USERSERVICE_DB_MAX_OPEN_CONNS environment variable.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prefix of environment variables which configure the service.
+const EnvPrefix = "USERSERVICE_"
+
+// ConfigFileEnv names environment variable with path of the config file. The path can be
+// given with the -config flag as well.
+const ConfigFileEnv = EnvPrefix + "CONFIG"
+
+// redacted replaces secrets in printed configuration.
+const redacted = "REDACTED"
+
+type Config struct {
+	// GRPCAddr and HTTPAddr are listen addresses of the gRPC server and the HTTP gateway.
+	GRPCAddr string `yaml:"grpc_addr"`
+	HTTPAddr string `yaml:"http_addr"`
+	// SinglePort serves gRPC and the HTTP gateway on GRPCAddr, HTTPAddr is ignored.
+	SinglePort bool `yaml:"single_port"`
+	// InProcessGateway connects the HTTP gateway to gRPC in memory instead of over grpc_addr.
+	InProcessGateway bool `yaml:"in_process_gateway"`
+	// GRPCReflection enables gRPC server reflection, e.g. for grpcurl.
+	GRPCReflection bool `yaml:"grpc_reflection"`
+	DB             DB   `yaml:"db"`
+	// CheckSchema refuses to start if database has pending migrations.
+	CheckSchema bool `yaml:"check_schema"`
+	// BcryptCost is cost of newly hashed passwords.
+	BcryptCost int      `yaml:"bcrypt_cost"`
+	Timeouts   Timeouts `yaml:"timeouts"`
+	Requests   Requests `yaml:"requests"`
+	TLS        TLS      `yaml:"tls"`
+	Health     Health   `yaml:"health"`
+	Metrics    Metrics  `yaml:"metrics"`
+	Tracing    Tracing  `yaml:"tracing"`
+	// LogLevel is one of debug, info, warn and error.
+	LogLevel string `yaml:"log_level"`
+	// LogFormat is json or text (logfmt).
+	LogFormat string `yaml:"log_format"`
+	Purge     Purge  `yaml:"purge"`
+	Auth      Auth   `yaml:"auth"`
+}
+
+type DB struct {
+	// Driver is postgres or sqlite.
+	Driver string `yaml:"driver"`
+	// DSN is connection string, DefaultDSN of the driver is used if it is empty.
+	DSN string `yaml:"dsn"`
+	// Pool settings, zero values mean no limit.
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	// ConnectTimeout limits how long database is waited for on startup.
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+}
+
+type Timeouts struct {
+	// Shutdown limits how long in-flight requests are waited for on shutdown.
+	Shutdown  time.Duration `yaml:"shutdown"`
+	HTTPRead  time.Duration `yaml:"http_read"`
+	HTTPWrite time.Duration `yaml:"http_write"`
+	HTTPIdle  time.Duration `yaml:"http_idle"`
+}
+
+type Requests struct {
+	// DefaultTimeout is deadline of gRPC requests sent without one.
+	DefaultTimeout time.Duration `yaml:"default_timeout"`
+	// MaxTimeout caps deadlines of gRPC requests.
+	MaxTimeout time.Duration `yaml:"max_timeout"`
+	// MaxStreamDuration limits duration of streams such as Health.Watch.
+	MaxStreamDuration time.Duration `yaml:"max_stream_duration"`
+	// MaxSize limits size of gRPC messages and HTTP bodies in bytes.
+	MaxSize int `yaml:"max_size"`
+	// AccessLog logs every request.
+	AccessLog bool `yaml:"access_log"`
+}
+
+type Health struct {
+	// CheckTimeout limits a single dependency check.
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// CacheTTL is how long results of dependency checks are reused.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+type Metrics struct {
+	// Enabled serves Prometheus metrics on /metrics of the HTTP server.
+	Enabled bool `yaml:"enabled"`
+}
+
+type Tracing struct {
+	// Exporter is none, stdout or otlp.
+	Exporter string `yaml:"exporter"`
+	// OTLPEndpoint is host:port of the OTLP gRPC collector.
+	OTLPEndpoint string `yaml:"otlp_endpoint"`
+	// OTLPInsecure connects to the collector without TLS.
+	OTLPInsecure bool `yaml:"otlp_insecure"`
+	// SampleRatio is fraction of traces started by the service which are sampled. Sampling
+	// decisions of callers are respected.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type TLS struct {
+	// CertFile and KeyFile enable TLS when both are set.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile enables verification of client certificates.
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// Enabled reports whether TLS is configured.
+func (t *TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type Purge struct {
+	// Retention is how long deleted users are kept before they are purged, 0 disables purging.
+	Retention time.Duration `yaml:"retention"`
+	// Interval is how often deleted users are purged.
+	Interval time.Duration `yaml:"interval"`
+}
+
+type Auth struct {
+	// Issuer is the iss claim of access tokens.
+	Issuer string `yaml:"issuer"`
+	// AccessTokenTTL and RefreshTokenTTL are lifetimes of issued tokens.
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// SigningAlgorithm of generated keys is EdDSA or RS256.
+	SigningAlgorithm string `yaml:"signing_algorithm"`
+	// KeyRotation is how often a new signing key is generated, 0 disables rotation.
+	KeyRotation time.Duration `yaml:"key_rotation"`
+	// KeysDir contains PEM private keys which are used instead of generated keys, e.g. to share
+	// them between replicas.
+	KeysDir string `yaml:"keys_dir"`
+	// APIKeysFile contains names and SHA-256 hashes of API keys which authenticate admins.
+	APIKeysFile string `yaml:"api_keys_file"`
+}
+
+// Default returns configuration which is used for settings that aren't set.
+func Default() *Config {
+	return &Config{
+		GRPCAddr: ":9000",
+		HTTPAddr: ":9001",
+		DB: DB{
+			Driver:         "postgres",
+			MaxIdleConns:   2,
+			ConnectTimeout: 30 * time.Second,
+		},
+		BcryptCost: bcrypt.DefaultCost,
+		Timeouts: Timeouts{
+			Shutdown:  10 * time.Second,
+			HTTPRead:  10 * time.Second,
+			HTTPWrite: 30 * time.Second,
+			HTTPIdle:  2 * time.Minute,
+		},
+		Requests: Requests{
+			DefaultTimeout:    30 * time.Second,
+			MaxTimeout:        2 * time.Minute,
+			MaxStreamDuration: time.Hour,
+			MaxSize:           4 << 20,
+			AccessLog:         true,
+		},
+		Health: Health{
+			CheckTimeout: 2 * time.Second,
+			CacheTTL:     5 * time.Second,
+		},
+		Metrics: Metrics{Enabled: true},
+		Tracing: Tracing{
+			Exporter:     "none",
+			OTLPEndpoint: "localhost:4317",
+			SampleRatio:  1,
+		},
+		LogLevel:  "info",
+		LogFormat: "json",
+		Purge: Purge{
+			Retention: 30 * 24 * time.Hour,
+			Interval:  time.Hour,
+		},
+		Auth: Auth{
+			Issuer:           "userservice",
+			AccessTokenTTL:   15 * time.Minute,
+			RefreshTokenTTL:  30 * 24 * time.Hour,
+			SigningAlgorithm: "EdDSA",
+			KeyRotation:      24 * time.Hour,
+		},
+	}
+}
+
+// DefaultDSN returns connection string of the local development database of driver.
+func DefaultDSN(driver string) string {
+	switch driver {
+	case "postgres":
+		return "host=localhost user=user password=password dbname=database sslmode=disable"
+	case "sqlite":
+		return "users.db?_pragma=busy_timeout(5000)"
+	}
+
+	return ""
+}
+
+type setting struct {
+	// key is path of the setting in the config file.
+	key   string
+	usage string
+	field func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"grpc_addr", "gRPC listen address", func(c *Config) interface{} { return &c.GRPCAddr }},
+	{"http_addr", "HTTP gateway listen address", func(c *Config) interface{} { return &c.HTTPAddr }},
+	{"single_port", "serve gRPC and the HTTP gateway on grpc_addr", func(c *Config) interface{} { return &c.SinglePort }},
+	{"in_process_gateway", "connect the HTTP gateway to gRPC in memory instead of over grpc_addr", func(c *Config) interface{} { return &c.InProcessGateway }},
+	{"grpc_reflection", "enable gRPC server reflection", func(c *Config) interface{} { return &c.GRPCReflection }},
+	{"db.driver", "storage backend, postgres or sqlite", func(c *Config) interface{} { return &c.DB.Driver }},
+	{"db.dsn", "database connection string, defaults to the local development database", func(c *Config) interface{} { return &c.DB.DSN }},
+	{"db.max_open_conns", "maximum number of open database connections, 0 is unlimited", func(c *Config) interface{} { return &c.DB.MaxOpenConns }},
+	{"db.max_idle_conns", "maximum number of idle database connections", func(c *Config) interface{} { return &c.DB.MaxIdleConns }},
+	{"db.conn_max_lifetime", "maximum lifetime of a database connection, 0 is unlimited", func(c *Config) interface{} { return &c.DB.ConnMaxLifetime }},
+	{"db.conn_max_idle_time", "maximum idle time of a database connection, 0 is unlimited", func(c *Config) interface{} { return &c.DB.ConnMaxIdleTime }},
+	{"db.connect_timeout", "how long database is waited for on startup", func(c *Config) interface{} { return &c.DB.ConnectTimeout }},
+	{"check_schema", "refuse to start if database has pending migrations", func(c *Config) interface{} { return &c.CheckSchema }},
+	{"bcrypt_cost", "bcrypt cost of password hashes", func(c *Config) interface{} { return &c.BcryptCost }},
+	{"timeouts.shutdown", "how long in-flight requests are waited for on shutdown", func(c *Config) interface{} { return &c.Timeouts.Shutdown }},
+	{"timeouts.http_read", "HTTP server read timeout", func(c *Config) interface{} { return &c.Timeouts.HTTPRead }},
+	{"timeouts.http_write", "HTTP server write timeout", func(c *Config) interface{} { return &c.Timeouts.HTTPWrite }},
+	{"timeouts.http_idle", "HTTP server keep-alive idle timeout", func(c *Config) interface{} { return &c.Timeouts.HTTPIdle }},
+	{"requests.default_timeout", "deadline of gRPC requests sent without one, 0 disables it", func(c *Config) interface{} { return &c.Requests.DefaultTimeout }},
+	{"requests.max_timeout", "maximum deadline of gRPC requests, 0 is unlimited", func(c *Config) interface{} { return &c.Requests.MaxTimeout }},
+	{"requests.max_stream_duration", "maximum duration of gRPC streams, 0 is unlimited", func(c *Config) interface{} { return &c.Requests.MaxStreamDuration }},
+	{"requests.max_size", "maximum size of gRPC messages and HTTP bodies in bytes", func(c *Config) interface{} { return &c.Requests.MaxSize }},
+	{"requests.access_log", "log every request", func(c *Config) interface{} { return &c.Requests.AccessLog }},
+	{"health.check_timeout", "timeout of a single dependency health check", func(c *Config) interface{} { return &c.Health.CheckTimeout }},
+	{"health.cache_ttl", "how long results of dependency health checks are reused", func(c *Config) interface{} { return &c.Health.CacheTTL }},
+	{"metrics.enabled", "serve Prometheus metrics on /metrics of the HTTP server", func(c *Config) interface{} { return &c.Metrics.Enabled }},
+	{"tracing.exporter", "trace exporter, none, stdout or otlp", func(c *Config) interface{} { return &c.Tracing.Exporter }},
+	{"tracing.otlp_endpoint", "host:port of the OTLP gRPC trace collector", func(c *Config) interface{} { return &c.Tracing.OTLPEndpoint }},
+	{"tracing.otlp_insecure", "connect to the OTLP collector without TLS", func(c *Config) interface{} { return &c.Tracing.OTLPInsecure }},
+	{"tracing.sample_ratio", "fraction of traces started by the service which are sampled", func(c *Config) interface{} { return &c.Tracing.SampleRatio }},
+	{"tls.cert_file", "TLS certificate file", func(c *Config) interface{} { return &c.TLS.CertFile }},
+	{"tls.key_file", "TLS private key file", func(c *Config) interface{} { return &c.TLS.KeyFile }},
+	{"tls.client_ca_file", "CA certificates verifying client certificates", func(c *Config) interface{} { return &c.TLS.ClientCAFile }},
+	{"log_level", "log level, one of debug, info, warn and error", func(c *Config) interface{} { return &c.LogLevel }},
+	{"log_format", "log format, json or text", func(c *Config) interface{} { return &c.LogFormat }},
+	{"purge.retention", "how long deleted users are kept before they are purged, 0 disables purging", func(c *Config) interface{} { return &c.Purge.Retention }},
+	{"purge.interval", "how often deleted users are purged", func(c *Config) interface{} { return &c.Purge.Interval }},
+	{"auth.issuer", "iss claim of access tokens", func(c *Config) interface{} { return &c.Auth.Issuer }},
+	{"auth.access_token_ttl", "lifetime of access tokens", func(c *Config) interface{} { return &c.Auth.AccessTokenTTL }},
+	{"auth.refresh_token_ttl", "lifetime of unused refresh tokens", func(c *Config) interface{} { return &c.Auth.RefreshTokenTTL }},
+	{"auth.signing_algorithm", "algorithm of generated signing keys, EdDSA or RS256", func(c *Config) interface{} { return &c.Auth.SigningAlgorithm }},
+	{"auth.key_rotation", "how often a new signing key is generated, 0 disables rotation", func(c *Config) interface{} { return &c.Auth.KeyRotation }},
+	{"auth.keys_dir", "directory of PEM signing keys used instead of generated keys", func(c *Config) interface{} { return &c.Auth.KeysDir }},
+	{"auth.api_keys_file", "file with names and SHA-256 hashes of admin API keys", func(c *Config) interface{} { return &c.Auth.APIKeysFile }},
+}
+
+func (s *setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.key)
+}
+
+func (s *setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+func setField(field interface{}, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*f = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*f = v
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*f = v
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*f = v
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+
+	return nil
+}
+
+func formatField(field interface{}) string {
+	switch f := field.(type) {
+	case *string:
+		return *f
+	case *int:
+		return strconv.Itoa(*f)
+	case *bool:
+		return strconv.FormatBool(*f)
+	case *float64:
+		return strconv.FormatFloat(*f, 'g', -1, 64)
+	case *time.Duration:
+		return f.String()
+	}
+
+	return ""
+}
+
+// flagValue is flag.Value of a setting. It only checks and keeps the raw value, so that flags
+// can be applied after the config file and environment.
+type flagValue struct {
+	setting *setting
+	value   string
+	set     bool
+}
+
+func (v *flagValue) String() string {
+	if v.setting == nil {
+		return ""
+	}
+
+	return v.value
+}
+
+func (v *flagValue) Set(value string) error {
+	if err := setField(v.setting.field(&Config{}), value); err != nil {
+		return err
+	}
+
+	v.value = value
+	v.set = true
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	_, ok := v.setting.field(&Config{}).(*bool)
+	return ok
+}
+
+// Load registers flags of all settings and the -config flag in fs, parses args and returns
+// validated configuration. Callers can register their own flags in fs before.
+func Load(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
+	defaults := Default()
+
+	configFile := fs.String("config", getenv(ConfigFileEnv), "path of YAML config file (env "+ConfigFileEnv+")")
+	flagValues := make([]*flagValue, 0, len(settings))
+	for i := range settings {
+		s := &settings[i]
+		v := &flagValue{setting: s, value: formatField(s.field(defaults))}
+		fs.Var(v, s.flagName(), fmt.Sprintf("%s (env %s)", s.usage, s.envName()))
+		flagValues = append(flagValues, v)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := defaults
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.envName()); v != "" {
+			if err := setField(s.field(c), v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.envName(), err)
+			}
+		}
+	}
+
+	for _, v := range flagValues {
+		if v.set {
+			if err := setField(v.setting.field(c), v.value); err != nil {
+				return nil, fmt.Errorf("-%s: %w", v.setting.flagName(), err)
+			}
+		}
+	}
+
+	if c.DB.DSN == "" {
+		c.DB.DSN = DefaultDSN(c.DB.Driver)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// Validate returns error which lists all invalid settings.
+func (c *Config) Validate() error {
+	problems := []string{}
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.GRPCAddr == "" {
+		add("grpc_addr is required")
+	}
+	if c.HTTPAddr == "" && !c.SinglePort {
+		add("http_addr is required")
+	}
+
+	if c.DB.Driver != "postgres" && c.DB.Driver != "sqlite" {
+		add("db.driver must be postgres or sqlite")
+	}
+	if c.DB.DSN == "" {
+		add("db.dsn is required")
+	}
+	if c.DB.MaxOpenConns < 0 || c.DB.MaxIdleConns < 0 {
+		add("db connection limits can't be negative")
+	}
+	if c.DB.ConnMaxLifetime < 0 || c.DB.ConnMaxIdleTime < 0 {
+		add("db connection times can't be negative")
+	}
+	if c.DB.ConnectTimeout <= 0 {
+		add("db.connect_timeout must be positive")
+	}
+
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		add("bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	if c.Timeouts.Shutdown < 0 || c.Timeouts.HTTPRead < 0 || c.Timeouts.HTTPWrite < 0 || c.Timeouts.HTTPIdle < 0 {
+		add("timeouts can't be negative")
+	}
+
+	if c.Health.CheckTimeout <= 0 || c.Health.CacheTTL < 0 {
+		add("health.check_timeout must be positive and health.cache_ttl can't be negative")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.OTLPEndpoint == "" {
+			add("tracing.otlp_endpoint is required by the otlp exporter")
+		}
+	default:
+		add("tracing.exporter must be none, stdout or otlp")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio must be between 0 and 1")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		add("tls.cert_file and tls.key_file must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		add("tls.client_ca_file requires tls.cert_file and tls.key_file")
+	}
+
+	if c.Requests.DefaultTimeout < 0 || c.Requests.MaxTimeout < 0 || c.Requests.MaxStreamDuration < 0 {
+		add("request timeouts can't be negative")
+	}
+	if c.Requests.MaxTimeout > 0 && c.Requests.DefaultTimeout > c.Requests.MaxTimeout {
+		add("requests.default_timeout can't be longer than requests.max_timeout")
+	}
+	if c.Requests.MaxSize <= 0 {
+		add("requests.max_size must be positive")
+	}
+
+	validLevel := false
+	for _, l := range logLevels {
+		validLevel = validLevel || c.LogLevel == l
+	}
+	if !validLevel {
+		add("log_level must be one of %s", strings.Join(logLevels, ", "))
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		add("log_format must be json or text")
+	}
+
+	if c.Purge.Retention < 0 {
+		add("purge.retention can't be negative")
+	}
+	if c.Purge.Retention > 0 && c.Purge.Interval <= 0 {
+		add("purge.interval must be positive")
+	}
+
+	if c.Auth.Issuer == "" {
+		add("auth.issuer is required")
+	}
+	if c.Auth.AccessTokenTTL <= 0 || c.Auth.RefreshTokenTTL <= 0 {
+		add("auth token lifetimes must be positive")
+	}
+	if c.Auth.SigningAlgorithm != "EdDSA" && c.Auth.SigningAlgorithm != "RS256" {
+		add("auth.signing_algorithm must be EdDSA or RS256")
+	}
+	if c.Auth.KeyRotation < 0 {
+		add("auth.key_rotation can't be negative")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(\\.|[^'])*'|\S+)`)
+
+// redactDSN hides password in a postgres URL or key=value connection string.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+
+		return u.String()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// Redacted returns copy of the configuration with secrets replaced, which is safe to print.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.DB.DSN = redactDSN(c.DB.DSN)
+
+	return &r
+}
+
+// WriteYAML writes configuration in the config file format.
+func (c *Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+
+	return enc.Close()
+}
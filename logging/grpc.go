@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// incomingRequestID returns request ID from incoming metadata or generates a new one.
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+
+	return uuid.NewString()
+}
+
+// codeLevel returns level of access log records of requests which finished with code. Server
+// errors are logged as errors, client errors are part of normal operation. Internal errors are
+// warnings since their cause is already logged as an error where they are returned, so that
+// every error is logged once.
+func codeLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.Unknown, codes.DataLoss, codes.Unimplemented:
+		return slog.LevelError
+	case codes.Internal, codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return slog.LevelWarn
+	}
+
+	return slog.LevelInfo
+}
+
+// Interceptor assigns request ID to gRPC requests, returns it in the x-request-id header and
+// logs method, status code and latency of every request. Zero value logs with slog.Default.
+type Interceptor struct {
+	Logger *slog.Logger
+	// DisableAccessLog disables logging of requests, request IDs are assigned regardless.
+	DisableAccessLog bool
+}
+
+func (i *Interceptor) logRequest(ctx context.Context, start time.Time, err error) {
+	if i.DisableAccessLog {
+		return
+	}
+
+	code := status.Code(err)
+	FromContext(ctx, i.Logger).LogAttrs(
+		ctx,
+		codeLevel(code),
+		"grpc request",
+		slog.String("code", code.String()),
+		slog.Duration("latency", time.Since(start)),
+	)
+}
+
+// Unary is grpc.UnaryServerInterceptor.
+func (i *Interceptor) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := incomingRequestID(ctx)
+	ctx = withRequest(ctx, &request{id: id, method: info.FullMethod})
+	grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	i.logRequest(ctx, start, err)
+
+	return resp, err
+}
+
+// requestStream overrides context of the stream with one carrying the request.
+type requestStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestStream) Context() context.Context {
+	return s.ctx
+}
+
+// Stream is grpc.StreamServerInterceptor. Streams are logged when they finish.
+func (i *Interceptor) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id := incomingRequestID(ss.Context())
+	ctx := withRequest(ss.Context(), &request{id: id, method: info.FullMethod})
+	ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+
+	start := time.Now()
+	err := handler(srv, &requestStream{ServerStream: ss, ctx: ctx})
+	i.logRequest(ctx, start, err)
+
+	return err
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+// statusRecorder records status code written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// statusLevel is codeLevel of HTTP status codes. Server errors are warnings since the gateway
+// returns them for gRPC requests which are logged by Interceptor.
+func statusLevel(status int) slog.Level {
+	if status >= http.StatusInternalServerError {
+		return slog.LevelWarn
+	}
+
+	return slog.LevelInfo
+}
+
+// Middleware assigns request ID to HTTP requests and logs method, path, status code and latency
+// of every request. The ID is set in the X-Request-Id request header, so that the gateway
+// forwards it to gRPC, and it is returned in the response. slog.Default is used if logger is nil.
+// disableAccessLog disables logging of requests, request IDs are assigned regardless.
+func Middleware(logger *slog.Logger, disableAccessLog bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = uuid.NewString()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := withRequest(r.Context(), &request{id: id})
+		rec := &statusRecorder{ResponseWriter: w}
+
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if disableAccessLog {
+			return
+		}
+
+		FromContext(ctx, logger).LogAttrs(
+			ctx,
+			statusLevel(rec.status),
+			"http request",
+			slog.String("http_method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+		)
+	})
+}
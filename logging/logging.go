@@ -0,0 +1,89 @@
+// logging package configures structured logging and correlates log records of a single request.
+//
+// Every gRPC request and every request to the HTTP gateway gets a request ID, which is taken
+// from the x-request-id header or generated. The ID is forwarded from the gateway to gRPC, so
+// that records of both have the same request_id field. Loggers returned by FromContext add the
+// request ID, the gRPC method and the trace ID of a sampled trace to every record.
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+)
+
+// RequestIDHeader is the header, and the gRPC metadata key, which carries the request ID.
+const RequestIDHeader = "x-request-id"
+
+// Formats of log records.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New returns logger writing records of level and above to w. Level is one of debug, info, warn
+// and error, format is FormatJSON or FormatText (logfmt).
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+
+	opts := slog.HandlerOptions{Level: l}
+	switch format {
+	case FormatJSON:
+		return slog.New(opts.NewJSONHandler(w)), nil
+	case FormatText:
+		return slog.New(opts.NewTextHandler(w)), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+type requestKey struct{}
+
+type request struct {
+	id     string
+	method string
+}
+
+func withRequest(ctx context.Context, r *request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// RequestID returns ID of the request handled in ctx, it is empty outside of requests.
+func RequestID(ctx context.Context) string {
+	if r, ok := ctx.Value(requestKey{}).(*request); ok {
+		return r.id
+	}
+
+	return ""
+}
+
+// FromContext returns logger which adds fields of the request handled in ctx to records.
+// slog.Default is used if logger is nil.
+func FromContext(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	var args []interface{}
+	if r, ok := ctx.Value(requestKey{}).(*request); ok {
+		args = append(args, "request_id", r.id)
+		if r.method != "" {
+			args = append(args, "method", r.method)
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		args = append(args, "trace_id", sc.TraceID().String())
+	}
+
+	if len(args) == 0 {
+		return logger
+	}
+	return logger.With(args...)
+}
@@ -0,0 +1,219 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/toncek345/userservice/logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  string
+		format string
+		err    bool
+	}{
+		{name: "json", level: "info", format: "json"},
+		{name: "text", level: "debug", format: "text"},
+		{name: "invalid level", level: "verbose", format: "json", err: true},
+		{name: "invalid format", level: "info", format: "xml", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := logging.New(&bytes.Buffer{}, test.level, test.format)
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// records decodes JSON log records written to buf.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	recs := []map[string]interface{}{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		rec := map[string]interface{}{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("decoding log record: %s", err)
+		}
+		recs = append(recs, rec)
+	}
+
+	return recs
+}
+
+// transportStream records headers set by the interceptor.
+type transportStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *transportStream) Method() string {
+	return "/users.Users/GetUser"
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestInterceptorUnary(t *testing.T) {
+	tests := []struct {
+		name      string
+		md        metadata.MD
+		err       error
+		requestID string
+		level     string
+		code      string
+	}{
+		{
+			name:      "request id from metadata",
+			md:        metadata.Pairs(logging.RequestIDHeader, "abc"),
+			requestID: "abc",
+			level:     "INFO",
+			code:      "OK",
+		},
+		{
+			name:  "generated request id",
+			err:   status.Error(codes.NotFound, "not found"),
+			level: "INFO",
+			code:  "NotFound",
+		},
+		{
+			name:  "internal error",
+			err:   status.Error(codes.Internal, "internal error"),
+			level: "WARN",
+			code:  "Internal",
+		},
+		{
+			name:  "unknown error",
+			err:   errors.New("unknown"),
+			level: "ERROR",
+			code:  "Unknown",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			logger, _ := logging.New(buf, "info", "json")
+
+			ts := &transportStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), ts)
+			if test.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, test.md)
+			}
+
+			var handlerID string
+			interceptor := &logging.Interceptor{Logger: logger}
+			_, err := interceptor.Unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/users.Users/GetUser"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerID = logging.RequestID(ctx)
+				return nil, test.err
+			})
+			if err != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if handlerID == "" || (test.requestID != "" && handlerID != test.requestID) {
+				t.Fatalf("unexpected request id %q", handlerID)
+			}
+			if ids := ts.header.Get(logging.RequestIDHeader); len(ids) != 1 || ids[0] != handlerID {
+				t.Fatalf("expected request id header %q, got %v", handlerID, ids)
+			}
+
+			recs := records(t, buf)
+			if len(recs) != 1 {
+				t.Fatalf("expected single record, got %v", recs)
+			}
+			rec := recs[0]
+			if rec["level"] != test.level || rec["code"] != test.code || rec["request_id"] != handlerID ||
+				rec["method"] != "/users.Users/GetUser" || rec["latency"] == nil {
+				t.Fatalf("unexpected record: %v", rec)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := logging.New(buf, "info", "json")
+
+	var forwardedID, contextID string
+	handler := logging.Middleware(logger, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedID = r.Header.Get(logging.RequestIDHeader)
+		contextID = logging.RequestID(r.Context())
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	id := rec.Header().Get(logging.RequestIDHeader)
+	if id == "" || forwardedID != id || contextID != id {
+		t.Fatalf("request id isn't propagated: response %q, header %q, context %q", id, forwardedID, contextID)
+	}
+
+	recs := records(t, buf)
+	if len(recs) != 1 {
+		t.Fatalf("expected single record, got %v", recs)
+	}
+	if recs[0]["request_id"] != id || recs[0]["status"] != float64(http.StatusNotFound) || recs[0]["path"] != "/users/1" {
+		t.Fatalf("unexpected record: %v", recs[0])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set(logging.RequestIDHeader, "abc")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if id := rec.Header().Get(logging.RequestIDHeader); id != "abc" || forwardedID != "abc" {
+		t.Fatalf("request id from header isn't used: %q", id)
+	}
+}
+
+func TestMiddlewareServerError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := logging.New(buf, "info", "json")
+
+	// The gRPC request behind the gateway already logged the error.
+	handler := logging.Middleware(logger, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	recs := records(t, buf)
+	if len(recs) != 1 || recs[0]["level"] != "WARN" {
+		t.Fatalf("expected single warning, got %v", recs)
+	}
+}
+
+func TestMiddlewareDisableAccessLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := logging.New(buf, "info", "json")
+
+	handler := logging.Middleware(logger, true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	if rec.Header().Get(logging.RequestIDHeader) == "" {
+		t.Fatal("request id isn't assigned")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("request is logged: %s", buf)
+	}
+}
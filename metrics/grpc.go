@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// splitMethod returns service and method of full gRPC method name, e.g. /users.Users/GetUser.
+func splitMethod(fullMethod string) (string, string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "unknown", "unknown"
+	}
+
+	return service, method
+}
+
+func (m *Metrics) observeRequest(typ, fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+	code := status.Code(err).String()
+
+	m.grpcHandled.WithLabelValues(typ, service, method, code).Inc()
+	m.grpcDuration.WithLabelValues(typ, service, method, code).Observe(time.Since(start).Seconds())
+}
+
+// Unary is grpc.UnaryServerInterceptor which counts and times requests by method and code.
+func (m *Metrics) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	m.observeRequest("unary", info.FullMethod, start, err)
+
+	return resp, err
+}
+
+// Stream is grpc.StreamServerInterceptor which counts and times streams when they finish.
+func (m *Metrics) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	typ := "server_stream"
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		typ = "bidi_stream"
+	case info.IsClientStream:
+		typ = "client_stream"
+	}
+
+	start := time.Now()
+	err := handler(srv, ss)
+	m.observeRequest(typ, info.FullMethod, start, err)
+
+	return err
+}
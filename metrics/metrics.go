@@ -0,0 +1,111 @@
+// metrics package collects Prometheus metrics of the service.
+//
+// gRPC requests are counted and timed by interceptors, storage operations and business events by
+// wrappers of storage.UserStorage and service.UserService, so that the wrapped implementations
+// don't depend on Prometheus. Metrics are exposed by Handler in the text exposition format.
+
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes names of metrics of the service. gRPC metrics use the names common to gRPC
+// servers instead.
+const Namespace = "userservice"
+
+// Metrics holds collectors of the service registered in their own registry.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	grpcHandled  *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+
+	// PasswordHashDuration is passed to service.UserServiceImpl.
+	PasswordHashDuration prometheus.Histogram
+	usersCreated         prometheus.Counter
+	usersUpdated         prometheus.Counter
+	usersDeleted         prometheus.Counter
+}
+
+// New returns metrics registered in a new registry together with Go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		grpcHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "Number of gRPC requests completed on the server.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Duration of gRPC requests handled by the server.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Duration of user storage operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "storage_errors_total",
+			Help:      "Number of user storage operations which failed unexpectedly.",
+		}, []string{"operation"}),
+		PasswordHashDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "password_hash_duration_seconds",
+			Help:      "Duration of bcrypt hashing and comparing of passwords.",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}),
+		usersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "users_created_total",
+			Help:      "Number of created users.",
+		}),
+		usersUpdated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "users_updated_total",
+			Help:      "Number of user updates, including partial ones.",
+		}),
+		usersDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "users_deleted_total",
+			Help:      "Number of deleted users.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.grpcHandled,
+		m.grpcDuration,
+		m.storageDuration,
+		m.storageErrors,
+		m.PasswordHashDuration,
+		m.usersCreated,
+		m.usersUpdated,
+		m.usersDeleted,
+	)
+
+	return m
+}
+
+// RegisterDB registers gauges of the connection pool of db, such as open, in use and idle
+// connections and waits for a connection.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
@@ -0,0 +1,128 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toncek345/userservice/metrics"
+	"github.com/toncek345/userservice/service"
+	"github.com/toncek345/userservice/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scrape returns metrics served by the handler.
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("reading metrics: %s", err)
+	}
+
+	return string(body)
+}
+
+func expectMetrics(t *testing.T, m *metrics.Metrics, lines ...string) {
+	t.Helper()
+
+	body := scrape(t, m)
+	for _, l := range lines {
+		if !strings.Contains(body, l+"\n") {
+			t.Fatalf("metric %q not found in:\n%s", l, body)
+		}
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	m := metrics.New()
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/users.Users/GetUser"}
+	m.Unary(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+	m.Unary(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	m.Stream(nil, nil, &grpc.StreamServerInfo{FullMethod: "/health.Health/Watch", IsServerStream: true}, func(srv interface{}, ss grpc.ServerStream) error {
+		return nil
+	})
+
+	expectMetrics(t, m,
+		`grpc_server_handled_total{grpc_code="OK",grpc_method="GetUser",grpc_service="users.Users",grpc_type="unary"} 1`,
+		`grpc_server_handled_total{grpc_code="NotFound",grpc_method="GetUser",grpc_service="users.Users",grpc_type="unary"} 1`,
+		`grpc_server_handled_total{grpc_code="OK",grpc_method="Watch",grpc_service="health.Health",grpc_type="server_stream"} 1`,
+		`grpc_server_handling_seconds_count{grpc_code="OK",grpc_method="GetUser",grpc_service="users.Users",grpc_type="unary"} 1`,
+	)
+}
+
+func TestUserStorage(t *testing.T) {
+	m := metrics.New()
+	s := &metrics.UserStorage{
+		Metrics: m,
+		UserStorage: &storage.MockUser{
+			GetUserFn: func(ctx context.Context, id string) (*storage.UserModel, error) {
+				switch id {
+				case "missing":
+					return nil, storage.ErrNotFound
+				case "broken":
+					return nil, errors.New("connection reset")
+				}
+				return &storage.UserModel{ID: id}, nil
+			},
+		},
+	}
+
+	for _, id := range []string{"1", "missing", "broken"} {
+		s.GetUser(context.Background(), id)
+	}
+
+	expectMetrics(t, m,
+		`userservice_storage_operation_duration_seconds_count{operation="get_user"} 3`,
+		`userservice_storage_errors_total{operation="get_user"} 1`,
+	)
+}
+
+func TestUserService(t *testing.T) {
+	m := metrics.New()
+	s := &metrics.UserService{
+		Metrics: m,
+		UserService: &service.UsersMock{
+			AddUserFn: func(ctx context.Context, user *service.AddUser) (*service.User, error) {
+				if user.Email == "taken@example.com" {
+					return nil, storage.ErrAlreadyExists
+				}
+				return &service.User{}, nil
+			},
+			UpdateUserFn: func(ctx context.Context, user *service.UpdateUser) (*service.User, error) {
+				return &service.User{}, nil
+			},
+			PatchUserFn: func(ctx context.Context, user *service.PatchUser) (*service.User, error) {
+				return &service.User{}, nil
+			},
+			DeleteUserFn: func(ctx context.Context, id string, expectedVersion int64) error {
+				return nil
+			},
+		},
+	}
+
+	s.AddUser(context.Background(), &service.AddUser{Email: "a@example.com"})
+	s.AddUser(context.Background(), &service.AddUser{Email: "taken@example.com"})
+	s.UpdateUser(context.Background(), &service.UpdateUser{})
+	s.PatchUser(context.Background(), &service.PatchUser{})
+	s.DeleteUser(context.Background(), "1", 0)
+
+	expectMetrics(t, m,
+		"userservice_users_created_total 1",
+		"userservice_users_updated_total 2",
+		"userservice_users_deleted_total 1",
+	)
+}
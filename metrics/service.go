@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/toncek345/userservice/service"
+)
+
+var _ service.UserService = (*UserService)(nil)
+
+// UserService counts users created, updated and deleted by the wrapped service. Only successful
+// changes are counted.
+type UserService struct {
+	service.UserService
+	Metrics *Metrics
+}
+
+func (s *UserService) AddUser(ctx context.Context, user *service.AddUser) (*service.User, error) {
+	u, err := s.UserService.AddUser(ctx, user)
+	if err == nil {
+		s.Metrics.usersCreated.Inc()
+	}
+
+	return u, err
+}
+
+func (s *UserService) UpdateUser(ctx context.Context, user *service.UpdateUser) (*service.User, error) {
+	u, err := s.UserService.UpdateUser(ctx, user)
+	if err == nil {
+		s.Metrics.usersUpdated.Inc()
+	}
+
+	return u, err
+}
+
+func (s *UserService) PatchUser(ctx context.Context, user *service.PatchUser) (*service.User, error) {
+	u, err := s.UserService.PatchUser(ctx, user)
+	if err == nil {
+		s.Metrics.usersUpdated.Inc()
+	}
+
+	return u, err
+}
+
+func (s *UserService) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	err := s.UserService.DeleteUser(ctx, id, expectedVersion)
+	if err == nil {
+		s.Metrics.usersDeleted.Inc()
+	}
+
+	return err
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/toncek345/userservice/storage"
+)
+
+var _ storage.UserStorage = (*UserStorage)(nil)
+
+// UserStorage times operations of the wrapped storage and counts their unexpected errors.
+type UserStorage struct {
+	storage.UserStorage
+	Metrics *Metrics
+}
+
+// expectedError reports whether err is a result of the request rather than a storage failure.
+func expectedError(err error) bool {
+	return errors.Is(err, storage.ErrNotFound) ||
+		errors.Is(err, storage.ErrVersionMismatch) ||
+		errors.Is(err, storage.ErrAlreadyExists) ||
+		errors.Is(err, context.Canceled)
+}
+
+func (s *UserStorage) observe(operation string, start time.Time, err error) {
+	s.Metrics.storageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil && !expectedError(err) {
+		s.Metrics.storageErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (s *UserStorage) InsertUser(ctx context.Context, user *storage.InsertUser) (u *storage.UserModel, err error) {
+	defer func(start time.Time) { s.observe("insert_user", start, err) }(time.Now())
+
+	return s.UserStorage.InsertUser(ctx, user)
+}
+
+func (s *UserStorage) DeleteUser(ctx context.Context, id string, expectedVersion int64) (err error) {
+	defer func(start time.Time) { s.observe("delete_user", start, err) }(time.Now())
+
+	return s.UserStorage.DeleteUser(ctx, id, expectedVersion)
+}
+
+func (s *UserStorage) UndeleteUser(ctx context.Context, id string) (u *storage.UserModel, err error) {
+	defer func(start time.Time) { s.observe("undelete_user", start, err) }(time.Now())
+
+	return s.UserStorage.UndeleteUser(ctx, id)
+}
+
+func (s *UserStorage) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (n int64, err error) {
+	defer func(start time.Time) { s.observe("purge_deleted_users", start, err) }(time.Now())
+
+	return s.UserStorage.PurgeDeletedUsers(ctx, deletedBefore)
+}
+
+func (s *UserStorage) UpdateUser(ctx context.Context, user *storage.UpdateUser) (u *storage.UserModel, err error) {
+	defer func(start time.Time) { s.observe("update_user", start, err) }(time.Now())
+
+	return s.UserStorage.UpdateUser(ctx, user)
+}
+
+func (s *UserStorage) PatchUser(ctx context.Context, user *storage.PatchUser) (u *storage.UserModel, err error) {
+	defer func(start time.Time) { s.observe("patch_user", start, err) }(time.Now())
+
+	return s.UserStorage.PatchUser(ctx, user)
+}
+
+func (s *UserStorage) SearchUser(ctx context.Context, filters *storage.Filters, page *storage.Page) (u []*storage.UserModel, err error) {
+	defer func(start time.Time) { s.observe("search_user", start, err) }(time.Now())
+
+	return s.UserStorage.SearchUser(ctx, filters, page)
+}
+
+func (s *UserStorage) CountUsers(ctx context.Context, filters *storage.Filters) (n int64, err error) {
+	defer func(start time.Time) { s.observe("count_users", start, err) }(time.Now())
+
+	return s.UserStorage.CountUsers(ctx, filters)
+}
+
+func (s *UserStorage) GetUser(ctx context.Context, id string) (u *storage.UserModel, err error) {
+	defer func(start time.Time) { s.observe("get_user", start, err) }(time.Now())
+
+	return s.UserStorage.GetUser(ctx, id)
+}
+
+func (s *UserStorage) GetUserByEmail(ctx context.Context, email string) (u *storage.UserModel, err error) {
+	defer func(start time.Time) { s.observe("get_user_by_email", start, err) }(time.Now())
+
+	return s.UserStorage.GetUserByEmail(ctx, email)
+}
@@ -0,0 +1,365 @@
+// migrations package implements versioned schema migrations of the service database.
+//
+// Migrations are embedded into the binary from the sql directory for Postgres and from the
+// sqlite directory for SQLite. Every migration consists of two files named
+// <version>_<name>.up.sql and <version>_<name>.down.sql. Applied versions are tracked in the
+// schema_migrations table.
+
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/*.sql sqlite/*.sql
+var embedded embed.FS
+
+// ErrSchemaBehind is returned when database has migrations which are not applied yet.
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+// ErrUnknownVersion is returned when requested version doesn't exist.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Embedded returns Postgres migrations which are shipped with the service.
+func Embedded() ([]*Migration, error) {
+	return embeddedDir("sql")
+}
+
+// EmbeddedSQLite returns SQLite migrations which are shipped with the service. They have the
+// same versions as the Postgres ones, so both databases report the same schema version.
+func EmbeddedSQLite() ([]*Migration, error) {
+	return embeddedDir("sqlite")
+}
+
+// EmbeddedFor returns embedded migrations for the database/sql driver name.
+func EmbeddedFor(driver string) ([]*Migration, error) {
+	switch driver {
+	case "postgres":
+		return Embedded()
+	case "sqlite":
+		return EmbeddedSQLite()
+	}
+
+	return nil, fmt.Errorf("no migrations for driver %q", driver)
+}
+
+func embeddedDir(dir string) ([]*Migration, error) {
+	sub, err := fs.Sub(embedded, dir)
+	if err != nil {
+		return nil, fmt.Errorf("sub fs: %w", err)
+	}
+
+	return Load(sub)
+}
+
+// Load reads migrations from the root of fsys and returns them ordered by version.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading dir: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+
+		version, name, direction, err := parseFileName(e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", e.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("version %d has conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s is missing up or down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// parseFileName parses names in form of 0001_create_users.up.sql.
+func parseFileName(fileName string) (int64, string, string, error) {
+	base := strings.TrimSuffix(fileName, ".sql")
+
+	direction := path.Ext(base)
+	if direction != ".up" && direction != ".down" {
+		return 0, "", "", fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", fileName)
+	}
+	base = strings.TrimSuffix(base, direction)
+
+	versionStr, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return 0, "", "", fmt.Errorf("migration %s: expected <version>_<name> format", fileName)
+	}
+
+	version, err := strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", "", fmt.Errorf("migration %s: invalid version %q", fileName, versionStr)
+	}
+
+	return version, name, strings.TrimPrefix(direction, "."), nil
+}
+
+type Migrator struct {
+	DB *sqlx.DB
+	// Migrations which are managed by migrator. They have to be sorted by version.
+	Migrations []*Migration
+}
+
+// Status describes state of a single migration.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	if _, err := m.DB.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint primary key,
+		applied_at timestamp NOT NULL
+		)`); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows := []*appliedMigration{}
+	if err := m.DB.SelectContext(ctx, &rows, "SELECT version, applied_at FROM schema_migrations"); err != nil {
+		return nil, fmt.Errorf("selecting applied migrations: %w", err)
+	}
+
+	applied := make(map[int64]time.Time, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r.AppliedAt
+	}
+
+	return applied, nil
+}
+
+// Status returns state of all known migrations ordered by version.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*Status, 0, len(m.Migrations))
+	for _, mig := range m.Migrations {
+		appliedAt, ok := applied[mig.Version]
+		statuses = append(statuses, &Status{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// Version returns the highest applied version or 0 if nothing is applied.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	if len(m.Migrations) == 0 {
+		return nil
+	}
+
+	return m.To(ctx, m.Migrations[len(m.Migrations)-1].Version)
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.Migrations[i].Version]; ok {
+			return m.revert(ctx, m.Migrations[i])
+		}
+	}
+
+	return nil
+}
+
+// To migrates database up or down so that all migrations up to and including version are
+// applied and none after it. Version 0 reverts everything.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.Migrations) - 1; i >= 0; i-- {
+		mig := m.Migrations[i]
+		if _, ok := applied[mig.Version]; ok && mig.Version > version {
+			if err := m.revert(ctx, mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, mig := range m.Migrations {
+		if _, ok := applied[mig.Version]; !ok && mig.Version <= version {
+			if err := m.apply(ctx, mig); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckCurrent returns ErrSchemaBehind if some of the migrations aren't applied.
+func (m *Migrator) CheckCurrent(ctx context.Context) error {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range m.Migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			return fmt.Errorf("%w: migration %d_%s is pending", ErrSchemaBehind, mig.Version, mig.Name)
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) find(version int64) *Migration {
+	for _, mig := range m.Migrations {
+		if mig.Version == version {
+			return mig
+		}
+	}
+
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, mig *Migration) error {
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("applying %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO schema_migrations (version, applied_at) VALUES ($1, CURRENT_TIMESTAMP)",
+		mig.Version); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("recording %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Migrator) revert(ctx context.Context, mig *Migration) error {
+	tx, err := m.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("reverting %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", mig.Version); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("unrecording %d_%s: %w", mig.Version, mig.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/toncek345/userservice/migrations"
+	_ "modernc.org/sqlite"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		fs       fstest.MapFS
+		versions []int64
+		isError  bool
+	}{
+		{
+			name: "works",
+			fs: fstest.MapFS{
+				"0002_second.up.sql":   {Data: []byte("up 2")},
+				"0002_second.down.sql": {Data: []byte("down 2")},
+				"0001_first.up.sql":    {Data: []byte("up 1")},
+				"0001_first.down.sql":  {Data: []byte("down 1")},
+				"README.md":            {Data: []byte("ignored")},
+			},
+			versions: []int64{1, 2},
+		},
+		{
+			name: "missing down",
+			fs: fstest.MapFS{
+				"0001_first.up.sql": {Data: []byte("up 1")},
+			},
+			isError: true,
+		},
+		{
+			name: "bad direction",
+			fs: fstest.MapFS{
+				"0001_first.sideways.sql": {Data: []byte("up 1")},
+			},
+			isError: true,
+		},
+		{
+			name: "bad version",
+			fs: fstest.MapFS{
+				"first_one.up.sql":   {Data: []byte("up 1")},
+				"first_one.down.sql": {Data: []byte("down 1")},
+			},
+			isError: true,
+		},
+		{
+			name: "conflicting names",
+			fs: fstest.MapFS{
+				"0001_first.up.sql":   {Data: []byte("up 1")},
+				"0001_other.down.sql": {Data: []byte("down 1")},
+			},
+			isError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migs, err := migrations.Load(test.fs)
+			if err != nil {
+				if test.isError {
+					return
+				}
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.isError {
+				t.Fatal("expected error")
+			}
+
+			if len(migs) != len(test.versions) {
+				t.Fatalf("expected %d migrations, got %d", len(test.versions), len(migs))
+			}
+			for i, m := range migs {
+				if m.Version != test.versions[i] {
+					t.Fatalf("expected version %d, got %d", test.versions[i], m.Version)
+				}
+				if m.Up == "" || m.Down == "" {
+					t.Fatal("missing migration content")
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	migs, err := migrations.Embedded()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(migs) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+}
+
+func TestEmbeddedSQLite(t *testing.T) {
+	pg, err := migrations.Embedded()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	sqlite, err := migrations.EmbeddedSQLite()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(pg) != len(sqlite) {
+		t.Fatalf("expected %d sqlite migrations, got %d", len(pg), len(sqlite))
+	}
+	for i := range pg {
+		if pg[i].Version != sqlite[i].Version || pg[i].Name != sqlite[i].Name {
+			t.Fatalf("expected migration %d_%s, got %d_%s",
+				pg[i].Version, pg[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestMigratorSQLite(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	defer db.Close()
+	// Every connection has its own in-memory database.
+	db.SetMaxOpenConns(1)
+
+	migs, err := migrations.EmbeddedSQLite()
+	if err != nil {
+		t.Fatalf("loading migrations: %s", err)
+	}
+	m := &migrations.Migrator{DB: db, Migrations: migs}
+	latest := migs[len(migs)-1].Version
+
+	if err := m.CheckCurrent(ctx); !errors.Is(err, migrations.ErrSchemaBehind) {
+		t.Fatalf("expected schema behind, got: %v", err)
+	}
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatalf("up: %s", err)
+	}
+	if err := m.CheckCurrent(ctx); err != nil {
+		t.Fatalf("schema isn't current: %s", err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("status: %s", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Fatalf("migration %d isn't applied", s.Version)
+		}
+	}
+
+	if err := m.Down(ctx); err != nil {
+		t.Fatalf("down: %s", err)
+	}
+	if v, err := m.Version(ctx); err != nil || v != latest-1 {
+		t.Fatalf("expected version %d, got %d: %v", latest-1, v, err)
+	}
+
+	if err := m.To(ctx, 0); err != nil {
+		t.Fatalf("to 0: %s", err)
+	}
+	if err := m.To(ctx, latest); err != nil {
+		t.Fatalf("to %d: %s", latest, err)
+	}
+	if v, err := m.Version(ctx); err != nil || v != latest {
+		t.Fatalf("expected version %d, got %d: %v", latest, v, err)
+	}
+}
+
+func TestMigratorSQLiteNormalizesEmails(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		emails  []string
+		isError bool
+	}{
+		{
+			name:   "normalized",
+			emails: []string{" John@Example.com", "jane@example.com"},
+		},
+		{
+			name:    "duplicates",
+			emails:  []string{"john@example.com", "JOHN@example.com "},
+			isError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := sqlx.Open("sqlite", ":memory:")
+			if err != nil {
+				t.Fatalf("opening db: %s", err)
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+
+			migs, err := migrations.EmbeddedSQLite()
+			if err != nil {
+				t.Fatalf("loading migrations: %s", err)
+			}
+			m := &migrations.Migrator{DB: db, Migrations: migs}
+			if err := m.To(ctx, 1); err != nil {
+				t.Fatalf("to 1: %s", err)
+			}
+			for i, email := range test.emails {
+				if _, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", i, email); err != nil {
+					t.Fatalf("inserting user: %s", err)
+				}
+			}
+
+			err = m.To(ctx, 2)
+			if test.isError {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if v, err := m.Version(ctx); err != nil || v != 1 {
+					t.Fatalf("expected version 1, got %d: %v", v, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("to 2: %s", err)
+			}
+
+			var emails []string
+			if err := db.Select(&emails, "SELECT email FROM users ORDER BY id"); err != nil {
+				t.Fatalf("selecting emails: %s", err)
+			}
+			if len(emails) != 2 || emails[0] != "john@example.com" || emails[1] != "jane@example.com" {
+				t.Fatalf("unexpected emails: %v", emails)
+			}
+		})
+	}
+}
@@ -1,4 +1,6 @@
-CREATE TABLE users (
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
   id UUID primary key,
   first_name text,
   last_name text,
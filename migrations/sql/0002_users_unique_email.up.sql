@@ -0,0 +1,20 @@
+-- Emails are stored trimmed and in lower case, as the service normalizes them. Users whose
+-- emails differ only in case or surrounding whitespace can't be normalized automatically, the
+-- migration fails until they are resolved, see the upgrade notes in README.md.
+DO $$
+DECLARE
+  duplicates text;
+BEGIN
+  SELECT string_agg(email, ', ') INTO duplicates FROM (
+    SELECT lower(trim(email)) AS email FROM users GROUP BY lower(trim(email)) HAVING count(*) > 1
+    ) d;
+  IF duplicates IS NOT NULL THEN
+    RAISE EXCEPTION 'users have duplicate emails differing only in case or whitespace: %', duplicates
+      USING HINT = 'Change or delete the duplicate users, see upgrade notes in README.md.';
+  END IF;
+END
+$$;
+
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users (lower(email));
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS users_deleted_at_idx;
+
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS users_email_lower_idx;
+CREATE UNIQUE INDEX users_email_lower_idx ON users (lower(email));
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,11 @@
+-- IDs are generated by the service, timestamps are stored as UTC text which sorts chronologically.
+CREATE TABLE IF NOT EXISTS users (
+  id text primary key,
+  first_name text,
+  last_name text,
+  email text,
+  country text,
+  password text,
+  created_at timestamp,
+  updated_at timestamp
+  );
@@ -0,0 +1,16 @@
+-- Emails are stored trimmed and in lower case, as the service normalizes them. Users whose
+-- emails differ only in case or surrounding whitespace can't be normalized automatically, the
+-- migration fails until they are resolved, see the upgrade notes in README.md. SQLite can raise
+-- errors only from triggers, so duplicates are inserted into a table which refuses them.
+CREATE TEMP TABLE users_duplicate_emails (email text);
+CREATE TEMP TRIGGER users_duplicate_emails_abort BEFORE INSERT ON users_duplicate_emails
+BEGIN
+  SELECT RAISE(ABORT, 'users have duplicate emails differing only in case or whitespace, change or delete the duplicate users, see upgrade notes in README.md');
+END;
+INSERT INTO users_duplicate_emails
+  SELECT lower(trim(email)) FROM users GROUP BY lower(trim(email)) HAVING count(*) > 1;
+DROP TABLE users_duplicate_emails;
+
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON users (lower(email));
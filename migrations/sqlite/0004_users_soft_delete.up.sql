@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN deleted_at timestamp NULL;
+
+-- Deleted users don't block their email from being used again.
+DROP INDEX IF EXISTS users_email_lower_idx;
+CREATE UNIQUE INDEX users_email_lower_idx ON users (lower(email)) WHERE deleted_at IS NULL;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,23 @@
+-- Refresh tokens are stored as SHA-256 hashes. Tokens rotated from the same login share
+-- a family which is revoked as a whole when a used token is presented again. Rotation and
+-- revocation lock the row of the family, so a token rotated concurrently with revocation
+-- of its family is either revoked too or not stored at all.
+CREATE TABLE IF NOT EXISTS refresh_token_families (
+  id text primary key,
+  user_id text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at timestamp NOT NULL,
+  revoked_at timestamp NULL
+  );
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  token_hash text primary key,
+  family_id text NOT NULL REFERENCES refresh_token_families (id) ON DELETE CASCADE,
+  user_id text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  created_at timestamp NOT NULL,
+  expires_at timestamp NOT NULL,
+  used_at timestamp NULL,
+  revoked_at timestamp NULL
+  );
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
@@ -0,0 +1,81 @@
+// pagination package contains page size handling and opaque page tokens used for keyset
+// pagination.
+//
+// Page token encodes values of the sort columns of the last returned row together with the
+// sort order it was issued for. Next page is then fetched with a WHERE condition on those
+// values instead of an OFFSET, so it is fast on large tables and doesn't skip or duplicate rows
+// when data changes between requests.
+
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	DefaultPageSize = 5
+	MaxPageSize     = 100
+)
+
+// ErrInvalidToken is returned when page token can't be decoded or doesn't belong to the request.
+var ErrInvalidToken = errors.New("invalid page token")
+
+// PageSize returns size with defaults and limits applied.
+func PageSize(size int64) int64 {
+	if size <= 0 {
+		return DefaultPageSize
+	}
+	if size > MaxPageSize {
+		return MaxPageSize
+	}
+
+	return size
+}
+
+// Offset returns offset of 1-based page. Pages lower than 1 are treated as the first page.
+func Offset(page, size int64) int64 {
+	if page < 1 {
+		return 0
+	}
+
+	return (page - 1) * size
+}
+
+type Cursor struct {
+	// Order identifies sort order and filters the cursor was issued for.
+	Order string `json:"o"`
+	// Values of the sort columns of the last row of the previous page.
+	Values []string `json:"v"`
+}
+
+// EncodeCursor returns opaque page token of cursor.
+func EncodeCursor(c *Cursor) string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		// Cursor consists only of strings so marshaling can't fail.
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor decodes token made by EncodeCursor and checks that it was issued for order.
+func DecodeCursor(token, order string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	c := &Cursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if c.Order != order || len(c.Values) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return c, nil
+}
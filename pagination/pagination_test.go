@@ -0,0 +1,76 @@
+package pagination_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/toncek345/userservice/pagination"
+)
+
+func TestPageSize(t *testing.T) {
+	tests := []struct {
+		in, out int64
+	}{
+		{0, pagination.DefaultPageSize},
+		{-1, pagination.DefaultPageSize},
+		{10, 10},
+		{pagination.MaxPageSize + 1, pagination.MaxPageSize},
+	}
+
+	for _, test := range tests {
+		if got := pagination.PageSize(test.in); got != test.out {
+			t.Fatalf("page size %d: expected %d, got %d", test.in, test.out, got)
+		}
+	}
+}
+
+func TestOffset(t *testing.T) {
+	tests := []struct {
+		page, size, out int64
+	}{
+		{0, 5, 0},
+		{1, 5, 0},
+		{3, 5, 10},
+	}
+
+	for _, test := range tests {
+		if got := pagination.Offset(test.page, test.size); got != test.out {
+			t.Fatalf("offset of page %d: expected %d, got %d", test.page, test.out, got)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	token := pagination.EncodeCursor(&pagination.Cursor{Order: "order", Values: []string{"a", "b"}})
+
+	tests := []struct {
+		name  string
+		token string
+		order string
+		err   error
+	}{
+		{name: "works", token: token, order: "order"},
+		{name: "other order", token: token, order: "other", err: pagination.ErrInvalidToken},
+		{name: "garbage", token: "!!!", order: "order", err: pagination.ErrInvalidToken},
+		{name: "not json", token: "bm90IGpzb24", order: "order", err: pagination.ErrInvalidToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := pagination.DecodeCursor(test.token, test.order)
+			if err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got: %s", test.err, err)
+				}
+				return
+			}
+			if test.err != nil {
+				t.Fatal("expected error")
+			}
+
+			if len(c.Values) != 2 || c.Values[0] != "a" || c.Values[1] != "b" {
+				t.Fatalf("unexpected values: %v", c.Values)
+			}
+		})
+	}
+}
@@ -23,7 +23,15 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type HealthClient interface {
+	// Check fails with UNAVAILABLE if the service isn't ready.
 	Check(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Liveness reports whether the process works and shouldn't be restarted. HTTP gateway responds
+	// with 503 if it is down.
+	Liveness(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*HealthStatus, error)
+	// Readiness reports whether the service and its dependencies can handle requests. HTTP gateway
+	// responds with 503 if it is down.
+	Readiness(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*HealthStatus, error)
+	// Watch sends readiness when the stream starts and then whenever its aggregate status changes.
 	Watch(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Health_WatchClient, error)
 }
 
@@ -44,6 +52,24 @@ func (c *healthClient) Check(ctx context.Context, in *emptypb.Empty, opts ...grp
 	return out, nil
 }
 
+func (c *healthClient) Liveness(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*HealthStatus, error) {
+	out := new(HealthStatus)
+	err := c.cc.Invoke(ctx, "/health.Health/Liveness", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *healthClient) Readiness(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*HealthStatus, error) {
+	out := new(HealthStatus)
+	err := c.cc.Invoke(ctx, "/health.Health/Readiness", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *healthClient) Watch(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Health_WatchClient, error) {
 	stream, err := c.cc.NewStream(ctx, &Health_ServiceDesc.Streams[0], "/health.Health/Watch", opts...)
 	if err != nil {
@@ -60,7 +86,7 @@ func (c *healthClient) Watch(ctx context.Context, in *emptypb.Empty, opts ...grp
 }
 
 type Health_WatchClient interface {
-	Recv() (*emptypb.Empty, error)
+	Recv() (*HealthStatus, error)
 	grpc.ClientStream
 }
 
@@ -68,8 +94,8 @@ type healthWatchClient struct {
 	grpc.ClientStream
 }
 
-func (x *healthWatchClient) Recv() (*emptypb.Empty, error) {
-	m := new(emptypb.Empty)
+func (x *healthWatchClient) Recv() (*HealthStatus, error) {
+	m := new(HealthStatus)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -80,7 +106,15 @@ func (x *healthWatchClient) Recv() (*emptypb.Empty, error) {
 // All implementations must embed UnimplementedHealthServer
 // for forward compatibility
 type HealthServer interface {
+	// Check fails with UNAVAILABLE if the service isn't ready.
 	Check(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// Liveness reports whether the process works and shouldn't be restarted. HTTP gateway responds
+	// with 503 if it is down.
+	Liveness(context.Context, *emptypb.Empty) (*HealthStatus, error)
+	// Readiness reports whether the service and its dependencies can handle requests. HTTP gateway
+	// responds with 503 if it is down.
+	Readiness(context.Context, *emptypb.Empty) (*HealthStatus, error)
+	// Watch sends readiness when the stream starts and then whenever its aggregate status changes.
 	Watch(*emptypb.Empty, Health_WatchServer) error
 	mustEmbedUnimplementedHealthServer()
 }
@@ -92,6 +126,12 @@ type UnimplementedHealthServer struct {
 func (UnimplementedHealthServer) Check(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Check not implemented")
 }
+func (UnimplementedHealthServer) Liveness(context.Context, *emptypb.Empty) (*HealthStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Liveness not implemented")
+}
+func (UnimplementedHealthServer) Readiness(context.Context, *emptypb.Empty) (*HealthStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Readiness not implemented")
+}
 func (UnimplementedHealthServer) Watch(*emptypb.Empty, Health_WatchServer) error {
 	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
 }
@@ -126,6 +166,42 @@ func _Health_Check_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Health_Liveness_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HealthServer).Liveness(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/health.Health/Liveness",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HealthServer).Liveness(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Health_Readiness_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HealthServer).Readiness(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/health.Health/Readiness",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HealthServer).Readiness(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Health_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(emptypb.Empty)
 	if err := stream.RecvMsg(m); err != nil {
@@ -135,7 +211,7 @@ func _Health_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
 }
 
 type Health_WatchServer interface {
-	Send(*emptypb.Empty) error
+	Send(*HealthStatus) error
 	grpc.ServerStream
 }
 
@@ -143,7 +219,7 @@ type healthWatchServer struct {
 	grpc.ServerStream
 }
 
-func (x *healthWatchServer) Send(m *emptypb.Empty) error {
+func (x *healthWatchServer) Send(m *HealthStatus) error {
 	return x.ServerStream.SendMsg(m)
 }
 
@@ -158,6 +234,14 @@ var Health_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Check",
 			Handler:    _Health_Check_Handler,
 		},
+		{
+			MethodName: "Liveness",
+			Handler:    _Health_Liveness_Handler,
+		},
+		{
+			MethodName: "Readiness",
+			Handler:    _Health_Readiness_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
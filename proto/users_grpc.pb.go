@@ -24,10 +24,19 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type UsersClient interface {
 	AddUser(ctx context.Context, in *AddUserMessage, opts ...grpc.CallOption) (*User, error)
+	// Marks the user as deleted. Deleted users can be restored with UndeleteUser until they are
+	// permanently purged after the retention period.
 	DeleteUser(ctx context.Context, in *DeleteUserMessage, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	UndeleteUser(ctx context.Context, in *UndeleteUserMessage, opts ...grpc.CallOption) (*User, error)
 	// Replaces the user in DB with newly provided one.
 	UpdateUser(ctx context.Context, in *UpdateUserMessage, opts ...grpc.CallOption) (*User, error)
+	// Updates only the fields listed in update_mask. Password is rehashed only when it is listed.
+	PatchUser(ctx context.Context, in *PatchUserMessage, opts ...grpc.CallOption) (*User, error)
 	SearchUser(ctx context.Context, in *SearchUserMessage, opts ...grpc.CallOption) (*SearchUserResponse, error)
+	GetUser(ctx context.Context, in *GetUserMessage, opts ...grpc.CallOption) (*User, error)
+	GetUserByEmail(ctx context.Context, in *GetUserByEmailMessage, opts ...grpc.CallOption) (*User, error)
+	// Verifies user credentials and returns the user if they match.
+	Authenticate(ctx context.Context, in *AuthenticateMessage, opts ...grpc.CallOption) (*User, error)
 }
 
 type usersClient struct {
@@ -56,6 +65,15 @@ func (c *usersClient) DeleteUser(ctx context.Context, in *DeleteUserMessage, opt
 	return out, nil
 }
 
+func (c *usersClient) UndeleteUser(ctx context.Context, in *UndeleteUserMessage, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/users.Users/UndeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *usersClient) UpdateUser(ctx context.Context, in *UpdateUserMessage, opts ...grpc.CallOption) (*User, error) {
 	out := new(User)
 	err := c.cc.Invoke(ctx, "/users.Users/UpdateUser", in, out, opts...)
@@ -65,6 +83,15 @@ func (c *usersClient) UpdateUser(ctx context.Context, in *UpdateUserMessage, opt
 	return out, nil
 }
 
+func (c *usersClient) PatchUser(ctx context.Context, in *PatchUserMessage, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/users.Users/PatchUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *usersClient) SearchUser(ctx context.Context, in *SearchUserMessage, opts ...grpc.CallOption) (*SearchUserResponse, error) {
 	out := new(SearchUserResponse)
 	err := c.cc.Invoke(ctx, "/users.Users/SearchUser", in, out, opts...)
@@ -74,15 +101,51 @@ func (c *usersClient) SearchUser(ctx context.Context, in *SearchUserMessage, opt
 	return out, nil
 }
 
+func (c *usersClient) GetUser(ctx context.Context, in *GetUserMessage, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/users.Users/GetUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) GetUserByEmail(ctx context.Context, in *GetUserByEmailMessage, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/users.Users/GetUserByEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) Authenticate(ctx context.Context, in *AuthenticateMessage, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/users.Users/Authenticate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UsersServer is the server API for Users service.
 // All implementations must embed UnimplementedUsersServer
 // for forward compatibility
 type UsersServer interface {
 	AddUser(context.Context, *AddUserMessage) (*User, error)
+	// Marks the user as deleted. Deleted users can be restored with UndeleteUser until they are
+	// permanently purged after the retention period.
 	DeleteUser(context.Context, *DeleteUserMessage) (*emptypb.Empty, error)
+	UndeleteUser(context.Context, *UndeleteUserMessage) (*User, error)
 	// Replaces the user in DB with newly provided one.
 	UpdateUser(context.Context, *UpdateUserMessage) (*User, error)
+	// Updates only the fields listed in update_mask. Password is rehashed only when it is listed.
+	PatchUser(context.Context, *PatchUserMessage) (*User, error)
 	SearchUser(context.Context, *SearchUserMessage) (*SearchUserResponse, error)
+	GetUser(context.Context, *GetUserMessage) (*User, error)
+	GetUserByEmail(context.Context, *GetUserByEmailMessage) (*User, error)
+	// Verifies user credentials and returns the user if they match.
+	Authenticate(context.Context, *AuthenticateMessage) (*User, error)
 	mustEmbedUnimplementedUsersServer()
 }
 
@@ -96,12 +159,27 @@ func (UnimplementedUsersServer) AddUser(context.Context, *AddUserMessage) (*User
 func (UnimplementedUsersServer) DeleteUser(context.Context, *DeleteUserMessage) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
 }
+func (UnimplementedUsersServer) UndeleteUser(context.Context, *UndeleteUserMessage) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UndeleteUser not implemented")
+}
 func (UnimplementedUsersServer) UpdateUser(context.Context, *UpdateUserMessage) (*User, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateUser not implemented")
 }
+func (UnimplementedUsersServer) PatchUser(context.Context, *PatchUserMessage) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PatchUser not implemented")
+}
 func (UnimplementedUsersServer) SearchUser(context.Context, *SearchUserMessage) (*SearchUserResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SearchUser not implemented")
 }
+func (UnimplementedUsersServer) GetUser(context.Context, *GetUserMessage) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedUsersServer) GetUserByEmail(context.Context, *GetUserByEmailMessage) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUserByEmail not implemented")
+}
+func (UnimplementedUsersServer) Authenticate(context.Context, *AuthenticateMessage) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
 func (UnimplementedUsersServer) mustEmbedUnimplementedUsersServer() {}
 
 // UnsafeUsersServer may be embedded to opt out of forward compatibility for this service.
@@ -151,6 +229,24 @@ func _Users_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Users_UndeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UndeleteUserMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).UndeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.Users/UndeleteUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).UndeleteUser(ctx, req.(*UndeleteUserMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Users_UpdateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UpdateUserMessage)
 	if err := dec(in); err != nil {
@@ -169,6 +265,24 @@ func _Users_UpdateUser_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Users_PatchUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PatchUserMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).PatchUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.Users/PatchUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).PatchUser(ctx, req.(*PatchUserMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Users_SearchUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SearchUserMessage)
 	if err := dec(in); err != nil {
@@ -187,6 +301,60 @@ func _Users_SearchUser_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Users_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.Users/GetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).GetUser(ctx, req.(*GetUserMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_GetUserByEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserByEmailMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).GetUserByEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.Users/GetUserByEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).GetUserByEmail(ctx, req.(*GetUserByEmailMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/users.Users/Authenticate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).Authenticate(ctx, req.(*AuthenticateMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Users_ServiceDesc is the grpc.ServiceDesc for Users service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -202,14 +370,34 @@ var Users_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteUser",
 			Handler:    _Users_DeleteUser_Handler,
 		},
+		{
+			MethodName: "UndeleteUser",
+			Handler:    _Users_UndeleteUser_Handler,
+		},
 		{
 			MethodName: "UpdateUser",
 			Handler:    _Users_UpdateUser_Handler,
 		},
+		{
+			MethodName: "PatchUser",
+			Handler:    _Users_PatchUser_Handler,
+		},
 		{
 			MethodName: "SearchUser",
 			Handler:    _Users_SearchUser_Handler,
 		},
+		{
+			MethodName: "GetUser",
+			Handler:    _Users_GetUser_Handler,
+		},
+		{
+			MethodName: "GetUserByEmail",
+			Handler:    _Users_GetUserByEmail_Handler,
+		},
+		{
+			MethodName: "Authenticate",
+			Handler:    _Users_Authenticate_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/users.proto",
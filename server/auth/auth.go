@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/toncek345/userservice/access"
+	"github.com/toncek345/userservice/logging"
+	pb "github.com/toncek345/userservice/proto"
+	"github.com/toncek345/userservice/server/validation"
+	"github.com/toncek345/userservice/service"
+
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// tokenType of access tokens, they are sent in the Authorization header as bearer tokens.
+const tokenType = "Bearer"
+
+// Policies of the Auth service. Its methods are public, refresh tokens authenticate Refresh and
+// Logout.
+var Policies = access.ServicePolicies(&pb.Auth_ServiceDesc, access.Public)
+
+type AuthServer struct {
+	AuthService service.AuthService
+	// Logger logs internal errors, slog.Default is used if it is nil.
+	Logger *slog.Logger
+	pb.UnimplementedAuthServer
+}
+
+// internalError logs err, which is hidden from the client, and returns Internal status.
+func (a *AuthServer) internalError(ctx context.Context, msg string, err error) error {
+	logging.FromContext(ctx, a.Logger).ErrorCtx(ctx, msg, "error", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func serviceTokensToPTokens(t *service.Tokens) *pb.Tokens {
+	return &pb.Tokens{
+		AccessToken:           t.AccessToken,
+		TokenType:             tokenType,
+		AccessTokenExpiresAt:  timestamppb.New(t.AccessTokenExpiresAt),
+		RefreshToken:          t.RefreshToken,
+		RefreshTokenExpiresAt: timestamppb.New(t.RefreshTokenExpiresAt),
+	}
+}
+
+func (a *AuthServer) Login(ctx context.Context, msg *pb.LoginMessage) (*pb.Tokens, error) {
+	v := &validation.Validator{}
+	v.Required("email", msg.Email)
+	v.Required("password", msg.Password)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	tokens, err := a.AuthService.Login(ctx, msg.Email, msg.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+		}
+
+		return nil, a.internalError(ctx, "login failed", err)
+	}
+
+	return serviceTokensToPTokens(tokens), nil
+}
+
+func (a *AuthServer) RefreshToken(ctx context.Context, msg *pb.RefreshTokenMessage) (*pb.Tokens, error) {
+	v := &validation.Validator{}
+	v.Required("refresh_token", msg.RefreshToken)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	tokens, err := a.AuthService.RefreshToken(ctx, msg.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+
+		return nil, a.internalError(ctx, "refreshing token failed", err)
+	}
+
+	return serviceTokensToPTokens(tokens), nil
+}
+
+func (a *AuthServer) Logout(ctx context.Context, msg *pb.LogoutMessage) (*emptypb.Empty, error) {
+	v := &validation.Validator{}
+	v.Required("refresh_token", msg.RefreshToken)
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := a.AuthService.Logout(ctx, msg.RefreshToken); err != nil {
+		if errors.Is(err, service.ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+
+		return nil, a.internalError(ctx, "logout failed", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,166 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/toncek345/userservice/proto"
+	"github.com/toncek345/userservice/server/auth"
+	"github.com/toncek345/userservice/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var issued = &service.Tokens{
+	AccessToken:           "access",
+	AccessTokenExpiresAt:  time.Date(2026, 1, 1, 12, 15, 0, 0, time.UTC),
+	RefreshToken:          "refresh",
+	RefreshTokenExpiresAt: time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC),
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if status.Code(err) != code {
+		t.Fatalf("expected code %s, got %v", code, err)
+	}
+}
+
+func expectTokens(t *testing.T, tokens *pb.Tokens) {
+	t.Helper()
+
+	if tokens.AccessToken != "access" || tokens.TokenType != "Bearer" || tokens.RefreshToken != "refresh" ||
+		!tokens.AccessTokenExpiresAt.AsTime().Equal(issued.AccessTokenExpiresAt) ||
+		!tokens.RefreshTokenExpiresAt.AsTime().Equal(issued.RefreshTokenExpiresAt) {
+		t.Fatalf("unexpected tokens: %+v", tokens)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   *pb.LoginMessage
+		err   error
+		code  codes.Code
+		calls int
+	}{
+		{
+			name:  "works",
+			msg:   &pb.LoginMessage{Email: "john@example.com", Password: "password"},
+			code:  codes.OK,
+			calls: 1,
+		},
+		{
+			name: "missing password",
+			msg:  &pb.LoginMessage{Email: "john@example.com"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:  "invalid credentials",
+			msg:   &pb.LoginMessage{Email: "john@example.com", Password: "wrong"},
+			err:   service.ErrInvalidCredentials,
+			code:  codes.Unauthenticated,
+			calls: 1,
+		},
+		{
+			name:  "internal error",
+			msg:   &pb.LoginMessage{Email: "john@example.com", Password: "password"},
+			err:   fmt.Errorf("db down"),
+			code:  codes.Internal,
+			calls: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			server := &auth.AuthServer{AuthService: &service.AuthMock{
+				LoginFn: func(ctx context.Context, email, password string) (*service.Tokens, error) {
+					calls++
+					if email != test.msg.Email || password != test.msg.Password {
+						t.Fatalf("unexpected credentials %s %s", email, password)
+					}
+					if test.err != nil {
+						return nil, test.err
+					}
+					return issued, nil
+				},
+			}}
+
+			tokens, err := server.Login(context.Background(), test.msg)
+			expectCode(t, err, test.code)
+			if calls != test.calls {
+				t.Fatalf("expected %d calls of the service, got %d", test.calls, calls)
+			}
+			if err == nil {
+				expectTokens(t, tokens)
+			}
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *pb.RefreshTokenMessage
+		err  error
+		code codes.Code
+	}{
+		{"works", &pb.RefreshTokenMessage{RefreshToken: "old"}, nil, codes.OK},
+		{"missing token", &pb.RefreshTokenMessage{}, nil, codes.InvalidArgument},
+		{"invalid token", &pb.RefreshTokenMessage{RefreshToken: "old"}, service.ErrInvalidToken, codes.Unauthenticated},
+		{"internal error", &pb.RefreshTokenMessage{RefreshToken: "old"}, fmt.Errorf("db down"), codes.Internal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &auth.AuthServer{AuthService: &service.AuthMock{
+				RefreshTokenFn: func(ctx context.Context, refreshToken string) (*service.Tokens, error) {
+					if refreshToken != "old" {
+						t.Fatalf("unexpected refresh token %s", refreshToken)
+					}
+					if test.err != nil {
+						return nil, test.err
+					}
+					return issued, nil
+				},
+			}}
+
+			tokens, err := server.RefreshToken(context.Background(), test.msg)
+			expectCode(t, err, test.code)
+			if err == nil {
+				expectTokens(t, tokens)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *pb.LogoutMessage
+		err  error
+		code codes.Code
+	}{
+		{"works", &pb.LogoutMessage{RefreshToken: "token"}, nil, codes.OK},
+		{"missing token", &pb.LogoutMessage{}, nil, codes.InvalidArgument},
+		{"invalid token", &pb.LogoutMessage{RefreshToken: "token"}, service.ErrInvalidToken, codes.Unauthenticated},
+		{"internal error", &pb.LogoutMessage{RefreshToken: "token"}, fmt.Errorf("db down"), codes.Internal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &auth.AuthServer{AuthService: &service.AuthMock{
+				LogoutFn: func(ctx context.Context, refreshToken string) error {
+					return test.err
+				},
+			}}
+
+			_, err := server.Logout(context.Background(), test.msg)
+			expectCode(t, err, test.code)
+		})
+	}
+}
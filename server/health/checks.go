@@ -0,0 +1,153 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCheckTimeout = 2 * time.Second
+	DefaultCacheTTL     = 5 * time.Second
+)
+
+// Checker checks health of a single dependency, such as a database or a cache. It returns nil
+// if the dependency works.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to Checker, e.g. CheckerFunc(db.PingContext).
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Check is a named Checker registered in Registry.
+type Check struct {
+	Name    string
+	Checker Checker
+	// Timeout limits a single run of the checker, DefaultCheckTimeout is used if it is 0.
+	Timeout time.Duration
+	// CacheTTL is how long a result is reused, DefaultCacheTTL is used if it is 0.
+	CacheTTL time.Duration
+	// Liveness makes the check affect liveness as well. Dependency checks should affect only
+	// readiness, so that the service isn't restarted when a dependency is down.
+	Liveness bool
+}
+
+// Result is a result of a single run of a Check.
+type Result struct {
+	Name      string
+	Err       error
+	CheckedAt time.Time
+	Latency   time.Duration
+}
+
+type registeredCheck struct {
+	*Check
+
+	// mu is held while the checker runs, so concurrent callers wait for a single run.
+	mu     sync.Mutex
+	result *Result
+}
+
+func (c *registeredCheck) run(ctx context.Context, now func() time.Time) *Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := c.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+	if c.result != nil && now().Sub(c.result.CheckedAt) < ttl {
+		return c.result
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := now()
+	err := c.Checker.Check(ctx)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	result := &Result{
+		Name:      c.Name,
+		Err:       err,
+		CheckedAt: start,
+		Latency:   now().Sub(start),
+	}
+
+	// Results of canceled callers aren't cached as they don't describe the dependency.
+	if !errors.Is(err, context.Canceled) {
+		c.result = result
+	}
+
+	return result
+}
+
+// Registry runs registered checks and caches their results. Zero value is ready to use.
+type Registry struct {
+	// Now returns current time, time.Now is used if it is nil.
+	Now func() time.Time
+
+	mu     sync.RWMutex
+	checks []*registeredCheck
+}
+
+// Register adds check to the registry. Names of checks have to be unique.
+func (r *Registry) Register(check *Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.checks {
+		if c.Name == check.Name {
+			panic(fmt.Sprintf("health check %q is already registered", check.Name))
+		}
+	}
+
+	r.checks = append(r.checks, &registeredCheck{Check: check})
+}
+
+// Run runs checks concurrently and returns their results in the order of registration. Only
+// liveness checks are run if liveness is set.
+func (r *Registry) Run(ctx context.Context, liveness bool) []*Result {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+
+	r.mu.RLock()
+	checks := make([]*registeredCheck, 0, len(r.checks))
+	for _, c := range r.checks {
+		if !liveness || c.Liveness {
+			checks = append(checks, c)
+		}
+	}
+	r.mu.RUnlock()
+
+	results := make([]*Result, len(checks))
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *registeredCheck) {
+			defer wg.Done()
+			results[i] = c.run(ctx, now)
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/toncek345/userservice/proto"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// StandardService is a service whose status is reported by the standard grpc.health.v1 protocol.
+type StandardService struct {
+	// Name is full name of the gRPC service, empty name is the overall status of the server.
+	Name string
+	// Liveness makes status of the service depend only on liveness, e.g. for services which don't
+	// use the database. Status depends on readiness otherwise.
+	Liveness bool
+}
+
+// SyncStandard sets statuses of services in std every WatchInterval until ctx is done, so that
+// load balancers and grpc_health_probe see the same health as Readiness and Liveness.
+func (h *HealthServer) SyncStandard(ctx context.Context, std *grpchealth.Server, services []StandardService) {
+	interval := h.WatchInterval
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.UpdateStandard(ctx, std, services)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// UpdateStandard sets statuses of services in std once, e.g. right after readiness changes.
+func (h *HealthServer) UpdateStandard(ctx context.Context, std *grpchealth.Server, services []StandardService) {
+	var ready, live *pb.HealthStatus
+	for _, s := range services {
+		var hs *pb.HealthStatus
+		if s.Liveness {
+			if live == nil {
+				live = h.status(ctx, true)
+			}
+			hs = live
+		} else {
+			if ready == nil {
+				ready = h.status(ctx, false)
+			}
+			hs = ready
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		servingStatus := healthpb.HealthCheckResponse_SERVING
+		if hs.Status != pb.Status_UP {
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		std.SetServingStatus(s.Name, servingStatus)
+	}
+}
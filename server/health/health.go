@@ -1,7 +1,7 @@
 // health package implements health of the service.
 //
-// TODO: inject outer dependencies and return health based on ping checks. Example: if redis is
-// down, the svc is not fully healthy.
+// Liveness and readiness are built from checks of dependencies registered in Registry, e.g. if
+// the database is down, the service is alive but not ready to handle requests.
 
 package health
 
@@ -9,27 +9,115 @@ import (
 	"context"
 	"time"
 
+	"github.com/toncek345/userservice/access"
 	pb "github.com/toncek345/userservice/proto"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// DefaultWatchInterval is how often Watch checks readiness by default.
+const DefaultWatchInterval = 5 * time.Second
+
+// StartupComponent is name of the readiness component which reports whether the service
+// finished starting.
+const StartupComponent = "startup"
+
+// Policies of the Health service, probes don't authenticate.
+var Policies = access.ServicePolicies(&pb.Health_ServiceDesc, access.Public)
+
 type HealthServer struct {
 	pb.UnimplementedHealthServer
+	// Ready reports whether the service finished starting and can handle requests. Service is
+	// always ready if it is nil.
+	Ready func() bool
+	// Checks of dependencies, service has no dependencies if it is nil.
+	Checks *Registry
+	// WatchInterval is how often Watch checks readiness, DefaultWatchInterval is used if it is 0.
+	WatchInterval time.Duration
 }
 
-func (h *HealthServer) Check(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+func (h *HealthServer) status(ctx context.Context, liveness bool) *pb.HealthStatus {
+	components := []*pb.ComponentHealth{}
+
+	if !liveness && h.Ready != nil {
+		c := &pb.ComponentHealth{Name: StartupComponent, Status: pb.Status_UP}
+		if !h.Ready() {
+			c.Status = pb.Status_DOWN
+			c.Error = "service is not ready"
+		}
+		components = append(components, c)
+	}
+
+	if h.Checks != nil {
+		for _, r := range h.Checks.Run(ctx, liveness) {
+			c := &pb.ComponentHealth{
+				Name:      r.Name,
+				Status:    pb.Status_UP,
+				CheckedAt: timestamppb.New(r.CheckedAt),
+				Latency:   durationpb.New(r.Latency),
+			}
+			if r.Err != nil {
+				c.Status = pb.Status_DOWN
+				c.Error = r.Err.Error()
+			}
+			components = append(components, c)
+		}
+	}
+
+	hs := &pb.HealthStatus{Status: pb.Status_UP, Components: components}
+	for _, c := range components {
+		if c.Status != pb.Status_UP {
+			hs.Status = pb.Status_DOWN
+		}
+	}
+
+	return hs
+}
+
+// Check returns Unavailable until the service is ready.
+func (h *HealthServer) Check(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if h.status(ctx, false).Status != pb.Status_UP {
+		return nil, status.Error(codes.Unavailable, "service is not ready")
+	}
+
 	return &emptypb.Empty{}, nil
 }
 
+func (h *HealthServer) Liveness(ctx context.Context, _ *emptypb.Empty) (*pb.HealthStatus, error) {
+	return h.status(ctx, true), nil
+}
+
+func (h *HealthServer) Readiness(ctx context.Context, _ *emptypb.Empty) (*pb.HealthStatus, error) {
+	return h.status(ctx, false), nil
+}
+
 func (h *HealthServer) Watch(_ *emptypb.Empty, srv pb.Health_WatchServer) error {
-	ticker := time.NewTicker(5 * time.Second)
+	interval := h.WatchInterval
+	if interval == 0 {
+		interval = DefaultWatchInterval
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	last := pb.Status_STATUS_UNSPECIFIED
 	for {
-		if err := srv.Send(&emptypb.Empty{}); err != nil {
+		hs := h.status(srv.Context(), false)
+		if hs.Status != last {
+			if err := srv.Send(hs); err != nil {
+				return nil
+			}
+			last = hs.Status
+		}
+
+		select {
+		case <-srv.Context().Done():
 			return nil
+		case <-ticker.C:
 		}
-		<-ticker.C
 	}
 }
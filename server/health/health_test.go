@@ -0,0 +1,215 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/toncek345/userservice/proto"
+	"github.com/toncek345/userservice/server/health"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestRegistryCache(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	r := &health.Registry{Now: func() time.Time { return now }}
+	r.Register(&health.Check{
+		Name:     "db",
+		CacheTTL: time.Minute,
+		Checker: health.CheckerFunc(func(ctx context.Context) error {
+			calls++
+			return nil
+		}),
+	})
+
+	r.Run(context.Background(), false)
+	r.Run(context.Background(), false)
+	if calls != 1 {
+		t.Fatalf("expected cached result, checker called %d times", calls)
+	}
+
+	now = now.Add(time.Minute)
+	results := r.Run(context.Background(), false)
+	if calls != 2 {
+		t.Fatalf("expected expired result, checker called %d times", calls)
+	}
+	if len(results) != 1 || results[0].Name != "db" || results[0].Err != nil || !results[0].CheckedAt.Equal(now) {
+		t.Fatalf("unexpected results: %+v", results[0])
+	}
+}
+
+func TestRegistryTimeout(t *testing.T) {
+	r := &health.Registry{}
+	r.Register(&health.Check{
+		Name:    "slow",
+		Timeout: 10 * time.Millisecond,
+		Checker: health.CheckerFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+	})
+
+	results := r.Run(context.Background(), false)
+	if results[0].Err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	dbErr := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		ready     bool
+		dbErr     error
+		liveness  bool
+		status    pb.Status
+		names     []string
+		checkCode codes.Code
+	}{
+		{
+			name:      "ready",
+			ready:     true,
+			status:    pb.Status_UP,
+			names:     []string{health.StartupComponent, "db", "goroutines"},
+			checkCode: codes.OK,
+		},
+		{
+			name:      "starting",
+			status:    pb.Status_DOWN,
+			names:     []string{health.StartupComponent, "db", "goroutines"},
+			checkCode: codes.Unavailable,
+		},
+		{
+			name:      "db down",
+			ready:     true,
+			dbErr:     dbErr,
+			status:    pb.Status_DOWN,
+			names:     []string{health.StartupComponent, "db", "goroutines"},
+			checkCode: codes.Unavailable,
+		},
+		{
+			name:     "db down doesn't affect liveness",
+			dbErr:    dbErr,
+			liveness: true,
+			status:   pb.Status_UP,
+			names:    []string{"goroutines"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checks := &health.Registry{}
+			checks.Register(&health.Check{
+				Name:    "db",
+				Checker: health.CheckerFunc(func(ctx context.Context) error { return test.dbErr }),
+			})
+			checks.Register(&health.Check{
+				Name:     "goroutines",
+				Checker:  health.CheckerFunc(func(ctx context.Context) error { return nil }),
+				Liveness: true,
+			})
+			h := &health.HealthServer{Ready: func() bool { return test.ready }, Checks: checks}
+
+			var hs *pb.HealthStatus
+			if test.liveness {
+				hs, _ = h.Liveness(context.Background(), &emptypb.Empty{})
+			} else {
+				hs, _ = h.Readiness(context.Background(), &emptypb.Empty{})
+
+				_, err := h.Check(context.Background(), &emptypb.Empty{})
+				if status.Code(err) != test.checkCode {
+					t.Fatalf("expected check code %s, got %s", test.checkCode, status.Code(err))
+				}
+			}
+
+			if hs.Status != test.status {
+				t.Fatalf("expected status %s, got %s", test.status, hs.Status)
+			}
+			if len(hs.Components) != len(test.names) {
+				t.Fatalf("expected components %v, got %v", test.names, hs.Components)
+			}
+			for i, c := range hs.Components {
+				if c.Name != test.names[i] {
+					t.Fatalf("expected component %s, got %s", test.names[i], c.Name)
+				}
+				if c.Name == "db" && test.dbErr != nil && (c.Status != pb.Status_DOWN || c.Error != dbErr.Error()) {
+					t.Fatalf("unexpected db component: %v", c)
+				}
+			}
+		})
+	}
+}
+
+type watchServer struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *pb.HealthStatus
+}
+
+func (w *watchServer) Context() context.Context {
+	return w.ctx
+}
+
+func (w *watchServer) Send(hs *pb.HealthStatus) error {
+	w.sent <- hs
+	return nil
+}
+
+func TestWatch(t *testing.T) {
+	ready := atomic.Bool{}
+	checks := atomic.Int64{}
+	h := &health.HealthServer{Ready: ready.Load, WatchInterval: time.Millisecond}
+	h.Checks = &health.Registry{}
+	h.Checks.Register(&health.Check{
+		Name:     "counter",
+		CacheTTL: time.Nanosecond,
+		Checker: health.CheckerFunc(func(ctx context.Context) error {
+			checks.Add(1)
+			return nil
+		}),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &watchServer{ctx: ctx, sent: make(chan *pb.HealthStatus, 10)}
+	done := make(chan error)
+	go func() { done <- h.Watch(&emptypb.Empty{}, srv) }()
+
+	expectSent := func(s pb.Status) {
+		t.Helper()
+
+		select {
+		case hs := <-srv.sent:
+			if hs.Status != s {
+				t.Fatalf("expected %s, got %s", s, hs.Status)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s wasn't sent", s)
+		}
+	}
+
+	expectSent(pb.Status_DOWN)
+
+	// Status is checked repeatedly, but unchanged status isn't sent again.
+	for checks.Load() < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	if len(srv.sent) != 0 {
+		t.Fatalf("unchanged status is sent: %v", <-srv.sent)
+	}
+
+	ready.Store(true)
+	expectSent(pb.Status_UP)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,107 @@
+// interceptors package contains gRPC interceptors which protect handlers: panic recovery and
+// request deadlines. Each interceptor has Unary and Stream methods so that unary and streaming
+// RPCs are handled the same way.
+
+package interceptors
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/toncek345/userservice/logging"
+
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Recovery turns panics of handlers into Internal errors instead of crashing the process. Zero
+// value logs with slog.Default.
+type Recovery struct {
+	Logger *slog.Logger
+}
+
+func (r *Recovery) recover(ctx context.Context, err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	logging.FromContext(ctx, r.Logger).ErrorCtx(ctx, "handler panicked", "panic", p, "stack", string(debug.Stack()))
+	*err = status.Error(codes.Internal, "internal error")
+}
+
+// Unary is grpc.UnaryServerInterceptor.
+func (r *Recovery) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer r.recover(ctx, &err)
+
+	return handler(ctx, req)
+}
+
+// Stream is grpc.StreamServerInterceptor.
+func (r *Recovery) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer r.recover(ss.Context(), &err)
+
+	return handler(srv, ss)
+}
+
+// Deadlines limits how long requests run. Zero durations disable the limits.
+type Deadlines struct {
+	// Default is deadline of unary requests which are sent without one.
+	Default time.Duration
+	// Max shortens deadlines of unary requests which are longer.
+	Max time.Duration
+	// StreamMax limits duration of streams. Streams, such as Health.Watch, are long-lived, so
+	// they aren't limited by Default and Max.
+	StreamMax time.Duration
+}
+
+// limit returns ctx with deadline shortened to max and defaulted to def.
+func limit(ctx context.Context, def, max time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok && def > 0:
+		return context.WithTimeout(ctx, def)
+	case max > 0 && (!ok || time.Until(deadline) > max):
+		return context.WithTimeout(ctx, max)
+	}
+
+	return ctx, func() {}
+}
+
+// Unary is grpc.UnaryServerInterceptor.
+func (d *Deadlines) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	def := d.Default
+	if d.Max > 0 && def > d.Max {
+		def = d.Max
+	}
+
+	ctx, cancel := limit(ctx, def, d.Max)
+	defer cancel()
+
+	return handler(ctx, req)
+}
+
+// contextStream overrides context of the stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// Stream is grpc.StreamServerInterceptor.
+func (d *Deadlines) Stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if d.StreamMax == 0 {
+		return handler(srv, ss)
+	}
+
+	ctx, cancel := limit(ss.Context(), d.StreamMax, d.StreamMax)
+	defer cancel()
+
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
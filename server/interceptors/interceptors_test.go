@@ -0,0 +1,140 @@
+package interceptors_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/logging"
+	"github.com/toncek345/userservice/server/interceptors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func TestRecovery(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, _ := logging.New(buf, "info", "json")
+	r := &interceptors.Recovery{Logger: logger}
+
+	_, err := r.Unary(context.Background(), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected internal error, got %v", err)
+	}
+
+	err = r.Stream(nil, &stream{ctx: context.Background()}, &grpc.StreamServerInfo{}, func(srv interface{}, ss grpc.ServerStream) error {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expected internal error, got %v", err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("handler panicked")) {
+		t.Fatalf("panic isn't logged: %s", buf)
+	}
+
+	err = r.Stream(nil, &stream{ctx: context.Background()}, &grpc.StreamServerInfo{}, func(srv interface{}, ss grpc.ServerStream) error {
+		return status.Error(codes.NotFound, "not found")
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("error of handler isn't returned: %v", err)
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	tests := []struct {
+		name      string
+		deadlines interceptors.Deadlines
+		// timeout of the request, 0 means no deadline.
+		timeout time.Duration
+		// expected timeout in the handler, 0 means no deadline.
+		expected time.Duration
+	}{
+		{
+			name: "no limits",
+		},
+		{
+			name:      "default",
+			deadlines: interceptors.Deadlines{Default: time.Minute, Max: time.Hour},
+			expected:  time.Minute,
+		},
+		{
+			name:      "default capped by max",
+			deadlines: interceptors.Deadlines{Default: time.Hour, Max: time.Minute},
+			expected:  time.Minute,
+		},
+		{
+			name:      "max without default",
+			deadlines: interceptors.Deadlines{Max: time.Minute},
+			expected:  time.Minute,
+		},
+		{
+			name:      "shorter deadline is kept",
+			deadlines: interceptors.Deadlines{Default: time.Minute, Max: time.Hour},
+			timeout:   time.Second,
+			expected:  time.Second,
+		},
+		{
+			name:      "longer deadline is shortened",
+			deadlines: interceptors.Deadlines{Default: time.Second, Max: time.Minute},
+			timeout:   time.Hour,
+			expected:  time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			var deadline time.Time
+			var ok bool
+			test.deadlines.Unary(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				deadline, ok = ctx.Deadline()
+				return nil, nil
+			})
+
+			if test.expected == 0 {
+				if ok {
+					t.Fatalf("unexpected deadline %s", deadline)
+				}
+				return
+			}
+			if remaining := time.Until(deadline); !ok || remaining > test.expected || remaining < test.expected-time.Second {
+				t.Fatalf("expected timeout %s, got %s", test.expected, remaining)
+			}
+		})
+	}
+}
+
+func TestDeadlinesStream(t *testing.T) {
+	d := &interceptors.Deadlines{Default: time.Second, Max: time.Second, StreamMax: time.Hour}
+
+	var deadline time.Time
+	var ok bool
+	d.Stream(nil, &stream{ctx: context.Background()}, &grpc.StreamServerInfo{}, func(srv interface{}, ss grpc.ServerStream) error {
+		deadline, ok = ss.Context().Deadline()
+		return nil
+	})
+
+	if remaining := time.Until(deadline); !ok || remaining < time.Hour-time.Minute {
+		t.Fatalf("expected stream max duration, got %s", remaining)
+	}
+}
@@ -2,64 +2,544 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/toncek345/userservice/access"
+	"github.com/toncek345/userservice/certs"
+	"github.com/toncek345/userservice/logging"
+	"github.com/toncek345/userservice/metrics"
 	pb "github.com/toncek345/userservice/proto"
+	"github.com/toncek345/userservice/server/auth"
 	"github.com/toncek345/userservice/server/health"
+	"github.com/toncek345/userservice/server/interceptors"
 	"github.com/toncek345/userservice/server/users"
 	"github.com/toncek345/userservice/service"
+	"github.com/toncek345/userservice/tokens"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/exp/slog"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 )
 
+// incomingHeaderMatcher forwards If-Match, X-Request-Id and X-Api-Key headers to grpc metadata in
+// addition to the defaults, which include Authorization. Forwarded client certificates are set
+// only by the gateway, so HTTP clients can't pass them as Grpc-Metadata- headers.
+func incomingHeaderMatcher(key string) (string, bool) {
+	if strings.EqualFold(key, "If-Match") {
+		return users.IfMatchMetadataKey, true
+	}
+	if strings.EqualFold(key, logging.RequestIDHeader) {
+		return logging.RequestIDHeader, true
+	}
+	if strings.EqualFold(key, access.APIKeyMetadataKey) {
+		return access.APIKeyMetadataKey, true
+	}
+
+	name, ok := runtime.DefaultHeaderMatcher(key)
+	if strings.EqualFold(name, certs.ForwardedCertMetadataKey) {
+		return "", false
+	}
+
+	return name, ok
+}
+
+// outgoingHeaderMatcher returns etag metadata as ETag header, other metadata is prefixed as in
+// the default gateway behaviour. Request ID is already returned by the logging middleware.
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == users.ETagMetadataKey {
+		return "ETag", true
+	}
+	if key == logging.RequestIDHeader {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, key), true
+}
+
+// errorHandler responds with 412 Precondition Failed to version mismatches which are returned
+// as Aborted, everything else is handled by the default handler.
+func errorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	if status.Code(err) == codes.Aborted {
+		w = &statusCodeWriter{ResponseWriter: w, code: http.StatusPreconditionFailed}
+	}
+
+	runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, r, err)
+}
+
+// healthStatusCode responds with 503 Service Unavailable to health statuses which aren't up, so
+// that HTTP probes can use liveness and readiness endpoints.
+func healthStatusCode(ctx context.Context, w http.ResponseWriter, m proto.Message) error {
+	if hs, ok := m.(*pb.HealthStatus); ok && hs.Status != pb.Status_UP {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	return nil
+}
+
+// statusCodeWriter overrides status code written to the response.
+type statusCodeWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusCodeWriter) WriteHeader(int) {
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+// Options configure listeners of the Server.
+type Options struct {
+	GRPCAddr string
+	HTTPAddr string
+	// SinglePort serves gRPC and the HTTP gateway on GRPCAddr, HTTPAddr is ignored. Requests are
+	// routed by their content type.
+	SinglePort bool
+	// HTTP server timeouts, zero means no timeout. With SinglePort, read timeout limits only
+	// reading of request headers and write timeout isn't applied, because they would cut
+	// gRPC streams. Deadlines limit the requests instead.
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	HTTPIdleTimeout  time.Duration
+	// HealthChecks determine liveness and readiness in addition to SetReady.
+	HealthChecks *health.Registry
+	// Reflection registers gRPC server reflection, e.g. for grpcurl.
+	Reflection bool
+	// Logger logs requests and internal errors, slog.Default is used if it is nil.
+	Logger *slog.Logger
+	// DisableAccessLog disables logging of every request, errors are logged regardless.
+	DisableAccessLog bool
+	// Deadlines of gRPC requests, including the ones forwarded by the gateway.
+	Deadlines interceptors.Deadlines
+	// MaxRequestSize limits size of gRPC request messages and HTTP request bodies in bytes. gRPC
+	// default of 4MiB is used if it is 0.
+	MaxRequestSize int
+	// Metrics count and time gRPC requests and are served on /metrics of the HTTP server. Metrics
+	// are disabled if it is nil.
+	Metrics *metrics.Metrics
+	// InProcessGateway connects the gateway to the gRPC server in memory instead of over the
+	// gRPC listener. Requests of the gateway go through the same interceptors.
+	InProcessGateway bool
+	// TLS serves both listeners and the connection of the gateway to gRPC with TLS. Listeners
+	// are plaintext if it is nil. With client CAs, identities of clients are available to
+	// handlers from certs.ClientIdentity.
+	TLS *certs.Reloader
+	// Auth serves the Auth service, it isn't registered if it is nil.
+	Auth service.AuthService
+	// TokenKeys are published on tokens.JWKSPath of the HTTP server if it is set, so that other
+	// services can verify access tokens.
+	TokenKeys *tokens.KeySet
+	// Access authenticates and authorizes calls, every call is allowed if it is nil. Its
+	// Policies override policies of the registered services.
+	Access *access.Interceptor
+}
+
+// servingListener closes serving when Serve starts accepting connections.
+type servingListener struct {
+	net.Listener
+	once    sync.Once
+	serving chan struct{}
+}
+
+func (l *servingListener) Accept() (net.Conn, error) {
+	l.once.Do(func() { close(l.serving) })
+	return l.Listener.Accept()
+}
+
 type Server struct {
 	server       *grpc.Server
-	grpcListener net.Listener
-	httpPort     int
-	mux          http.Handler
-	closeProxy   context.CancelFunc
+	grpcListener *servingListener
+	httpServer   *http.Server
+	// httpListener is nil with single port, the HTTP server serves grpcListener then.
+	httpListener net.Listener
+	// router routes requests of the single port.
+	router *router
+	// gatewayListener accepts in-process connections of the gateway, it is nil unless the
+	// gateway is in-process.
+	gatewayListener *bufconn.Listener
+	closeProxy      context.CancelFunc
+	ready           atomic.Bool
+	health          *health.HealthServer
+	stdHealth       *grpchealth.Server
+	// stdServices are reported by the grpc.health.v1 service.
+	stdServices []health.StandardService
 }
 
+// Start serves gRPC, with single port it serves the HTTP gateway as well.
 func (s *Server) Start() error {
+	if s.httpListener == nil {
+		return s.serveHTTP(s.grpcListener)
+	}
+
 	return s.server.Serve(s.grpcListener)
 }
 
+// WaitGRPCServing blocks until Start serves gRPC requests or ctx is done. Unless the gateway is
+// in-process, it forwards requests to the gRPC listener, so StartHTTP should be called after it.
+func (s *Server) WaitGRPCServing(ctx context.Context) error {
+	select {
+	case <-s.grpcListener.serving:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for grpc server: %w", ctx.Err())
+	}
+}
+
+// StartHTTP serves the HTTP gateway. With single port the gateway is served by Start.
 func (s *Server) StartHTTP() error {
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.httpPort), s.mux)
+	if s.httpListener == nil {
+		return errors.New("http is served by Start with single port")
+	}
+
+	return s.serveHTTP(s.httpListener)
+}
+
+func (s *Server) serveHTTP(lis net.Listener) error {
+	if s.httpServer.TLSConfig != nil {
+		return s.httpServer.ServeTLS(lis, "", "")
+	}
+
+	return s.httpServer.Serve(lis)
+}
+
+// GRPCAddr returns address of the gRPC listener.
+func (s *Server) GRPCAddr() net.Addr {
+	return s.grpcListener.Addr()
+}
+
+// HTTPAddr returns address of the HTTP listener, which is the gRPC listener with single port.
+func (s *Server) HTTPAddr() net.Addr {
+	if s.httpListener == nil {
+		return s.grpcListener.Addr()
+	}
+
+	return s.httpListener.Addr()
+}
+
+// SetReady sets readiness which is reported by the health service. Server isn't ready until it
+// is set.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+	s.health.UpdateStandard(context.Background(), s.stdHealth, s.stdServices)
 }
 
-func (s *Server) Stop() {
+// Stop reports the server as not ready and waits for in-flight requests to finish until ctx is
+// done, then closes remaining connections.
+func (s *Server) Stop(ctx context.Context) {
+	s.ready.Store(false)
+	s.stdHealth.Shutdown()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		s.httpServer.Close()
+	}
+
+	if s.router != nil {
+		// gRPC requests served by the HTTP server can't be drained by GracefulStop. Shutdown
+		// asked clients to go away, but doesn't wait for HTTP/2 connections without TLS.
+		s.router.wait(ctx)
+		s.closeProxy()
+		s.server.Stop()
+		return
+	}
 	s.closeProxy()
-	s.server.GracefulStop()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
 }
 
-func NewServer(grpcPort, httpPort int, userService service.UserService) (*Server, error) {
-	grpcHost := fmt.Sprintf("localhost:%d", grpcPort)
-	lis, err := net.Listen("tcp", grpcHost)
+// gatewayBufferSize is size of in-memory connections of the in-process gateway.
+const gatewayBufferSize = 1 << 20
+
+func (s *Server) closeListeners() {
+	s.grpcListener.Close()
+	if s.httpListener != nil {
+		s.httpListener.Close()
+	}
+}
+
+// router serves gRPC requests of the single port with the gRPC server and other requests with
+// the HTTP handler. It counts in-flight requests, so that Stop can wait for them.
+type router struct {
+	grpc     http.Handler
+	http     http.Handler
+	inFlight atomic.Int64
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.inFlight.Add(1)
+	defer r.inFlight.Add(-1)
+
+	if req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		r.grpc.ServeHTTP(w, req)
+		return
+	}
+	r.http.ServeHTTP(w, req)
+}
+
+// wait blocks until there are no in-flight requests or ctx is done.
+func (r *router) wait(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for r.inFlight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dialAddr returns address on which the gateway reaches the listener at addr.
+func dialAddr(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return addr.String()
+	}
+
+	return fmt.Sprintf("localhost:%d", tcpAddr.Port)
+}
+
+// standardServices are reported by the grpc.health.v1 service. Health service works without the
+// database, so it depends only on liveness.
+var standardServices = []health.StandardService{
+	{Name: ""},
+	{Name: pb.Users_ServiceDesc.ServiceName},
+	{Name: pb.Health_ServiceDesc.ServiceName, Liveness: true},
+}
+
+// policies returns access policies of all services the server can register, overridden by
+// policies of the caller. Reflection and the standard health service are public like the Health
+// service.
+func policies(overrides map[string]access.Policy) map[string]access.Policy {
+	all := map[string]access.Policy{}
+	for _, servicePolicies := range []map[string]access.Policy{
+		users.Policies,
+		auth.Policies,
+		health.Policies,
+		access.ServicePolicies(&healthpb.Health_ServiceDesc, access.Public),
+		access.ServicePolicies(&reflectionpb.ServerReflection_ServiceDesc, access.Public),
+		overrides,
+	} {
+		for method, policy := range servicePolicies {
+			all[method] = policy
+		}
+	}
+
+	return all
+}
+
+// NewServer binds both listeners, requests are served after Start and StartHTTP are called.
+// With single port only the gRPC listener is bound and StartHTTP isn't called.
+func NewServer(opts *Options, userService service.UserService) (*Server, error) {
+	lis, err := net.Listen("tcp", opts.GRPCAddr)
 	if err != nil {
 		return nil, fmt.Errorf("net listen: %w", err)
 	}
+	gatewayTarget := dialAddr(lis.Addr())
+
+	var httpLis net.Listener
+	if !opts.SinglePort {
+		httpLis, err = net.Listen("tcp", opts.HTTPAddr)
+		if err != nil {
+			lis.Close()
+			return nil, fmt.Errorf("net listen http: %w", err)
+		}
+	}
+
+	s := &Server{
+		grpcListener: &servingListener{Listener: lis, serving: make(chan struct{})},
+		httpListener: httpLis,
+		stdHealth:    grpchealth.NewServer(),
+		stdServices:  append([]health.StandardService{}, standardServices...),
+	}
+	s.health = &health.HealthServer{Ready: s.ready.Load, Checks: opts.HealthChecks}
+	if opts.InProcessGateway {
+		s.gatewayListener = bufconn.Listen(gatewayBufferSize)
+	}
 
-	server := grpc.NewServer()
-	pb.RegisterUsersServer(server, &users.UserServer{UserService: userService})
-	pb.RegisterHealthServer(server, &health.HealthServer{})
+	// Tracing, logging and metrics are the outermost interceptors so that recovered panics and
+	// deadlines are traced, logged and counted. Tracing goes first so that access logs carry
+	// the trace ID. Denied calls are logged and counted too.
+	logInterceptor := &logging.Interceptor{Logger: opts.Logger, DisableAccessLog: opts.DisableAccessLog}
+	recovery := &interceptors.Recovery{Logger: opts.Logger}
+	deadlines := opts.Deadlines
+	unary := []grpc.UnaryServerInterceptor{otelgrpc.UnaryServerInterceptor(), logInterceptor.Unary}
+	stream := []grpc.StreamServerInterceptor{otelgrpc.StreamServerInterceptor(), logInterceptor.Stream}
+	if opts.TLS != nil {
+		identity := &certs.Interceptor{Reloader: opts.TLS}
+		unary = append(unary, identity.Unary)
+		stream = append(stream, identity.Stream)
+	}
+	if opts.Metrics != nil {
+		unary = append(unary, opts.Metrics.Unary)
+		stream = append(stream, opts.Metrics.Stream)
+	}
+	unary = append(unary, recovery.Unary)
+	stream = append(stream, recovery.Stream)
+	if opts.Access != nil {
+		accessInterceptor := *opts.Access
+		accessInterceptor.Policies = policies(opts.Access.Policies)
+		unary = append(unary, accessInterceptor.Unary)
+		stream = append(stream, accessInterceptor.Stream)
+	}
+	unary = append(unary, deadlines.Unary)
+	stream = append(stream, deadlines.Stream)
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if opts.MaxRequestSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(opts.MaxRequestSize))
+	}
+	if opts.TLS != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(opts.TLS.ServerConfig())))
+	}
+
+	s.server = grpc.NewServer(serverOpts...)
+	pb.RegisterUsersServer(s.server, &users.UserServer{UserService: userService, Logger: opts.Logger})
+	pb.RegisterHealthServer(s.server, s.health)
+	if opts.Auth != nil {
+		pb.RegisterAuthServer(s.server, &auth.AuthServer{AuthService: opts.Auth, Logger: opts.Logger})
+		s.stdServices = append(s.stdServices, health.StandardService{Name: pb.Auth_ServiceDesc.ServiceName})
+	}
+	healthpb.RegisterHealthServer(s.server, s.stdHealth)
+	if opts.Reflection {
+		reflection.Register(s.server)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	mux := runtime.NewServeMux()
+	muxOpts := []runtime.ServeMuxOption{
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+		runtime.WithErrorHandler(errorHandler),
+		runtime.WithForwardResponseOption(healthStatusCode),
+	}
+	transportCreds := insecure.NewCredentials()
+	if opts.TLS != nil {
+		// Credentials of the gRPC server apply to in-process connections too.
+		muxOpts = append(muxOpts, runtime.WithMetadata(certs.GatewayMetadata))
+		transportCreds = credentials.NewTLS(opts.TLS.GatewayConfig())
+	}
+	mux := runtime.NewServeMux(muxOpts...)
 
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
-	if err := pb.RegisterUsersHandlerFromEndpoint(ctx, mux, grpcHost, opts); err != nil {
+	// Client interceptors propagate trace context of gateway requests to the gRPC server.
+	dialOpts := []grpc.DialOption{
+		grpc.WithTransportCredentials(transportCreds),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	}
+	if s.gatewayListener != nil {
+		gatewayTarget = "bufconn"
+		dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.gatewayListener.DialContext(ctx)
+		}))
+	}
+	if err := pb.RegisterUsersHandlerFromEndpoint(ctx, mux, gatewayTarget, dialOpts); err != nil {
 		defer cancel()
+		s.closeListeners()
 		return nil, fmt.Errorf("register user service: %w", err)
 	}
-	if err := pb.RegisterHealthHandlerFromEndpoint(ctx, mux, grpcHost, opts); err != nil {
+	if err := pb.RegisterHealthHandlerFromEndpoint(ctx, mux, gatewayTarget, dialOpts); err != nil {
 		defer cancel()
+		s.closeListeners()
 		return nil, fmt.Errorf("register user service: %w", err)
 	}
+	if opts.Auth != nil {
+		if err := pb.RegisterAuthHandlerFromEndpoint(ctx, mux, gatewayTarget, dialOpts); err != nil {
+			defer cancel()
+			s.closeListeners()
+			return nil, fmt.Errorf("register auth service: %w", err)
+		}
+	}
+
+	s.closeProxy = cancel
+	go s.health.SyncStandard(ctx, s.stdHealth, s.stdServices)
+	if s.gatewayListener != nil {
+		// Serve returns when the server is stopped.
+		go s.server.Serve(s.gatewayListener)
+	}
+
+	var gateway http.Handler = mux
+	if opts.MaxRequestSize > 0 {
+		gateway = http.MaxBytesHandler(gateway, int64(opts.MaxRequestSize))
+	}
+
+	// Scrapes of metrics aren't logged nor traced. Trace context of the caller is extracted
+	// from W3C traceparent header.
+	handler := http.NewServeMux()
+	handler.Handle("/", otelhttp.NewHandler(
+		logging.Middleware(opts.Logger, opts.DisableAccessLog, gateway),
+		"gateway",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "HTTP " + r.Method
+		}),
+	))
+	if opts.Metrics != nil {
+		handler.Handle("/metrics", opts.Metrics.Handler())
+	}
+	if opts.TokenKeys != nil {
+		handler.Handle(tokens.JWKSPath, opts.TokenKeys)
+	}
+
+	s.httpServer = &http.Server{
+		Handler:      handler,
+		ReadTimeout:  opts.HTTPReadTimeout,
+		WriteTimeout: opts.HTTPWriteTimeout,
+		IdleTimeout:  opts.HTTPIdleTimeout,
+	}
+	if opts.TLS != nil {
+		s.httpServer.TLSConfig = opts.TLS.ServerConfig()
+	}
+
+	if opts.SinglePort {
+		s.router = &router{grpc: s.server, http: handler}
+		s.httpServer.ReadTimeout = 0
+		s.httpServer.ReadHeaderTimeout = opts.HTTPReadTimeout
+		s.httpServer.WriteTimeout = 0
+
+		// HTTP/2 server is shared by TLS and cleartext (h2c) connections, so that Shutdown of
+		// the HTTP server gracefully closes both.
+		h2 := &http2.Server{}
+		if err := http2.ConfigureServer(s.httpServer, h2); err != nil {
+			cancel()
+			s.closeListeners()
+			return nil, fmt.Errorf("configure http2: %w", err)
+		}
+		if opts.TLS == nil {
+			// ConfigureServer sets an empty TLS config, which would make it serve TLS.
+			s.httpServer.TLSConfig = nil
+		}
+		s.httpServer.Handler = h2c.NewHandler(s.router, h2)
+	}
 
-	return &Server{server, lis, httpPort, mux, cancel}, nil
+	return s, nil
 }
@@ -0,0 +1,716 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/access"
+	"github.com/toncek345/userservice/certs"
+	"github.com/toncek345/userservice/certs/certstest"
+	"github.com/toncek345/userservice/metrics"
+	pb "github.com/toncek345/userservice/proto"
+	"github.com/toncek345/userservice/server"
+	"github.com/toncek345/userservice/server/health"
+	"github.com/toncek345/userservice/server/interceptors"
+	"github.com/toncek345/userservice/service"
+	"github.com/toncek345/userservice/tokens"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerReadiness(t *testing.T) {
+	s, err := server.NewServer(&server.Options{GRPCAddr: "localhost:0", HTTPAddr: "localhost:0"}, &service.UsersMock{})
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.WaitGRPCServing(ctx); err == nil {
+		t.Fatal("grpc is serving before start")
+	}
+
+	go s.Start()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.WaitGRPCServing(ctx); err != nil {
+		t.Fatalf("waiting for grpc: %s", err)
+	}
+	go s.StartHTTP()
+
+	expectStatus := func(path string, code int) {
+		t.Helper()
+
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", s.HTTPAddr(), path))
+		if err != nil {
+			t.Fatalf("health request: %s", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != code {
+			t.Fatalf("%s: expected status %d, got %d", path, code, resp.StatusCode)
+		}
+	}
+
+	expectStatus("/health", http.StatusServiceUnavailable)
+	expectStatus("/health/ready", http.StatusServiceUnavailable)
+	expectStatus("/health/live", http.StatusOK)
+	s.SetReady(true)
+	expectStatus("/health", http.StatusOK)
+	expectStatus("/health/ready", http.StatusOK)
+	s.SetReady(false)
+	expectStatus("/health", http.StatusServiceUnavailable)
+}
+
+func TestServerStandardHealth(t *testing.T) {
+	dbDown := atomic.Bool{}
+	dbDown.Store(true)
+
+	checks := &health.Registry{}
+	checks.Register(&health.Check{
+		Name:     "db",
+		CacheTTL: time.Nanosecond,
+		Checker: health.CheckerFunc(func(ctx context.Context) error {
+			if dbDown.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}),
+	})
+
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:     "localhost:0",
+		HTTPAddr:     "localhost:0",
+		HealthChecks: checks,
+		Reflection:   true,
+	}, &service.UsersMock{})
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+	go s.Start()
+
+	conn, err := grpc.Dial(s.GRPCAddr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	expectStatuses := func(statuses map[string]healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+
+		for service, expected := range statuses {
+			resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			if err != nil {
+				t.Fatalf("check %q: %s", service, err)
+			}
+			if resp.Status != expected {
+				t.Fatalf("service %q: expected %s, got %s", service, expected, resp.Status)
+			}
+		}
+	}
+
+	s.SetReady(true)
+	expectStatuses(map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":              healthpb.HealthCheckResponse_NOT_SERVING,
+		"users.Users":   healthpb.HealthCheckResponse_NOT_SERVING,
+		"health.Health": healthpb.HealthCheckResponse_SERVING,
+	})
+
+	dbDown.Store(false)
+	s.SetReady(true)
+	expectStatuses(map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"":              healthpb.HealthCheckResponse_SERVING,
+		"users.Users":   healthpb.HealthCheckResponse_SERVING,
+		"health.Health": healthpb.HealthCheckResponse_SERVING,
+	})
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatalf("reflection: %s", err)
+	}
+	if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatalf("reflection send: %s", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("reflection recv: %s", err)
+	}
+
+	services := map[string]bool{}
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services[s.Name] = true
+	}
+	for _, name := range []string{"users.Users", "health.Health", "grpc.health.v1.Health"} {
+		if !services[name] {
+			t.Fatalf("service %s isn't listed by reflection: %v", name, services)
+		}
+	}
+}
+
+func TestServerInterceptors(t *testing.T) {
+	users := &service.UsersMock{
+		GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Error("request has no default deadline")
+			}
+			panic("boom")
+		},
+	}
+
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:       "localhost:0",
+		HTTPAddr:       "localhost:0",
+		Deadlines:      interceptors.Deadlines{Default: time.Minute},
+		MaxRequestSize: 1024,
+		Metrics:        metrics.New(),
+	}, users)
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+	go s.Start()
+
+	conn, err := grpc.Dial(s.GRPCAddr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	client := pb.NewUsersClient(conn)
+
+	// Server survives the panic and keeps serving.
+	for i := 0; i < 2; i++ {
+		_, err := client.GetUser(context.Background(), &pb.GetUserMessage{Id: "1"})
+		if status.Code(err) != codes.Internal {
+			t.Fatalf("expected internal error, got %v", err)
+		}
+	}
+
+	_, err = client.AddUser(context.Background(), &pb.AddUserMessage{FirstName: strings.Repeat("a", 2048)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected resource exhausted, got %v", err)
+	}
+
+	go s.StartHTTP()
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", s.HTTPAddr()))
+	if err != nil {
+		t.Fatalf("metrics request: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	handled := `grpc_server_handled_total{grpc_code="Internal",grpc_method="GetUser",grpc_service="users.Users",grpc_type="unary"} 2`
+	if !strings.Contains(string(body), handled) {
+		t.Fatalf("recovered panics aren't counted:\n%s", body)
+	}
+}
+
+func TestServerTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	users := &service.UsersMock{
+		GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+			if got := trace.SpanContextFromContext(ctx).TraceID().String(); got != traceID {
+				t.Errorf("expected trace %s in handler, got %s", traceID, got)
+			}
+			return &service.User{ID: id}, nil
+		},
+	}
+
+	s, err := server.NewServer(&server.Options{GRPCAddr: "localhost:0", HTTPAddr: "localhost:0"}, users)
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+	go s.Start()
+	if err := s.WaitGRPCServing(context.Background()); err != nil {
+		t.Fatalf("wait grpc: %s", err)
+	}
+	go s.StartHTTP()
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/users/1", s.HTTPAddr()), nil)
+	if err != nil {
+		t.Fatalf("new request: %s", err)
+	}
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	// Gateway, gRPC client and gRPC server spans belong to the trace of the caller.
+	kinds := map[trace.SpanKind]bool{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			kinds[span.SpanKind()] = true
+		}
+	}
+	for _, kind := range []trace.SpanKind{trace.SpanKindServer, trace.SpanKindClient} {
+		if !kinds[kind] {
+			t.Fatalf("no %s span in trace, recorded %v", kind, kinds)
+		}
+	}
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	t.Run("two ports", func(t *testing.T) { testMutualTLS(t, false, false) })
+	t.Run("single port", func(t *testing.T) { testMutualTLS(t, true, false) })
+	t.Run("in-process gateway", func(t *testing.T) { testMutualTLS(t, false, true) })
+}
+
+func testMutualTLS(t *testing.T, singlePort, inProcessGateway bool) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "ca")
+	clientCA := certstest.NewCA(t, "client ca")
+	serverCert, serverKey := ca.Issue(t, "server")
+	reloader := &certs.Reloader{
+		CertFile:     certstest.WriteFile(t, dir, "tls.crt", serverCert),
+		KeyFile:      certstest.WriteFile(t, dir, "tls.key", serverKey),
+		ClientCAFile: certstest.WriteFile(t, dir, "client-ca.crt", clientCA.PEM),
+	}
+	if err := reloader.Load(); err != nil {
+		t.Fatalf("load certificates: %s", err)
+	}
+
+	users := &service.UsersMock{
+		GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+			identity, ok := certs.ClientIdentity(ctx)
+			if !ok {
+				return nil, errors.New("no client identity")
+			}
+			return &service.User{ID: id, FirstName: identity.CommonName}, nil
+		},
+	}
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:         "localhost:0",
+		HTTPAddr:         "localhost:0",
+		SinglePort:       singlePort,
+		InProcessGateway: inProcessGateway,
+		TLS:              reloader,
+	}, users)
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+	go s.Start()
+	if err := s.WaitGRPCServing(context.Background()); err != nil {
+		t.Fatalf("wait grpc: %s", err)
+	}
+	if !singlePort {
+		go s.StartHTTP()
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.PEM)
+	clientConfig := func(commonName string) *tls.Config {
+		c := &tls.Config{RootCAs: roots}
+		if commonName != "" {
+			cert, err := tls.X509KeyPair(clientCA.Issue(t, commonName))
+			if err != nil {
+				t.Fatalf("key pair: %s", err)
+			}
+			c.Certificates = []tls.Certificate{cert}
+		}
+		return c
+	}
+
+	t.Run("grpc", func(t *testing.T) {
+		conn, err := grpc.Dial(s.GRPCAddr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientConfig("grpc-client"))))
+		if err != nil {
+			t.Fatalf("dial: %s", err)
+		}
+		defer conn.Close()
+
+		// Identities forwarded by anyone else than the gateway are ignored.
+		ctx := metadata.AppendToOutgoingContext(context.Background(), certs.ForwardedCertMetadataKey, "forged")
+		u, err := pb.NewUsersClient(conn).GetUser(ctx, &pb.GetUserMessage{Id: "1"})
+		if err != nil {
+			t.Fatalf("get user: %s", err)
+		}
+		if u.FirstName != "grpc-client" {
+			t.Fatalf("expected identity grpc-client, got %q", u.FirstName)
+		}
+	})
+
+	t.Run("gateway", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig("http-client")}}
+		resp, err := client.Get(fmt.Sprintf("https://%s/users/1", s.HTTPAddr()))
+		if err != nil {
+			t.Fatalf("request: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(body), `"firstName":"http-client"`) {
+			t.Fatalf("expected identity http-client, got %d %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("gateway forged identity", func(t *testing.T) {
+		forgedPEM, _ := clientCA.Issue(t, "forged")
+		forged, _ := pem.Decode(forgedPEM)
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/users/1", s.HTTPAddr()), nil)
+		if err != nil {
+			t.Fatalf("new request: %s", err)
+		}
+		req.Header.Set("Grpc-Metadata-"+certs.ForwardedCertMetadataKey, base64.StdEncoding.EncodeToString(forged.Bytes))
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig("http-client")}}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(body), `"firstName":"http-client"`) {
+			t.Fatalf("expected identity http-client, got %d %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig("")}}
+		if resp, err := client.Get(fmt.Sprintf("https://%s/users/1", s.HTTPAddr())); err == nil {
+			resp.Body.Close()
+			t.Fatal("expected handshake error")
+		}
+	})
+}
+
+func TestServerSinglePort(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	users := &service.UsersMock{
+		GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+			if id == "slow" {
+				close(started)
+				<-release
+			}
+			return &service.User{ID: id}, nil
+		},
+	}
+
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:         "localhost:0",
+		SinglePort:       true,
+		HTTPWriteTimeout: time.Millisecond,
+	}, users)
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Start() }()
+	if err := s.WaitGRPCServing(context.Background()); err != nil {
+		t.Fatalf("wait grpc: %s", err)
+	}
+	if s.HTTPAddr().String() != s.GRPCAddr().String() {
+		t.Fatalf("expected single address, got %s and %s", s.GRPCAddr(), s.HTTPAddr())
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/users/1", s.HTTPAddr()))
+	if err != nil {
+		t.Fatalf("http request: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"id":"1"`) {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, body)
+	}
+
+	conn, err := grpc.Dial(s.GRPCAddr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer conn.Close()
+	client := pb.NewUsersClient(conn)
+
+	// Write timeout doesn't cut gRPC requests.
+	slow := make(chan error, 1)
+	go func() {
+		_, err := client.GetUser(context.Background(), &pb.GetUserMessage{Id: "slow"})
+		slow <- err
+	}()
+	<-started
+	time.Sleep(10 * time.Millisecond)
+
+	// Stop waits for the in-flight request.
+	stopped := make(chan struct{})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Stop(ctx)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop didn't wait for in-flight request")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("in-flight request failed: %s", err)
+	}
+	<-stopped
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("unexpected serve error: %v", err)
+	}
+}
+
+func TestServerInProcessGateway(t *testing.T) {
+	users := &service.UsersMock{
+		GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+			if id == "panic" {
+				panic("boom")
+			}
+			return &service.User{ID: id}, nil
+		},
+	}
+
+	m := metrics.New()
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:         "localhost:0",
+		HTTPAddr:         "localhost:0",
+		InProcessGateway: true,
+		Metrics:          m,
+	}, users)
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+	// gRPC listener isn't served, the gateway doesn't need it.
+	go s.StartHTTP()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", s.HTTPAddr(), path))
+		if err != nil {
+			t.Fatalf("request: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/users/1"); code != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", code, body)
+	}
+	// Panic is recovered by the interceptor.
+	if code, body := get("/users/panic"); code != http.StatusInternalServerError {
+		t.Fatalf("unexpected response %d %s", code, body)
+	}
+
+	_, body := get("/metrics")
+	for _, code := range []string{"OK", "Internal"} {
+		handled := fmt.Sprintf(`grpc_server_handled_total{grpc_code="%s",grpc_method="GetUser",grpc_service="users.Users",grpc_type="unary"} 1`, code)
+		if !strings.Contains(body, handled) {
+			t.Fatalf("gateway requests aren't counted by interceptors:\n%s", body)
+		}
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	keys := &tokens.KeySet{}
+	if err := keys.Load(); err != nil {
+		t.Fatalf("loading keys: %s", err)
+	}
+
+	authService := &service.AuthMock{
+		LoginFn: func(ctx context.Context, email, password string) (*service.Tokens, error) {
+			if password != "password" {
+				return nil, service.ErrInvalidCredentials
+			}
+			return &service.Tokens{AccessToken: "access", RefreshToken: "refresh"}, nil
+		},
+	}
+
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:         "localhost:0",
+		HTTPAddr:         "localhost:0",
+		InProcessGateway: true,
+		Auth:             authService,
+		TokenKeys:        keys,
+	}, &service.UsersMock{})
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+	go s.StartHTTP()
+
+	login := func(password string) (int, string) {
+		t.Helper()
+
+		body := fmt.Sprintf(`{"email":"john@example.com","password":%q}`, password)
+		resp, err := http.Post(fmt.Sprintf("http://%s/auth/login", s.HTTPAddr()), "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("login request: %s", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, body := login("password"); code != http.StatusOK || !strings.Contains(body, `"tokenType":"Bearer"`) {
+		t.Fatalf("unexpected login response %d %s", code, body)
+	}
+	if code, body := login("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("unexpected login response %d %s", code, body)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", s.HTTPAddr(), tokens.JWKSPath))
+	if err != nil {
+		t.Fatalf("jwks request: %s", err)
+	}
+	defer resp.Body.Close()
+	var jwks tokens.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil || len(jwks.Keys) != 1 {
+		t.Fatalf("unexpected jwks: %+v %v", jwks, err)
+	}
+}
+
+func TestServerAccess(t *testing.T) {
+	keys := &tokens.KeySet{}
+	if err := keys.Load(); err != nil {
+		t.Fatalf("loading keys: %s", err)
+	}
+	token, err := keys.Sign(&tokens.Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    "userservice",
+		Subject:   "user_id",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}})
+	if err != nil {
+		t.Fatalf("signing token: %s", err)
+	}
+
+	users := &service.UsersMock{
+		GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+			return &service.User{ID: id}, nil
+		},
+	}
+	s, err := server.NewServer(&server.Options{
+		GRPCAddr:         "localhost:0",
+		HTTPAddr:         "localhost:0",
+		InProcessGateway: true,
+		Access: &access.Interceptor{
+			Keys:    keys,
+			Issuer:  "userservice",
+			APIKeys: access.APIKeys{access.HashAPIKey("secret"): "admin"},
+			Policies: map[string]access.Policy{
+				"/health.Health/Check": access.Authenticated,
+			},
+		},
+	}, users)
+	if err != nil {
+		t.Fatalf("new server: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	}()
+	go s.StartHTTP()
+	s.SetReady(true)
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		code   int
+	}{
+		{"health is public", "/health/live", nil, http.StatusOK},
+		{"overridden policy", "/health", nil, http.StatusUnauthorized},
+		{"overridden policy authenticated", "/health", http.Header{"Authorization": {"Bearer " + token}}, http.StatusOK},
+		{"unauthenticated", "/users/user_id", nil, http.StatusUnauthorized},
+		{"self", "/users/user_id", http.Header{"Authorization": {"Bearer " + token}}, http.StatusOK},
+		{"other user", "/users/other_id", http.Header{"Authorization": {"Bearer " + token}}, http.StatusForbidden},
+		{"api key", "/users/other_id", http.Header{"X-Api-Key": {"secret"}}, http.StatusOK},
+		{"invalid api key", "/users/other_id", http.Header{"X-Api-Key": {"guess"}}, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s%s", s.HTTPAddr(), test.path), nil)
+			if err != nil {
+				t.Fatalf("new request: %s", err)
+			}
+			req.Header = test.header
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %s", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.code {
+				t.Fatalf("expected status %d, got %d", test.code, resp.StatusCode)
+			}
+		})
+	}
+}
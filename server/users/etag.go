@@ -0,0 +1,83 @@
+package users
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// IfMatchMetadataKey is metadata key with the expected version of a modified user.
+	IfMatchMetadataKey = "if-match"
+	// ETagMetadataKey is header metadata key with the version of a returned user.
+	ETagMetadataKey = "etag"
+)
+
+// FormatETag returns strong entity tag of version.
+func FormatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// isWeakETag reports whether etag is a weak entity tag, which never matches If-Match since it
+// requires strong comparison (RFC 9110, section 13.1.1).
+func isWeakETag(etag string) bool {
+	return strings.HasPrefix(strings.TrimSpace(etag), "W/")
+}
+
+// parseETag parses entity tags made by FormatETag, "*" matches any version which is returned
+// as 0.
+func parseETag(etag string) (int64, bool) {
+	etag = strings.TrimSpace(etag)
+	if etag == "*" {
+		return 0, true
+	}
+
+	unquoted, err := strconv.Unquote(etag)
+	if err != nil {
+		unquoted = etag
+	}
+
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// expectedVersion returns version from the message if it is set, otherwise from the If-Match
+// metadata. 0 means that version isn't checked.
+func expectedVersion(ctx context.Context, msgVersion int64) (int64, error) {
+	if msgVersion != 0 {
+		return msgVersion, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(IfMatchMetadataKey)
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	// Aborted is returned as 412 Precondition Failed by the gateway, as for version mismatches.
+	if isWeakETag(values[0]) {
+		return 0, status.Error(codes.Aborted, "weak entity tag doesn't match If-Match")
+	}
+
+	version, ok := parseETag(values[0])
+	if !ok {
+		return 0, status.Error(codes.InvalidArgument, "invalid If-Match")
+	}
+
+	return version, nil
+}
+
+// setETag sends version of the returned user in the header metadata.
+func setETag(ctx context.Context, version int64) {
+	// Fails only when there is no grpc stream in ctx, for example in tests.
+	_ = grpc.SetHeader(ctx, metadata.Pairs(ETagMetadataKey, FormatETag(version)))
+}
@@ -0,0 +1,20 @@
+package users
+
+import (
+	"github.com/toncek345/userservice/access"
+)
+
+// Policies of the Users service. Anyone can sign up and users can read and update themselves,
+// other methods are for admins.
+var Policies = map[string]access.Policy{
+	"/users.Users/AddUser":        access.Public,
+	"/users.Users/GetUser":        access.Self,
+	"/users.Users/UpdateUser":     access.Self,
+	"/users.Users/PatchUser":      access.Self,
+	"/users.Users/DeleteUser":     access.Admin,
+	"/users.Users/UndeleteUser":   access.Admin,
+	"/users.Users/SearchUser":     access.Admin,
+	"/users.Users/GetUserByEmail": access.Admin,
+	// Authenticate checks passwords for other services, users log in with the Auth service.
+	"/users.Users/Authenticate": access.Admin,
+}
@@ -3,12 +3,15 @@ package users
 import (
 	"context"
 	"errors"
-	"log"
+	"time"
 
+	"github.com/toncek345/userservice/logging"
+	"github.com/toncek345/userservice/pagination"
 	pb "github.com/toncek345/userservice/proto"
 	"github.com/toncek345/userservice/service"
 	"github.com/toncek345/userservice/storage"
 
+	"golang.org/x/exp/slog"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -17,11 +20,20 @@ import (
 
 type UserServer struct {
 	UserService service.UserService
+	// Logger logs internal errors, slog.Default is used if it is nil.
+	Logger *slog.Logger
 	pb.UnimplementedUsersServer
 }
 
+// internalError logs err, which is hidden from the client, and returns Internal status. Internal
+// errors are logged only here, lower layers return them wrapped with context.
+func (u *UserServer) internalError(ctx context.Context, msg string, err error) error {
+	logging.FromContext(ctx, u.Logger).ErrorCtx(ctx, msg, "error", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
 func serviceUserToPUser(u *service.User) *pb.User {
-	return &pb.User{
+	user := &pb.User{
 		Id:        u.ID,
 		FirstName: u.FirstName,
 		LastName:  u.LastName,
@@ -29,11 +41,28 @@ func serviceUserToPUser(u *service.User) *pb.User {
 		Country:   u.Country,
 		CreatedAt: timestamppb.New(u.CreatedAt),
 		UpdatedAt: timestamppb.New(u.UpdatedAt),
+		Version:   u.Version,
+	}
+	if !u.DeletedAt.IsZero() {
+		user.DeletedAt = timestamppb.New(u.DeletedAt)
+	}
+
+	return user
+}
+
+// timestampToTime returns zero time for nil timestamps.
+func timestampToTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
 	}
+
+	return ts.AsTime()
 }
 
 func (u *UserServer) AddUser(ctx context.Context, msg *pb.AddUserMessage) (*pb.User, error) {
-	// TODO: some form of validation
+	if err := validateAddUser(msg); err != nil {
+		return nil, err
+	}
 
 	user, err := u.UserService.AddUser(ctx, &service.AddUser{
 		FirstName: msg.FirstName,
@@ -43,75 +72,226 @@ func (u *UserServer) AddUser(ctx context.Context, msg *pb.AddUserMessage) (*pb.U
 		Password:  msg.Password,
 	})
 	if err != nil {
-		log.Printf("adding user failed: %s\n", err)
-		return nil, status.Error(codes.Internal, "internal error")
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+
+		return nil, u.internalError(ctx, "adding user failed", err)
 	}
 
+	setETag(ctx, user.Version)
 	return serviceUserToPUser(user), nil
 }
 
 func (u *UserServer) DeleteUser(ctx context.Context, msg *pb.DeleteUserMessage) (*emptypb.Empty, error) {
-	if err := u.UserService.DeleteUser(ctx, msg.Id); err != nil {
-		log.Printf("deleting user failed: %s\n", err)
-		return nil, status.Error(codes.Internal, "internal error")
+	version, err := expectedVersion(ctx, msg.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.UserService.DeleteUser(ctx, msg.Id, version); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			return nil, status.Error(codes.Aborted, "version mismatch")
+		}
+
+		return nil, u.internalError(ctx, "deleting user failed", err)
 	}
 
 	return &emptypb.Empty{}, nil
 }
 
+func (u *UserServer) UndeleteUser(ctx context.Context, msg *pb.UndeleteUserMessage) (*pb.User, error) {
+	user, err := u.UserService.UndeleteUser(ctx, msg.Id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+
+		return nil, u.internalError(ctx, "undeleting user failed", err)
+	}
+
+	setETag(ctx, user.Version)
+	return serviceUserToPUser(user), nil
+}
+
 func (u *UserServer) UpdateUser(ctx context.Context, msg *pb.UpdateUserMessage) (*pb.User, error) {
-	// TODO: some form of validation
+	if err := validateUpdateUser(msg); err != nil {
+		return nil, err
+	}
+
+	version, err := expectedVersion(ctx, msg.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
 
 	user, err := u.UserService.UpdateUser(ctx, &service.UpdateUser{
-		ID:        msg.Id,
-		FirstName: msg.FirstName,
-		LastName:  msg.LastName,
-		Email:     msg.Email,
-		Country:   msg.Country,
-		Password:  msg.Password,
+		ID:              msg.Id,
+		FirstName:       msg.FirstName,
+		LastName:        msg.LastName,
+		Email:           msg.Email,
+		Country:         msg.Country,
+		Password:        msg.Password,
+		ExpectedVersion: version,
 	})
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			return nil, status.Error(codes.NotFound, "not found")
 		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			return nil, status.Error(codes.Aborted, "version mismatch")
+		}
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
 
-		log.Printf("updating user failed: %s\n", err)
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, u.internalError(ctx, "updating user failed", err)
 	}
 
+	setETag(ctx, user.Version)
 	return serviceUserToPUser(user), nil
 }
 
-func (u *UserServer) SearchUser(ctx context.Context, msg *pb.SearchUserMessage) (*pb.SearchUserResponse, error) {
-	// TODO: extract pagination handling
-	pageSize := 5
-	page := 1
+func (u *UserServer) PatchUser(ctx context.Context, msg *pb.PatchUserMessage) (*pb.User, error) {
+	if err := validatePatchUser(msg); err != nil {
+		return nil, err
+	}
+
+	version, err := expectedVersion(ctx, msg.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := &service.PatchUser{ID: msg.Id, ExpectedVersion: version}
+	for _, path := range msg.UpdateMask.GetPaths() {
+		switch path {
+		case "first_name":
+			patch.FirstName = &msg.User.FirstName
+		case "last_name":
+			patch.LastName = &msg.User.LastName
+		case "email":
+			patch.Email = &msg.User.Email
+		case "country":
+			patch.Country = &msg.User.Country
+		case "password":
+			patch.Password = &msg.User.Password
+		}
+	}
+
+	user, err := u.UserService.PatchUser(ctx, patch)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		if errors.Is(err, storage.ErrVersionMismatch) {
+			return nil, status.Error(codes.Aborted, "version mismatch")
+		}
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
 
-	if msg.Page != 0 {
-		page = int(msg.Page)
+		return nil, u.internalError(ctx, "patching user failed", err)
 	}
 
-	if msg.PageSize != 0 {
-		pageSize = int(msg.PageSize)
+	setETag(ctx, user.Version)
+	return serviceUserToPUser(user), nil
+}
+
+func (u *UserServer) SearchUser(ctx context.Context, msg *pb.SearchUserMessage) (*pb.SearchUserResponse, error) {
+	if err := validateSearchUser(msg); err != nil {
+		return nil, err
 	}
 
-	countryFilter := ""
-	if msg.Filters != nil && msg.Filters.Country != "" {
-		countryFilter = msg.Filters.Country
+	filters := msg.Filters
+	if filters == nil {
+		filters = &pb.UserFilters{}
 	}
 
-	users, err := u.UserService.SearchUser(ctx, int64(page), int64(pageSize), countryFilter)
+	result, err := u.UserService.SearchUser(ctx, &service.SearchUser{
+		Country:          filters.Country,
+		Email:            filters.Email,
+		NamePrefix:       filters.NamePrefix,
+		Query:            filters.Query,
+		CreatedAfter:     timestampToTime(filters.CreatedAfter),
+		CreatedBefore:    timestampToTime(filters.CreatedBefore),
+		UpdatedAfter:     timestampToTime(filters.UpdatedAfter),
+		UpdatedBefore:    timestampToTime(filters.UpdatedBefore),
+		ShowDeleted:      msg.ShowDeleted,
+		OrderBy:          msg.OrderBy,
+		Page:             int64(msg.Page),
+		PageSize:         int64(msg.PageSize),
+		PageToken:        msg.PageToken,
+		IncludeTotalSize: msg.IncludeTotalSize,
+	})
 	if err != nil {
-		log.Printf("searching users failed: %s\n", err)
-		return nil, status.Error(codes.Internal, "internal error")
+		if errors.Is(err, pagination.ErrInvalidToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		if errors.Is(err, storage.ErrInvalidOrderBy) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		return nil, u.internalError(ctx, "searching users failed", err)
 	}
 
-	up := make([]*pb.User, 0, len(users))
-	for _, v := range users {
+	up := make([]*pb.User, 0, len(result.Users))
+	for _, v := range result.Users {
 		up = append(up, serviceUserToPUser(v))
 	}
 
 	return &pb.SearchUserResponse{
-		Users: up,
+		Users:         up,
+		NextPageToken: result.NextPageToken,
+		TotalSize:     result.TotalSize,
 	}, nil
 }
+
+func (u *UserServer) GetUser(ctx context.Context, msg *pb.GetUserMessage) (*pb.User, error) {
+	user, err := u.UserService.GetUser(ctx, msg.Id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+
+		return nil, u.internalError(ctx, "getting user failed", err)
+	}
+
+	setETag(ctx, user.Version)
+	return serviceUserToPUser(user), nil
+}
+
+func (u *UserServer) GetUserByEmail(ctx context.Context, msg *pb.GetUserByEmailMessage) (*pb.User, error) {
+	user, err := u.UserService.GetUserByEmail(ctx, msg.Email)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+
+		return nil, u.internalError(ctx, "getting user by email failed", err)
+	}
+
+	setETag(ctx, user.Version)
+	return serviceUserToPUser(user), nil
+}
+
+func (u *UserServer) Authenticate(ctx context.Context, msg *pb.AuthenticateMessage) (*pb.User, error) {
+	if err := validateAuthenticate(msg); err != nil {
+		return nil, err
+	}
+
+	user, err := u.UserService.Authenticate(ctx, msg.Email, msg.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+		}
+
+		return nil, u.internalError(ctx, "authenticating user failed", err)
+	}
+
+	return serviceUserToPUser(user), nil
+}
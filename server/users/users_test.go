@@ -4,10 +4,20 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/toncek345/userservice/access"
+	"github.com/toncek345/userservice/pagination"
 	pb "github.com/toncek345/userservice/proto"
 	"github.com/toncek345/userservice/server/users"
 	"github.com/toncek345/userservice/service"
+	"github.com/toncek345/userservice/storage"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type testingTCtx struct{}
@@ -27,6 +37,7 @@ func TestAddUser(t *testing.T) {
 		userIn     *pb.AddUserMessage
 		userOut    *pb.User
 		isError    bool
+		code       codes.Code
 		userServer users.UserServer
 	}{
 		{
@@ -34,7 +45,7 @@ func TestAddUser(t *testing.T) {
 			userIn: &pb.AddUserMessage{
 				FirstName: "first_name",
 				LastName:  "last_name",
-				Email:     "email",
+				Email:     "user@example.com",
 				Country:   "US",
 				Password:  "password",
 			},
@@ -42,7 +53,7 @@ func TestAddUser(t *testing.T) {
 				Id:        "gen_id",
 				FirstName: "first_name",
 				LastName:  "last_name",
-				Email:     "email",
+				Email:     "user@example.com",
 				Country:   "US",
 			},
 			userServer: users.UserServer{
@@ -50,7 +61,7 @@ func TestAddUser(t *testing.T) {
 					AddUserFn: func(ctx context.Context, user *service.AddUser) (*service.User, error) {
 						t := testingTFromCtx(ctx)
 						if user.FirstName != "first_name" || user.LastName != "last_name" ||
-							user.Email != "email" || user.Country != "US" || user.Password != "password" {
+							user.Email != "user@example.com" || user.Country != "US" || user.Password != "password" {
 							t.Fatal("user doesn't match")
 						}
 
@@ -58,7 +69,7 @@ func TestAddUser(t *testing.T) {
 							ID:        "gen_id",
 							FirstName: "first_name",
 							LastName:  "last_name",
-							Email:     "email",
+							Email:     "user@example.com",
 							Country:   "US",
 						}, nil
 					},
@@ -68,7 +79,13 @@ func TestAddUser(t *testing.T) {
 		{
 			name:    "fails",
 			isError: true,
-			userIn:  &pb.AddUserMessage{},
+			userIn: &pb.AddUserMessage{
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "user@example.com",
+				Country:   "US",
+				Password:  "password",
+			},
 			userServer: users.UserServer{
 				UserService: &service.UsersMock{
 					AddUserFn: func(ctx context.Context, user *service.AddUser) (*service.User, error) {
@@ -77,6 +94,31 @@ func TestAddUser(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "email taken",
+			isError: true,
+			code:    codes.AlreadyExists,
+			userIn: &pb.AddUserMessage{
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "user@example.com",
+				Country:   "US",
+				Password:  "password",
+			},
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					AddUserFn: func(ctx context.Context, user *service.AddUser) (*service.User, error) {
+						return nil, fmt.Errorf("wrapped: %w", storage.ErrAlreadyExists)
+					},
+				},
+			},
+		},
+		{
+			name:    "invalid",
+			isError: true,
+			code:    codes.InvalidArgument,
+			userIn:  &pb.AddUserMessage{Email: "not an email"},
+		},
 	}
 
 	for _, test := range tests {
@@ -86,6 +128,9 @@ func TestAddUser(t *testing.T) {
 			u, err := test.userServer.AddUser(ctx, test.userIn)
 			if err != nil {
 				if test.isError {
+					if test.code != codes.OK && status.Code(err) != test.code {
+						t.Fatalf("expected code %s, got: %s", test.code, err)
+					}
 					return
 				}
 
@@ -119,7 +164,7 @@ func TestDeleteUserr(t *testing.T) {
 			idIn: "idasdf",
 			userServer: users.UserServer{
 				UserService: &service.UsersMock{
-					DeleteUserFn: func(ctx context.Context, id string) error {
+					DeleteUserFn: func(ctx context.Context, id string, expectedVersion int64) error {
 						t := testingTFromCtx(ctx)
 						if id != "idasdf" {
 							t.Fatal("id doesn't match")
@@ -135,7 +180,7 @@ func TestDeleteUserr(t *testing.T) {
 			isError: true,
 			userServer: users.UserServer{
 				UserService: &service.UsersMock{
-					DeleteUserFn: func(ctx context.Context, id string) error {
+					DeleteUserFn: func(ctx context.Context, id string, expectedVersion int64) error {
 						t := testingTFromCtx(ctx)
 						if id != "idasdf" {
 							t.Fatal("id doesn't match")
@@ -166,6 +211,7 @@ func TestUpdateUser(t *testing.T) {
 		userIn     *pb.UpdateUserMessage
 		userOut    *pb.User
 		isError    bool
+		code       codes.Code
 		userServer users.UserServer
 	}{
 		{
@@ -174,7 +220,7 @@ func TestUpdateUser(t *testing.T) {
 				Id:        "some_id",
 				FirstName: "first_name",
 				LastName:  "last_name",
-				Email:     "email",
+				Email:     "user@example.com",
 				Country:   "US",
 				Password:  "password",
 			},
@@ -182,7 +228,7 @@ func TestUpdateUser(t *testing.T) {
 				Id:        "some_id",
 				FirstName: "first_name",
 				LastName:  "last_name",
-				Email:     "email",
+				Email:     "user@example.com",
 				Country:   "US",
 			},
 			userServer: users.UserServer{
@@ -190,7 +236,7 @@ func TestUpdateUser(t *testing.T) {
 					UpdateUserFn: func(ctx context.Context, user *service.UpdateUser) (*service.User, error) {
 						t := testingTFromCtx(ctx)
 						if user.ID != "some_id" || user.FirstName != "first_name" || user.LastName != "last_name" ||
-							user.Email != "email" || user.Country != "US" || user.Password != "password" {
+							user.Email != "user@example.com" || user.Country != "US" || user.Password != "password" {
 							t.Fatal("user doesn't match")
 						}
 
@@ -198,7 +244,7 @@ func TestUpdateUser(t *testing.T) {
 							ID:        "some_id",
 							FirstName: "first_name",
 							LastName:  "last_name",
-							Email:     "email",
+							Email:     "user@example.com",
 							Country:   "US",
 						}, nil
 					},
@@ -208,7 +254,14 @@ func TestUpdateUser(t *testing.T) {
 		{
 			name:    "fails",
 			isError: true,
-			userIn:  &pb.UpdateUserMessage{},
+			userIn: &pb.UpdateUserMessage{
+				Id:        "some_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "user@example.com",
+				Country:   "US",
+				Password:  "password",
+			},
 			userServer: users.UserServer{
 				UserService: &service.UsersMock{
 					UpdateUserFn: func(ctx context.Context, user *service.UpdateUser) (*service.User, error) {
@@ -217,6 +270,32 @@ func TestUpdateUser(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "email taken",
+			isError: true,
+			code:    codes.AlreadyExists,
+			userIn: &pb.UpdateUserMessage{
+				Id:        "some_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "user@example.com",
+				Country:   "US",
+				Password:  "password",
+			},
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					UpdateUserFn: func(ctx context.Context, user *service.UpdateUser) (*service.User, error) {
+						return nil, fmt.Errorf("wrapped: %w", storage.ErrAlreadyExists)
+					},
+				},
+			},
+		},
+		{
+			name:    "invalid",
+			isError: true,
+			code:    codes.InvalidArgument,
+			userIn:  &pb.UpdateUserMessage{Email: "not an email"},
+		},
 	}
 
 	for _, test := range tests {
@@ -226,6 +305,9 @@ func TestUpdateUser(t *testing.T) {
 			u, err := test.userServer.UpdateUser(ctx, test.userIn)
 			if err != nil {
 				if test.isError {
+					if test.code != codes.OK && status.Code(err) != test.code {
+						t.Fatalf("expected code %s, got: %s", test.code, err)
+					}
 					return
 				}
 
@@ -236,3 +318,563 @@ func TestUpdateUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		idIn       string
+		userOut    *pb.User
+		code       codes.Code
+		userServer users.UserServer
+	}{
+		{
+			name: "works",
+			idIn: "some_id",
+			userOut: &pb.User{
+				Id:        "some_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "email",
+				Country:   "US",
+			},
+			code: codes.OK,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+						t := testingTFromCtx(ctx)
+						if id != "some_id" {
+							t.Fatal("id doesn't match")
+						}
+
+						return &service.User{
+							ID:        "some_id",
+							FirstName: "first_name",
+							LastName:  "last_name",
+							Email:     "email",
+							Country:   "US",
+						}, nil
+					},
+				},
+			},
+		},
+		{
+			name: "not found",
+			idIn: "some_id",
+			code: codes.NotFound,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+						return nil, fmt.Errorf("wrapped: %w", storage.ErrNotFound)
+					},
+				},
+			},
+		},
+		{
+			name: "fails",
+			idIn: "some_id",
+			code: codes.Internal,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+						return nil, fmt.Errorf("error")
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+
+			u, err := test.userServer.GetUser(ctx, &pb.GetUserMessage{Id: test.idIn})
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+
+			matchUsers(t, u, test.userOut)
+		})
+	}
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	tests := []struct {
+		name       string
+		emailIn    string
+		userOut    *pb.User
+		code       codes.Code
+		userServer users.UserServer
+	}{
+		{
+			name:    "works",
+			emailIn: "email",
+			userOut: &pb.User{
+				Id:        "some_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "email",
+				Country:   "US",
+			},
+			code: codes.OK,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					GetUserByEmailFn: func(ctx context.Context, email string) (*service.User, error) {
+						t := testingTFromCtx(ctx)
+						if email != "email" {
+							t.Fatal("email doesn't match")
+						}
+
+						return &service.User{
+							ID:        "some_id",
+							FirstName: "first_name",
+							LastName:  "last_name",
+							Email:     "email",
+							Country:   "US",
+						}, nil
+					},
+				},
+			},
+		},
+		{
+			name:    "not found",
+			emailIn: "email",
+			code:    codes.NotFound,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					GetUserByEmailFn: func(ctx context.Context, email string) (*service.User, error) {
+						return nil, fmt.Errorf("wrapped: %w", storage.ErrNotFound)
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+
+			u, err := test.userServer.GetUserByEmail(ctx, &pb.GetUserByEmailMessage{Email: test.emailIn})
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+
+			matchUsers(t, u, test.userOut)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name       string
+		msgIn      *pb.AuthenticateMessage
+		userOut    *pb.User
+		code       codes.Code
+		userServer users.UserServer
+	}{
+		{
+			name:  "works",
+			msgIn: &pb.AuthenticateMessage{Email: "user@example.com", Password: "password"},
+			userOut: &pb.User{
+				Id:        "some_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "user@example.com",
+				Country:   "US",
+			},
+			code: codes.OK,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					AuthenticateFn: func(ctx context.Context, email, password string) (*service.User, error) {
+						t := testingTFromCtx(ctx)
+						if email != "user@example.com" || password != "password" {
+							t.Fatal("credentials don't match")
+						}
+
+						return &service.User{
+							ID:        "some_id",
+							FirstName: "first_name",
+							LastName:  "last_name",
+							Email:     "user@example.com",
+							Country:   "US",
+						}, nil
+					},
+				},
+			},
+		},
+		{
+			name:  "invalid credentials",
+			msgIn: &pb.AuthenticateMessage{Email: "user@example.com", Password: "password"},
+			code:  codes.Unauthenticated,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					AuthenticateFn: func(ctx context.Context, email, password string) (*service.User, error) {
+						return nil, service.ErrInvalidCredentials
+					},
+				},
+			},
+		},
+		{
+			name:  "missing password",
+			msgIn: &pb.AuthenticateMessage{Email: "user@example.com"},
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:  "fails",
+			msgIn: &pb.AuthenticateMessage{Email: "user@example.com", Password: "password"},
+			code:  codes.Internal,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					AuthenticateFn: func(ctx context.Context, email, password string) (*service.User, error) {
+						return nil, fmt.Errorf("error")
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+
+			u, err := test.userServer.Authenticate(ctx, test.msgIn)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+
+			matchUsers(t, u, test.userOut)
+		})
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		msgIn      *pb.PatchUserMessage
+		code       codes.Code
+		userServer users.UserServer
+	}{
+		{
+			name: "works",
+			msgIn: &pb.PatchUserMessage{
+				Id:         "some_id",
+				User:       &pb.UserPatch{Country: "HR", FirstName: "ignored"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+			},
+			code: codes.OK,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					PatchUserFn: func(ctx context.Context, user *service.PatchUser) (*service.User, error) {
+						t := testingTFromCtx(ctx)
+						if user.ID != "some_id" || user.Country == nil || *user.Country != "HR" ||
+							user.FirstName != nil || user.Password != nil {
+							t.Fatal("user doesn't match")
+						}
+
+						return &service.User{ID: "some_id", Country: "HR"}, nil
+					},
+				},
+			},
+		},
+		{
+			name: "empty mask",
+			msgIn: &pb.PatchUserMessage{
+				Id:   "some_id",
+				User: &pb.UserPatch{Country: "HR"},
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unknown path",
+			msgIn: &pb.PatchUserMessage{
+				Id:         "some_id",
+				User:       &pb.UserPatch{Country: "HR"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}},
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "invalid value",
+			msgIn: &pb.PatchUserMessage{
+				Id:         "some_id",
+				User:       &pb.UserPatch{Country: "XX"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "not found",
+			msgIn: &pb.PatchUserMessage{
+				Id:         "some_id",
+				User:       &pb.UserPatch{Country: "HR"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"country"}},
+			},
+			code: codes.NotFound,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					PatchUserFn: func(ctx context.Context, user *service.PatchUser) (*service.User, error) {
+						return nil, fmt.Errorf("wrapped: %w", storage.ErrNotFound)
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+
+			u, err := test.userServer.PatchUser(ctx, test.msgIn)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if u.Id != test.msgIn.Id {
+				t.Fatal("users do not match")
+			}
+		})
+	}
+}
+
+func TestSearchUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		msgIn      *pb.SearchUserMessage
+		code       codes.Code
+		userServer users.UserServer
+	}{
+		{
+			name: "works",
+			msgIn: &pb.SearchUserMessage{
+				Filters: &pb.UserFilters{
+					Country:      "US",
+					NamePrefix:   "jo",
+					CreatedAfter: timestamppb.New(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)),
+				},
+				OrderBy:          "last_name desc",
+				PageSize:         2,
+				PageToken:        "token",
+				IncludeTotalSize: true,
+			},
+			code: codes.OK,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					SearchUserFn: func(ctx context.Context, search *service.SearchUser) (*service.SearchUserResult, error) {
+						t := testingTFromCtx(ctx)
+						if search.Country != "US" || search.NamePrefix != "jo" || search.OrderBy != "last_name desc" ||
+							!search.CreatedAfter.Equal(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)) || !search.CreatedBefore.IsZero() ||
+							search.PageSize != 2 || search.PageToken != "token" || !search.IncludeTotalSize {
+							t.Fatalf("search doesn't match: %+v", search)
+						}
+
+						return &service.SearchUserResult{
+							Users:         []*service.User{{ID: "some_id"}},
+							NextPageToken: "next",
+							TotalSize:     10,
+						}, nil
+					},
+				},
+			},
+		},
+		{
+			name:  "negative page",
+			msgIn: &pb.SearchUserMessage{Page: -1},
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:  "invalid order by",
+			msgIn: &pb.SearchUserMessage{OrderBy: "password desc"},
+			code:  codes.InvalidArgument,
+		},
+		{
+			name: "inverted time range",
+			msgIn: &pb.SearchUserMessage{Filters: &pb.UserFilters{
+				CreatedAfter:  timestamppb.New(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)),
+				CreatedBefore: timestamppb.New(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)),
+			}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:  "invalid token",
+			msgIn: &pb.SearchUserMessage{PageToken: "token"},
+			code:  codes.InvalidArgument,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					SearchUserFn: func(ctx context.Context, search *service.SearchUser) (*service.SearchUserResult, error) {
+						return nil, pagination.ErrInvalidToken
+					},
+				},
+			},
+		},
+		{
+			name:  "fails",
+			msgIn: &pb.SearchUserMessage{},
+			code:  codes.Internal,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					SearchUserFn: func(ctx context.Context, search *service.SearchUser) (*service.SearchUserResult, error) {
+						return nil, fmt.Errorf("error")
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+
+			res, err := test.userServer.SearchUser(ctx, test.msgIn)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(res.Users) != 1 || res.NextPageToken != "next" || res.TotalSize != 10 {
+				t.Fatalf("unexpected response: %v", res)
+			}
+		})
+	}
+}
+
+func TestUndeleteUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       codes.Code
+		userServer users.UserServer
+	}{
+		{
+			name: "works",
+			code: codes.OK,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					UndeleteUserFn: func(ctx context.Context, id string) (*service.User, error) {
+						t := testingTFromCtx(ctx)
+						if id != "some_id" {
+							t.Fatal("id doesn't match")
+						}
+
+						return &service.User{ID: "some_id"}, nil
+					},
+				},
+			},
+		},
+		{
+			name: "not deleted",
+			code: codes.NotFound,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					UndeleteUserFn: func(ctx context.Context, id string) (*service.User, error) {
+						return nil, fmt.Errorf("wrapped: %w", storage.ErrNotFound)
+					},
+				},
+			},
+		},
+		{
+			name: "email taken",
+			code: codes.AlreadyExists,
+			userServer: users.UserServer{
+				UserService: &service.UsersMock{
+					UndeleteUserFn: func(ctx context.Context, id string) (*service.User, error) {
+						return nil, fmt.Errorf("wrapped: %w", storage.ErrAlreadyExists)
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+
+			u, err := test.userServer.UndeleteUser(ctx, &pb.UndeleteUserMessage{Id: "some_id"})
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, err)
+			}
+			if err != nil {
+				return
+			}
+
+			if u.Id != "some_id" || u.DeletedAt != nil {
+				t.Fatalf("unexpected user: %v", u)
+			}
+		})
+	}
+}
+
+func TestDeleteUserVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgIn    *pb.DeleteUserMessage
+		ifMatch  string
+		version  int64
+		code     codes.Code
+		mismatch bool
+	}{
+		{name: "no version", msgIn: &pb.DeleteUserMessage{Id: "id"}, code: codes.OK},
+		{name: "message version", msgIn: &pb.DeleteUserMessage{Id: "id", ExpectedVersion: 3}, version: 3, code: codes.OK},
+		{name: "if-match", msgIn: &pb.DeleteUserMessage{Id: "id"}, ifMatch: `"4"`, version: 4, code: codes.OK},
+		{name: "weak if-match", msgIn: &pb.DeleteUserMessage{Id: "id"}, ifMatch: `W/"5"`, code: codes.Aborted},
+		{name: "any if-match", msgIn: &pb.DeleteUserMessage{Id: "id"}, ifMatch: "*", code: codes.OK},
+		{name: "invalid if-match", msgIn: &pb.DeleteUserMessage{Id: "id"}, ifMatch: `"abc"`, code: codes.InvalidArgument},
+		{name: "mismatch", msgIn: &pb.DeleteUserMessage{Id: "id", ExpectedVersion: 3}, version: 3, code: codes.Aborted, mismatch: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+			if test.ifMatch != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(users.IfMatchMetadataKey, test.ifMatch))
+			}
+
+			userServer := users.UserServer{
+				UserService: &service.UsersMock{
+					DeleteUserFn: func(ctx context.Context, id string, expectedVersion int64) error {
+						t := testingTFromCtx(ctx)
+						if expectedVersion != test.version {
+							t.Fatalf("expected version %d, got %d", test.version, expectedVersion)
+						}
+						if test.mismatch {
+							return fmt.Errorf("wrapped: %w", storage.ErrVersionMismatch)
+						}
+						return nil
+					},
+				},
+			}
+
+			_, err := userServer.DeleteUser(ctx, test.msgIn)
+			if status.Code(err) != test.code {
+				t.Fatalf("expected code %s, got: %s", test.code, err)
+			}
+		})
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	for method := range access.ServicePolicies(&pb.Users_ServiceDesc, access.Admin) {
+		if _, ok := users.Policies[method]; !ok {
+			t.Errorf("%s has no policy", method)
+		}
+	}
+
+	user := &access.Principal{UserID: "user_id"}
+	if !users.Policies["/users.Users/GetUser"](user, &pb.GetUserMessage{Id: "user_id"}) {
+		t.Fatal("user can't get themselves")
+	}
+	if users.Policies["/users.Users/DeleteUser"](user, &pb.DeleteUserMessage{Id: "user_id"}) {
+		t.Fatal("user can delete themselves")
+	}
+}
@@ -0,0 +1,142 @@
+package users
+
+import (
+	"fmt"
+
+	pb "github.com/toncek345/userservice/proto"
+	"github.com/toncek345/userservice/server/validation"
+	"github.com/toncek345/userservice/storage"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxNameLength = 100
+	// minPasswordLength is a sanity minimum, maxPasswordBytes is a limit imposed by bcrypt.
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+func validateName(v *validation.Validator, field, name string) {
+	if v.Required(field, name) {
+		v.MaxLength(field, name, maxNameLength)
+	}
+}
+
+func validateEmail(v *validation.Validator, field, email string) {
+	if v.Required(field, email) {
+		v.Email(field, email)
+	}
+}
+
+func validateCountry(v *validation.Validator, field, country string) {
+	if v.Required(field, country) {
+		v.Country(field, country)
+	}
+}
+
+func validatePassword(v *validation.Validator, field, password string) {
+	if v.Required(field, password) && v.MinLength(field, password, minPasswordLength) {
+		v.MaxBytes(field, password, maxPasswordBytes)
+	}
+}
+
+func validateAddUser(msg *pb.AddUserMessage) error {
+	v := &validation.Validator{}
+	validateName(v, "first_name", msg.FirstName)
+	validateName(v, "last_name", msg.LastName)
+	validateEmail(v, "email", msg.Email)
+	validateCountry(v, "country", msg.Country)
+	validatePassword(v, "password", msg.Password)
+
+	return v.Err()
+}
+
+func validateUpdateUser(msg *pb.UpdateUserMessage) error {
+	v := &validation.Validator{}
+	v.Required("id", msg.Id)
+	validateName(v, "first_name", msg.FirstName)
+	validateName(v, "last_name", msg.LastName)
+	validateEmail(v, "email", msg.Email)
+	validateCountry(v, "country", msg.Country)
+	validatePassword(v, "password", msg.Password)
+
+	return v.Err()
+}
+
+func validatePatchUser(msg *pb.PatchUserMessage) error {
+	v := &validation.Validator{}
+	v.Required("id", msg.Id)
+
+	paths := msg.UpdateMask.GetPaths()
+	if len(paths) == 0 {
+		v.AddViolation("update_mask", "at least one path is required")
+	}
+
+	user := msg.User
+	if user == nil {
+		user = &pb.UserPatch{}
+	}
+
+	for _, path := range paths {
+		field := "user." + path
+		switch path {
+		case "first_name":
+			validateName(v, field, user.FirstName)
+		case "last_name":
+			validateName(v, field, user.LastName)
+		case "email":
+			validateEmail(v, field, user.Email)
+		case "country":
+			validateCountry(v, field, user.Country)
+		case "password":
+			validatePassword(v, field, user.Password)
+		default:
+			v.AddViolation("update_mask", fmt.Sprintf("unknown path %q", path))
+		}
+	}
+
+	return v.Err()
+}
+
+func validateTimeRange(v *validation.Validator, field string, after, before *timestamppb.Timestamp) {
+	for _, ts := range []*timestamppb.Timestamp{after, before} {
+		if ts != nil && !ts.IsValid() {
+			v.AddViolation(field, "must be a valid timestamp")
+			return
+		}
+	}
+
+	if after != nil && before != nil && !after.AsTime().Before(before.AsTime()) {
+		v.AddViolation(field, "must be before the end of the range")
+	}
+}
+
+func validateSearchUser(msg *pb.SearchUserMessage) error {
+	v := &validation.Validator{}
+	if msg.Page < 0 {
+		v.AddViolation("page", "must not be negative")
+	}
+	if msg.PageSize < 0 {
+		v.AddViolation("page_size", "must not be negative")
+	}
+
+	if _, err := storage.ParseOrderBy(msg.OrderBy); err != nil {
+		v.AddViolation("order_by", err.Error())
+	}
+
+	if f := msg.Filters; f != nil {
+		validateTimeRange(v, "filters.created_after", f.CreatedAfter, f.CreatedBefore)
+		validateTimeRange(v, "filters.updated_after", f.UpdatedAfter, f.UpdatedBefore)
+	}
+
+	return v.Err()
+}
+
+func validateAuthenticate(msg *pb.AuthenticateMessage) error {
+	v := &validation.Validator{}
+	v.Required("email", msg.Email)
+	v.Required("password", msg.Password)
+
+	return v.Err()
+}
@@ -0,0 +1,119 @@
+// validation package collects field violations of incoming messages and converts them into
+// InvalidArgument status errors with errdetails.BadRequest details.
+
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaxEmailLength is the maximum length of an email address as defined by RFC 5321.
+const MaxEmailLength = 254
+
+// Validator accumulates field violations. Zero value is ready to use.
+type Validator struct {
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+// AddViolation records that field is invalid with given description.
+func (v *Validator) AddViolation(field, description string) {
+	v.violations = append(v.violations, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: description,
+	})
+}
+
+// Violations returns all recorded violations.
+func (v *Validator) Violations() []*errdetails.BadRequest_FieldViolation {
+	return v.violations
+}
+
+// Err returns nil if there were no violations, otherwise InvalidArgument status error with
+// BadRequest details listing every violation.
+func (v *Validator) Err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+
+	st := status.New(codes.InvalidArgument, "invalid argument")
+	st, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v.violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid argument")
+	}
+
+	return st.Err()
+}
+
+// Required checks that value isn't empty or only whitespace. It returns false if the check
+// failed so other checks of the same field can be skipped.
+func (v *Validator) Required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.AddViolation(field, "is required")
+		return false
+	}
+
+	return true
+}
+
+// MaxLength checks that value has at most max characters.
+func (v *Validator) MaxLength(field, value string, max int) bool {
+	if utf8.RuneCountInString(value) > max {
+		v.AddViolation(field, fmt.Sprintf("must be at most %d characters long", max))
+		return false
+	}
+
+	return true
+}
+
+// MinLength checks that value has at least min characters.
+func (v *Validator) MinLength(field, value string, min int) bool {
+	if utf8.RuneCountInString(value) < min {
+		v.AddViolation(field, fmt.Sprintf("must be at least %d characters long", min))
+		return false
+	}
+
+	return true
+}
+
+// MaxBytes checks that value has at most max bytes.
+func (v *Validator) MaxBytes(field, value string, max int) bool {
+	if len(value) > max {
+		v.AddViolation(field, fmt.Sprintf("must be at most %d bytes long", max))
+		return false
+	}
+
+	return true
+}
+
+// Email checks that value is a bare RFC 5322 address, without a display name.
+func (v *Validator) Email(field, value string) bool {
+	if len(value) > MaxEmailLength {
+		v.AddViolation(field, fmt.Sprintf("must be at most %d characters long", MaxEmailLength))
+		return false
+	}
+
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Name != "" || addr.Address != value {
+		v.AddViolation(field, "must be a valid email address")
+		return false
+	}
+
+	return true
+}
+
+// Country checks that value is an ISO 3166-1 alpha-2 country code in upper case.
+func (v *Validator) Country(field, value string) bool {
+	if _, ok := countryCodes[value]; !ok {
+		v.AddViolation(field, "must be an ISO 3166-1 alpha-2 country code")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,102 @@
+package validation_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/toncek345/userservice/server/validation"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  func(v *validation.Validator)
+		fields []string
+	}{
+		{
+			name: "valid",
+			check: func(v *validation.Validator) {
+				v.Required("name", "john")
+				v.MaxLength("name", "john", 4)
+				v.MinLength("password", "password", 8)
+				v.Email("email", "john.doe+tag@example.com")
+				v.Country("country", "HR")
+			},
+		},
+		{
+			name: "required",
+			check: func(v *validation.Validator) {
+				v.Required("name", " ")
+			},
+			fields: []string{"name"},
+		},
+		{
+			name: "lengths",
+			check: func(v *validation.Validator) {
+				v.MaxLength("name", "johnny", 4)
+				v.MinLength("password", "pass", 8)
+				v.MaxBytes("password", strings.Repeat("ž", 40), 72)
+			},
+			fields: []string{"name", "password", "password"},
+		},
+		{
+			name: "emails",
+			check: func(v *validation.Validator) {
+				v.Email("a", "email")
+				v.Email("b", "John <john@example.com>")
+				v.Email("c", " john@example.com")
+				v.Email("d", strings.Repeat("a", 250)+"@example.com")
+			},
+			fields: []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "countries",
+			check: func(v *validation.Validator) {
+				v.Country("a", "us")
+				v.Country("b", "XX")
+				v.Country("c", "USA")
+			},
+			fields: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := &validation.Validator{}
+			test.check(v)
+
+			err := v.Err()
+			if len(test.fields) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			st := status.Convert(err)
+			if st.Code() != codes.InvalidArgument {
+				t.Fatalf("expected invalid argument, got: %s", err)
+			}
+			if len(st.Details()) != 1 {
+				t.Fatalf("expected 1 detail, got %d", len(st.Details()))
+			}
+
+			br, ok := st.Details()[0].(*errdetails.BadRequest)
+			if !ok {
+				t.Fatalf("expected bad request detail, got %T", st.Details()[0])
+			}
+			if len(br.FieldViolations) != len(test.fields) {
+				t.Fatalf("expected %d violations, got %d", len(test.fields), len(br.FieldViolations))
+			}
+			for i, f := range test.fields {
+				if br.FieldViolations[i].Field != f {
+					t.Fatalf("expected violation of %s, got %s", f, br.FieldViolations[i].Field)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/toncek345/userservice/logging"
+	"github.com/toncek345/userservice/storage"
+	"github.com/toncek345/userservice/tokens"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+var _ AuthService = (*AuthServiceImpl)(nil)
+var _ AuthService = (*AuthMock)(nil)
+
+type AuthService interface {
+	// Login returns new tokens if password matches the stored hash, otherwise
+	// ErrInvalidCredentials.
+	Login(ctx context.Context, email, password string) (*Tokens, error)
+	// RefreshToken exchanges refresh token for new tokens. Refresh tokens can be used once,
+	// using a token again revokes all tokens issued since the login. Invalid tokens return
+	// ErrInvalidToken.
+	RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error)
+	// Logout revokes refresh token and all tokens issued since the login. Invalid tokens return
+	// ErrInvalidToken.
+	Logout(ctx context.Context, refreshToken string) error
+}
+
+// ErrInvalidToken is returned when refresh token doesn't exist, expired or was revoked.
+var ErrInvalidToken = errors.New("invalid token")
+
+type Tokens struct {
+	AccessToken           string
+	AccessTokenExpiresAt  time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+// Default lifetimes of tokens.
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// refreshTokenBytes is number of random bytes of refresh tokens.
+const refreshTokenBytes = 32
+
+type AuthServiceImpl struct {
+	// UserService authenticates users and checks that they weren't deleted on refresh.
+	UserService  UserService
+	TokenStorage storage.RefreshTokenStorage
+	// Keys sign access tokens.
+	Keys *tokens.KeySet
+	// Issuer is the iss claim of access tokens.
+	Issuer string
+	// AccessTokenTTL and RefreshTokenTTL are lifetimes of tokens, defaults are used if they are
+	// 0. Refresh token expires when it isn't used for its lifetime.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Logger logs logins and reused refresh tokens, slog.Default is used if it is nil.
+	Logger *slog.Logger
+	// Now returns current time, time.Now is used if it is nil.
+	Now func() time.Time
+}
+
+func (a *AuthServiceImpl) logger(ctx context.Context) *slog.Logger {
+	return logging.FromContext(ctx, a.Logger)
+}
+
+func (a *AuthServiceImpl) now() time.Time {
+	if a.Now != nil {
+		return a.Now()
+	}
+
+	return time.Now()
+}
+
+func (a *AuthServiceImpl) accessTokenTTL() time.Duration {
+	if a.AccessTokenTTL == 0 {
+		return DefaultAccessTokenTTL
+	}
+
+	return a.AccessTokenTTL
+}
+
+func (a *AuthServiceImpl) refreshTokenTTL() time.Duration {
+	if a.RefreshTokenTTL == 0 {
+		return DefaultRefreshTokenTTL
+	}
+
+	return a.RefreshTokenTTL
+}
+
+// hashToken returns hash under which refresh token is stored. Tokens are random, so they don't
+// need a salt nor a slow hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issue returns new access token and refresh token of the family, and the refresh token to be
+// stored by the caller.
+func (a *AuthServiceImpl) issue(user *User, familyID string) (*Tokens, *storage.InsertRefreshToken, error) {
+	now := a.now()
+	t := &Tokens{
+		AccessTokenExpiresAt:  now.Add(a.accessTokenTTL()),
+		RefreshTokenExpiresAt: now.Add(a.refreshTokenTTL()),
+	}
+
+	accessToken, err := a.Keys.Sign(&tokens.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    a.Issuer,
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(t.AccessTokenExpiresAt),
+		},
+		Email: user.Email,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("access token: %w", err)
+	}
+	t.AccessToken = accessToken
+
+	b := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, fmt.Errorf("generating refresh token: %w", err)
+	}
+	t.RefreshToken = base64.RawURLEncoding.EncodeToString(b)
+
+	return t, &storage.InsertRefreshToken{
+		TokenHash: hashToken(t.RefreshToken),
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		ExpiresAt: t.RefreshTokenExpiresAt,
+	}, nil
+}
+
+func (a *AuthServiceImpl) Login(ctx context.Context, email, password string) (*Tokens, error) {
+	user, err := a.UserService.Authenticate(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	t, refreshToken, err := a.issue(user, uuid.NewString())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.TokenStorage.InsertRefreshToken(ctx, refreshToken); err != nil {
+		return nil, fmt.Errorf("storing refresh token: %w", err)
+	}
+
+	a.logger(ctx).InfoCtx(ctx, "user logged in", "user_id", user.ID)
+	return t, nil
+}
+
+// revokeFamily revokes tokens of the family, a failure is logged since the request fails with
+// ErrInvalidToken regardless.
+func (a *AuthServiceImpl) revokeFamily(ctx context.Context, token *storage.RefreshTokenModel) {
+	if _, err := a.TokenStorage.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		a.logger(ctx).ErrorCtx(ctx, "revoking refresh tokens failed", "user_id", token.UserID, "error", err)
+	}
+}
+
+func (a *AuthServiceImpl) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	hash := hashToken(refreshToken)
+
+	token, err := a.TokenStorage.GetRefreshToken(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("getting refresh token: %w", err)
+		}
+
+		return nil, ErrInvalidToken
+	}
+	if token.UsedAt != nil || token.RevokedAt != nil {
+		return nil, a.checkReuse(ctx, hash)
+	}
+
+	if !a.now().Before(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := a.UserService.GetUser(ctx, token.UserID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("getting user: %w", err)
+		}
+
+		// Deleted users can't refresh their tokens.
+		a.revokeFamily(ctx, token)
+		return nil, ErrInvalidToken
+	}
+
+	t, next, err := a.issue(user, token.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.TokenStorage.RotateRefreshToken(ctx, hash, next); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("rotating refresh token: %w", err)
+		}
+
+		// The token was used or revoked by a concurrent request.
+		return nil, a.checkReuse(ctx, hash)
+	}
+
+	return t, nil
+}
+
+// checkReuse revokes family of the token if it was already used, since either the user or an
+// attacker has a stolen token, and returns ErrInvalidToken.
+func (a *AuthServiceImpl) checkReuse(ctx context.Context, hash string) error {
+	token, err := a.TokenStorage.GetRefreshToken(ctx, hash)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("getting refresh token: %w", err)
+		}
+
+		return ErrInvalidToken
+	}
+
+	if token.UsedAt != nil {
+		a.logger(ctx).WarnCtx(ctx, "refresh token reused, revoking tokens of the login",
+			"user_id", token.UserID, "used_at", *token.UsedAt)
+		a.revokeFamily(ctx, token)
+	}
+
+	return ErrInvalidToken
+}
+
+func (a *AuthServiceImpl) Logout(ctx context.Context, refreshToken string) error {
+	token, err := a.TokenStorage.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrInvalidToken
+		}
+
+		return fmt.Errorf("getting refresh token: %w", err)
+	}
+
+	if _, err := a.TokenStorage.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		return fmt.Errorf("revoking refresh tokens: %w", err)
+	}
+
+	a.logger(ctx).InfoCtx(ctx, "user logged out", "user_id", token.UserID)
+	return nil
+}
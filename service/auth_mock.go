@@ -0,0 +1,19 @@
+package service
+
+import "context"
+
+type AuthMock struct {
+	LoginFn        func(ctx context.Context, email, password string) (*Tokens, error)
+	RefreshTokenFn func(ctx context.Context, refreshToken string) (*Tokens, error)
+	LogoutFn       func(ctx context.Context, refreshToken string) error
+}
+
+func (m *AuthMock) Login(ctx context.Context, email, password string) (*Tokens, error) {
+	return m.LoginFn(ctx, email, password)
+}
+func (m *AuthMock) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	return m.RefreshTokenFn(ctx, refreshToken)
+}
+func (m *AuthMock) Logout(ctx context.Context, refreshToken string) error {
+	return m.LogoutFn(ctx, refreshToken)
+}
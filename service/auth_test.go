@@ -0,0 +1,211 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/service"
+	"github.com/toncek345/userservice/storage"
+	"github.com/toncek345/userservice/tokens"
+)
+
+type authFixture struct {
+	auth  *service.AuthServiceImpl
+	keys  *tokens.KeySet
+	now   time.Time
+	users map[string]*service.User
+}
+
+func newAuthFixture(t *testing.T) *authFixture {
+	t.Helper()
+
+	f := &authFixture{
+		now: time.Now(),
+		users: map[string]*service.User{
+			"user_id": {ID: "user_id", Email: "john@example.com"},
+		},
+	}
+	now := func() time.Time { return f.now }
+	f.keys = &tokens.KeySet{Now: now}
+	if err := f.keys.Load(); err != nil {
+		t.Fatalf("loading keys: %s", err)
+	}
+
+	f.auth = &service.AuthServiceImpl{
+		UserService: &service.UsersMock{
+			AuthenticateFn: func(ctx context.Context, email, password string) (*service.User, error) {
+				if email != "john@example.com" || password != "password" {
+					return nil, service.ErrInvalidCredentials
+				}
+				return f.users["user_id"], nil
+			},
+			GetUserFn: func(ctx context.Context, id string) (*service.User, error) {
+				u, ok := f.users[id]
+				if !ok {
+					return nil, storage.ErrNotFound
+				}
+				return u, nil
+			},
+		},
+		TokenStorage:    &storage.RefreshTokenStorageMemory{Now: now},
+		Keys:            f.keys,
+		Issuer:          "userservice",
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+		Now:             now,
+	}
+
+	return f
+}
+
+func (f *authFixture) login(t *testing.T) *service.Tokens {
+	t.Helper()
+
+	issued, err := f.auth.Login(context.Background(), "john@example.com", "password")
+	if err != nil {
+		t.Fatalf("login: %s", err)
+	}
+
+	return issued
+}
+
+func (f *authFixture) refresh(t *testing.T, refreshToken string) *service.Tokens {
+	t.Helper()
+
+	issued, err := f.auth.RefreshToken(context.Background(), refreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %s", err)
+	}
+
+	return issued
+}
+
+// revokingTokenStorage revokes family of every token before it is rotated.
+type revokingTokenStorage struct {
+	storage.RefreshTokenStorage
+}
+
+func (s *revokingTokenStorage) RotateRefreshToken(ctx context.Context, tokenHash string, next *storage.InsertRefreshToken) (*storage.RefreshTokenModel, error) {
+	if _, err := s.RefreshTokenStorage.RevokeRefreshTokenFamily(ctx, next.FamilyID); err != nil {
+		return nil, err
+	}
+
+	return s.RefreshTokenStorage.RotateRefreshToken(ctx, tokenHash, next)
+}
+
+func expectInvalidToken(t *testing.T, err error) {
+	t.Helper()
+
+	if !errors.Is(err, service.ErrInvalidToken) {
+		t.Fatalf("expected invalid token, got %v", err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	f := newAuthFixture(t)
+
+	issued := f.login(t)
+	claims, err := f.keys.Parse(issued.AccessToken)
+	if err != nil {
+		t.Fatalf("parsing access token: %s", err)
+	}
+	if claims.Subject != "user_id" || claims.Issuer != "userservice" || claims.Email != "john@example.com" || claims.ID == "" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if !claims.ExpiresAt.Time.Equal(f.now.Add(time.Minute).Truncate(time.Second)) {
+		t.Fatalf("unexpected expiration %s", claims.ExpiresAt)
+	}
+	if issued.RefreshToken == "" || !issued.RefreshTokenExpiresAt.Equal(f.now.Add(time.Hour)) {
+		t.Fatalf("unexpected refresh token: %+v", issued)
+	}
+
+	if _, err := f.auth.Login(context.Background(), "john@example.com", "wrong"); !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("rotation", func(t *testing.T) {
+		f := newAuthFixture(t)
+		first := f.login(t)
+
+		f.now = f.now.Add(30 * time.Minute)
+		second := f.refresh(t, first.RefreshToken)
+		if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+			t.Fatal("tokens aren't rotated")
+		}
+		if !second.RefreshTokenExpiresAt.Equal(f.now.Add(time.Hour)) {
+			t.Fatalf("refresh token expiration isn't extended: %s", second.RefreshTokenExpiresAt)
+		}
+		f.refresh(t, second.RefreshToken)
+	})
+
+	t.Run("reuse revokes the login", func(t *testing.T) {
+		f := newAuthFixture(t)
+		first := f.login(t)
+		second := f.refresh(t, first.RefreshToken)
+		other := f.login(t)
+
+		_, err := f.auth.RefreshToken(ctx, first.RefreshToken)
+		expectInvalidToken(t, err)
+		_, err = f.auth.RefreshToken(ctx, second.RefreshToken)
+		expectInvalidToken(t, err)
+
+		// Other logins of the user aren't affected.
+		f.refresh(t, other.RefreshToken)
+	})
+
+	t.Run("revoked during refresh", func(t *testing.T) {
+		f := newAuthFixture(t)
+		issued := f.login(t)
+		// Reuse is detected by a concurrent request before the token is rotated.
+		f.auth.TokenStorage = &revokingTokenStorage{RefreshTokenStorage: f.auth.TokenStorage}
+
+		_, err := f.auth.RefreshToken(ctx, issued.RefreshToken)
+		expectInvalidToken(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		f := newAuthFixture(t)
+		issued := f.login(t)
+
+		f.now = f.now.Add(time.Hour)
+		_, err := f.auth.RefreshToken(ctx, issued.RefreshToken)
+		expectInvalidToken(t, err)
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		f := newAuthFixture(t)
+		issued := f.login(t)
+
+		delete(f.users, "user_id")
+		_, err := f.auth.RefreshToken(ctx, issued.RefreshToken)
+		expectInvalidToken(t, err)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		f := newAuthFixture(t)
+
+		_, err := f.auth.RefreshToken(ctx, "unknown")
+		expectInvalidToken(t, err)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	f := newAuthFixture(t)
+	first := f.login(t)
+	second := f.refresh(t, first.RefreshToken)
+
+	if err := f.auth.Logout(ctx, second.RefreshToken); err != nil {
+		t.Fatalf("logout: %s", err)
+	}
+	_, err := f.auth.RefreshToken(ctx, second.RefreshToken)
+	expectInvalidToken(t, err)
+
+	expectInvalidToken(t, f.auth.Logout(ctx, "unknown"))
+}
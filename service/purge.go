@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/toncek345/userservice/storage"
+
+	"golang.org/x/exp/slog"
+)
+
+// PurgeWorker periodically and permanently removes users which were deleted longer than
+// Retention ago and expired refresh tokens.
+type PurgeWorker struct {
+	UserStorage storage.UserStorage
+	// TokenStorage is optional, expired refresh tokens aren't deleted if it is nil.
+	TokenStorage storage.RefreshTokenStorage
+	Retention    time.Duration
+	Interval     time.Duration
+	// Logger is slog.Default if it is nil.
+	Logger *slog.Logger
+}
+
+// PurgeOnce removes users deleted before now minus retention and returns their number.
+func (p *PurgeWorker) PurgeOnce(ctx context.Context, now time.Time) (int64, error) {
+	purged, err := p.UserStorage.PurgeDeletedUsers(ctx, now.Add(-p.Retention))
+	if err != nil {
+		return 0, fmt.Errorf("purging deleted users: %w", err)
+	}
+
+	return purged, nil
+}
+
+// DeleteExpiredTokensOnce deletes refresh tokens expired before now and returns their number.
+func (p *PurgeWorker) DeleteExpiredTokensOnce(ctx context.Context, now time.Time) (int64, error) {
+	if p.TokenStorage == nil {
+		return 0, nil
+	}
+
+	deleted, err := p.TokenStorage.DeleteExpiredRefreshTokens(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired refresh tokens: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// Run purges users and refresh tokens every interval until ctx is done.
+func (p *PurgeWorker) Run(ctx context.Context) {
+	logger := p.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("worker", "purge")
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		purged, err := p.PurgeOnce(ctx, now)
+		if err != nil {
+			logger.Error("purging users failed", "error", err)
+		} else if purged > 0 {
+			logger.Info("purged deleted users", "count", purged)
+		}
+
+		deleted, err := p.DeleteExpiredTokensOnce(ctx, now)
+		if err != nil {
+			logger.Error("deleting expired refresh tokens failed", "error", err)
+		} else if deleted > 0 {
+			logger.Info("deleted expired refresh tokens", "count", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/service"
+	"github.com/toncek345/userservice/storage"
+)
+
+func TestPurgeOnce(t *testing.T) {
+	now := time.Date(2023, 2, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		storage *storage.MockUser
+		purged  int64
+		isError bool
+	}{
+		{
+			name: "works",
+			storage: &storage.MockUser{
+				PurgeDeletedUsersFn: func(ctx context.Context, deletedBefore time.Time) (int64, error) {
+					t := testingTFromCtx(ctx)
+					if !deletedBefore.Equal(now.Add(-72 * time.Hour)) {
+						t.Fatalf("unexpected deleted before: %s", deletedBefore)
+					}
+					return 3, nil
+				},
+			},
+			purged: 3,
+		},
+		{
+			name: "fails",
+			storage: &storage.MockUser{
+				PurgeDeletedUsersFn: func(ctx context.Context, deletedBefore time.Time) (int64, error) {
+					return 0, fmt.Errorf("err")
+				},
+			},
+			isError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := testingTToCtx(context.Background(), t)
+			worker := &service.PurgeWorker{UserStorage: test.storage, Retention: 72 * time.Hour}
+
+			purged, err := worker.PurgeOnce(ctx, now)
+			if err != nil {
+				if test.isError {
+					return
+				}
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if purged != test.purged {
+				t.Fatalf("expected %d purged, got %d", test.purged, purged)
+			}
+		})
+	}
+}
+
+func TestDeleteExpiredTokensOnce(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	tokens := &storage.RefreshTokenStorageMemory{}
+	for hash, expiresAt := range map[string]time.Time{"expired": now.Add(-time.Second), "valid": now.Add(time.Hour)} {
+		if _, err := tokens.InsertRefreshToken(ctx, &storage.InsertRefreshToken{TokenHash: hash, FamilyID: hash, ExpiresAt: expiresAt}); err != nil {
+			t.Fatalf("inserting refresh token: %s", err)
+		}
+	}
+
+	worker := &service.PurgeWorker{TokenStorage: tokens}
+	deleted, err := worker.DeleteExpiredTokensOnce(ctx, now)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 deleted, got %d: %v", deleted, err)
+	}
+
+	if deleted, err := (&service.PurgeWorker{}).DeleteExpiredTokensOnce(ctx, now); err != nil || deleted != 0 {
+		t.Fatalf("expected nothing deleted without storage, got %d: %v", deleted, err)
+	}
+}
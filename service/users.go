@@ -2,12 +2,23 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/toncek345/userservice/logging"
+	"github.com/toncek345/userservice/pagination"
 	"github.com/toncek345/userservice/storage"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slog"
 )
 
 var _ UserService = (*UserServiceImpl)(nil)
@@ -15,18 +26,116 @@ var _ UserService = (*UsersMock)(nil)
 
 type UserService interface {
 	AddUser(ctx context.Context, user *AddUser) (*User, error)
-	DeleteUser(ctx context.Context, id string) error
+	// DeleteUser checks expectedVersion against the stored version if it isn't 0.
+	DeleteUser(ctx context.Context, id string, expectedVersion int64) error
+	// UndeleteUser restores a deleted user which wasn't purged yet.
+	UndeleteUser(ctx context.Context, id string) (*User, error)
 	UpdateUser(ctx context.Context, user *UpdateUser) (*User, error)
-	// SearchUser returns a list of users and optinally filters them by country.
-	SearchUser(ctx context.Context, page, page_size int64, country string) ([]*User, error)
+	// PatchUser updates only the fields which are set. Password is hashed only if it is set.
+	PatchUser(ctx context.Context, user *PatchUser) (*User, error)
+	// SearchUser returns a page of users matching the filters.
+	SearchUser(ctx context.Context, search *SearchUser) (*SearchUserResult, error)
+	GetUser(ctx context.Context, id string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// Authenticate returns the user if password matches the stored hash, otherwise
+	// ErrInvalidCredentials.
+	Authenticate(ctx context.Context, email, password string) (*User, error)
 }
 
 type UserServiceImpl struct {
 	UserStorage storage.UserStorage
+	// PasswordCost is bcrypt cost of new password hashes, bcrypt.DefaultCost is used if it is 0.
+	PasswordCost int
+	// Logger logs changes of users, slog.Default is used if it is nil. Errors are returned, not
+	// logged.
+	Logger *slog.Logger
+	// PasswordHashDuration observes how long hashing and comparing of passwords takes in seconds,
+	// e.g. a prometheus histogram. It is optional.
+	PasswordHashDuration Observer
+
+	dummyHashOnce sync.Once
+	dummyHash     []byte
 }
 
+var tracer = otel.Tracer("github.com/toncek345/userservice/service")
+
 var GeneratePasswordHash func(password []byte, cost int) ([]byte, error) = bcrypt.GenerateFromPassword
 
+var ComparePasswordHash func(hashedPassword, password []byte) error = bcrypt.CompareHashAndPassword
+
+// Observer is implemented by prometheus histograms and summaries.
+type Observer interface {
+	Observe(float64)
+}
+
+// ErrInvalidCredentials is returned when email or password doesn't match.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyPasswordHash is compared against when user doesn't exist so that authentication takes the
+// same time regardless of whether the account exists. It is generated with bcrypt.DefaultCost,
+// for other costs a dummy hash is generated on first use.
+var dummyPasswordHash = []byte("$2a$10$WUF7jKrVD8Ac1.o2Ya8bfu8.HR0mVvCvXdrqYD6BET5KAW2qCdCrm")
+
+func (u *UserServiceImpl) logger(ctx context.Context) *slog.Logger {
+	return logging.FromContext(ctx, u.Logger)
+}
+
+func (u *UserServiceImpl) passwordCost() int {
+	if u.PasswordCost == 0 {
+		return bcrypt.DefaultCost
+	}
+
+	return u.PasswordCost
+}
+
+func (u *UserServiceImpl) observeHash(start time.Time) {
+	if u.PasswordHashDuration != nil {
+		u.PasswordHashDuration.Observe(time.Since(start).Seconds())
+	}
+}
+
+func (u *UserServiceImpl) hashPassword(ctx context.Context, password string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword",
+		trace.WithAttributes(attribute.Int("bcrypt.cost", u.passwordCost())))
+	defer span.End()
+	defer u.observeHash(time.Now())
+
+	hash, err := GeneratePasswordHash([]byte(password), u.passwordCost())
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return hash, err
+}
+
+// comparePassword doesn't mark the span as failed on mismatch since wrong passwords are expected.
+func (u *UserServiceImpl) comparePassword(ctx context.Context, hash []byte, password string) error {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+	defer u.observeHash(time.Now())
+
+	return ComparePasswordHash(hash, []byte(password))
+}
+
+// unknownUserHash returns hash with the configured cost which is compared against when user
+// doesn't exist.
+func (u *UserServiceImpl) unknownUserHash() []byte {
+	if u.passwordCost() == bcrypt.DefaultCost {
+		return dummyPasswordHash
+	}
+
+	u.dummyHashOnce.Do(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), u.passwordCost())
+		if err != nil {
+			u.logger(context.Background()).Warn("generating dummy password hash failed", "error", err)
+			hash = dummyPasswordHash
+		}
+		u.dummyHash = hash
+	})
+
+	return u.dummyHash
+}
+
 type User struct {
 	// ID is represented in UUID.
 	ID        string
@@ -36,10 +145,14 @@ type User struct {
 	Country   string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// DeletedAt is zero if user isn't deleted.
+	DeletedAt time.Time
+	// Version is incremented on every modification of the user.
+	Version int64
 }
 
 func storageUserToServiceUser(u *storage.UserModel) *User {
-	return &User{
+	user := &User{
 		ID:        u.ID,
 		FirstName: u.FirstName,
 		LastName:  u.LastName,
@@ -47,7 +160,13 @@ func storageUserToServiceUser(u *storage.UserModel) *User {
 		Country:   u.Country,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
+		Version:   u.Version,
 	}
+	if u.DeletedAt != nil {
+		user.DeletedAt = *u.DeletedAt
+	}
+
+	return user
 }
 
 type AddUser struct {
@@ -59,7 +178,7 @@ type AddUser struct {
 }
 
 func (u *UserServiceImpl) AddUser(ctx context.Context, user *AddUser) (*User, error) {
-	hashedPw, err := GeneratePasswordHash([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPw, err := u.hashPassword(ctx, user.Password)
 	if err != nil {
 		return nil, fmt.Errorf("hashing password: %w", err)
 	}
@@ -77,17 +196,29 @@ func (u *UserServiceImpl) AddUser(ctx context.Context, user *AddUser) (*User, er
 		return nil, fmt.Errorf("adding user: %w", err)
 	}
 
+	u.logger(ctx).InfoCtx(ctx, "user added", "user_id", storageUser.ID)
 	return storageUserToServiceUser(storageUser), nil
 }
 
-func (u *UserServiceImpl) DeleteUser(ctx context.Context, id string) error {
-	if err := u.UserStorage.DeleteUser(ctx, id); err != nil {
+func (u *UserServiceImpl) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	if err := u.UserStorage.DeleteUser(ctx, id, expectedVersion); err != nil {
 		return fmt.Errorf("user storage: %w", err)
 	}
 
+	u.logger(ctx).InfoCtx(ctx, "user deleted", "user_id", id)
 	return nil
 }
 
+func (u *UserServiceImpl) UndeleteUser(ctx context.Context, id string) (*User, error) {
+	storageUser, err := u.UserStorage.UndeleteUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("undeleting user: %w", err)
+	}
+
+	u.logger(ctx).InfoCtx(ctx, "user undeleted", "user_id", id)
+	return storageUserToServiceUser(storageUser), nil
+}
+
 type UpdateUser struct {
 	ID        string
 	FirstName string
@@ -95,10 +226,12 @@ type UpdateUser struct {
 	Email     string
 	Country   string
 	Password  string
+	// ExpectedVersion is checked against the stored version if it isn't 0.
+	ExpectedVersion int64
 }
 
 func (u *UserServiceImpl) UpdateUser(ctx context.Context, user *UpdateUser) (*User, error) {
-	hashedPw, err := GeneratePasswordHash([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPw, err := u.hashPassword(ctx, user.Password)
 	if err != nil {
 		return nil, fmt.Errorf("hashing password: %w", err)
 	}
@@ -106,32 +239,198 @@ func (u *UserServiceImpl) UpdateUser(ctx context.Context, user *UpdateUser) (*Us
 	storageUser, err := u.UserStorage.UpdateUser(
 		ctx,
 		&storage.UpdateUser{
-			ID:        user.ID,
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-			Email:     user.Email,
-			Country:   user.Country,
-			Password:  string(hashedPw),
+			ID:              user.ID,
+			FirstName:       user.FirstName,
+			LastName:        user.LastName,
+			Email:           user.Email,
+			Country:         user.Country,
+			Password:        string(hashedPw),
+			ExpectedVersion: user.ExpectedVersion,
 		})
 	if err != nil {
 		return nil, fmt.Errorf("updating user: %w", err)
 	}
 
+	u.logger(ctx).InfoCtx(ctx, "user updated", "user_id", user.ID, "version", storageUser.Version)
+	return storageUserToServiceUser(storageUser), nil
+}
+
+type PatchUser struct {
+	ID string
+	// Nil fields are left unchanged.
+	FirstName *string
+	LastName  *string
+	Email     *string
+	Country   *string
+	Password  *string
+	// ExpectedVersion is checked against the stored version if it isn't 0.
+	ExpectedVersion int64
+}
+
+func (u *UserServiceImpl) PatchUser(ctx context.Context, user *PatchUser) (*User, error) {
+	patch := &storage.PatchUser{
+		ID:              user.ID,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		Email:           user.Email,
+		Country:         user.Country,
+		ExpectedVersion: user.ExpectedVersion,
+	}
+
+	if user.Password != nil {
+		hashedPw, err := u.hashPassword(ctx, *user.Password)
+		if err != nil {
+			return nil, fmt.Errorf("hashing password: %w", err)
+		}
+
+		hashed := string(hashedPw)
+		patch.Password = &hashed
+	}
+
+	storageUser, err := u.UserStorage.PatchUser(ctx, patch)
+	if err != nil {
+		return nil, fmt.Errorf("patching user: %w", err)
+	}
+
+	u.logger(ctx).InfoCtx(ctx, "user patched", "user_id", user.ID, "version", storageUser.Version)
 	return storageUserToServiceUser(storageUser), nil
 }
 
-func (u *UserServiceImpl) SearchUser(ctx context.Context, page, page_size int64, country string) ([]*User, error) {
-	// TODO: extract pagination in other file/module
+type SearchUser struct {
+	Country       string
+	Email         string
+	NamePrefix    string
+	Query         string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	ShowDeleted   bool
+	// OrderBy is parsed by storage.ParseOrderBy.
+	OrderBy string
+	// Page is 1-based page number. It is ignored when PageToken is set.
+	Page      int64
+	PageSize  int64
+	PageToken string
+	// IncludeTotalSize requests counting of all users matching the filters.
+	IncludeTotalSize bool
+}
+
+type SearchUserResult struct {
+	Users []*User
+	// NextPageToken is empty when there are no more users.
+	NextPageToken string
+	TotalSize     int64
+}
+
+func (u *UserServiceImpl) SearchUser(ctx context.Context, search *SearchUser) (*SearchUserResult, error) {
+	filters := &storage.Filters{
+		Country:       search.Country,
+		Email:         search.Email,
+		NamePrefix:    search.NamePrefix,
+		Query:         search.Query,
+		CreatedAfter:  search.CreatedAfter,
+		CreatedBefore: search.CreatedBefore,
+		UpdatedAfter:  search.UpdatedAfter,
+		UpdatedBefore: search.UpdatedBefore,
+		ShowDeleted:   search.ShowDeleted,
+	}
+
+	order, err := storage.ParseOrderBy(search.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	cursorKey := cursorOrder(order, filters)
+
+	pageSize := pagination.PageSize(search.PageSize)
+	// One more user is fetched to find out whether there is a next page.
+	page := &storage.Page{Order: order, Limit: pageSize + 1}
+	if search.PageToken != "" {
+		cursor, err := pagination.DecodeCursor(search.PageToken, cursorKey)
+		if err != nil {
+			return nil, err
+		}
+		if !storage.ValidSortValues(order, cursor.Values) {
+			return nil, pagination.ErrInvalidToken
+		}
+
+		page.After = cursor.Values
+	} else {
+		page.Offset = pagination.Offset(search.Page, pageSize)
+	}
 
-	usersS, err := u.UserStorage.SearchUser(ctx, &storage.Filters{Country: country}, page_size*(page-1), page_size)
+	usersS, err := u.UserStorage.SearchUser(ctx, filters, page)
 	if err != nil {
 		return nil, fmt.Errorf("search user storage: %w", err)
 	}
 
-	users := make([]*User, 0, len(usersS))
+	result := &SearchUserResult{}
+	if int64(len(usersS)) > pageSize {
+		usersS = usersS[:pageSize]
+		result.NextPageToken = pagination.EncodeCursor(&pagination.Cursor{
+			Order:  cursorKey,
+			Values: storage.SortValues(usersS[len(usersS)-1], order),
+		})
+	}
+
+	result.Users = make([]*User, 0, len(usersS))
 	for _, u := range usersS {
-		users = append(users, storageUserToServiceUser(u))
+		result.Users = append(result.Users, storageUserToServiceUser(u))
+	}
+
+	if search.IncludeTotalSize {
+		result.TotalSize, err = u.UserStorage.CountUsers(ctx, filters)
+		if err != nil {
+			return nil, fmt.Errorf("count users storage: %w", err)
+		}
 	}
 
-	return users, nil
+	return result, nil
+}
+
+// cursorOrder identifies the sort order and filters of a search so that page tokens can't be
+// reused with a different search.
+func cursorOrder(order []storage.SortField, filters *storage.Filters) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v|%+v", order, *filters)
+
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+func (u *UserServiceImpl) GetUser(ctx context.Context, id string) (*User, error) {
+	storageUser, err := u.UserStorage.GetUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+
+	return storageUserToServiceUser(storageUser), nil
+}
+
+func (u *UserServiceImpl) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	storageUser, err := u.UserStorage.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, fmt.Errorf("getting user by email: %w", err)
+	}
+
+	return storageUserToServiceUser(storageUser), nil
+}
+
+func (u *UserServiceImpl) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	storageUser, err := u.UserStorage.GetUserByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("getting user by email: %w", err)
+		}
+
+		u.comparePassword(ctx, u.unknownUserHash(), password)
+		u.logger(ctx).DebugCtx(ctx, "authentication failed", "reason", "unknown email")
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := u.comparePassword(ctx, []byte(storageUser.Password), password); err != nil {
+		u.logger(ctx).DebugCtx(ctx, "authentication failed", "reason", "wrong password", "user_id", storageUser.ID)
+		return nil, ErrInvalidCredentials
+	}
+
+	return storageUserToServiceUser(storageUser), nil
 }
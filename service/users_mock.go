@@ -3,23 +3,48 @@ package service
 import "context"
 
 type UsersMock struct {
-	AddUserFn    func(ctx context.Context, user *AddUser) (*User, error)
-	DeleteUserFn func(ctx context.Context, id string) error
-	UpdateUserFn func(ctx context.Context, user *UpdateUser) (*User, error)
-	SearchUserFn func(ctx context.Context, page, page_size int64, country string) ([]*User, error)
+	AddUserFn        func(ctx context.Context, user *AddUser) (*User, error)
+	DeleteUserFn     func(ctx context.Context, id string, expectedVersion int64) error
+	UndeleteUserFn   func(ctx context.Context, id string) (*User, error)
+	UpdateUserFn     func(ctx context.Context, user *UpdateUser) (*User, error)
+	PatchUserFn      func(ctx context.Context, user *PatchUser) (*User, error)
+	SearchUserFn     func(ctx context.Context, search *SearchUser) (*SearchUserResult, error)
+	GetUserFn        func(ctx context.Context, id string) (*User, error)
+	GetUserByEmailFn func(ctx context.Context, email string) (*User, error)
+	AuthenticateFn   func(ctx context.Context, email, password string) (*User, error)
 }
 
 func (m *UsersMock) AddUser(ctx context.Context, user *AddUser) (*User, error) {
 	return m.AddUserFn(ctx, user)
 }
 
-func (m *UsersMock) DeleteUser(ctx context.Context, id string) error {
-	return m.DeleteUserFn(ctx, id)
+func (m *UsersMock) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	return m.DeleteUserFn(ctx, id, expectedVersion)
 }
 
 func (m *UsersMock) UpdateUser(ctx context.Context, user *UpdateUser) (*User, error) {
 	return m.UpdateUserFn(ctx, user)
 }
-func (m *UsersMock) SearchUser(ctx context.Context, page, page_size int64, country string) ([]*User, error) {
-	return m.SearchUserFn(ctx, page, page_size, country)
+func (m *UsersMock) SearchUser(ctx context.Context, search *SearchUser) (*SearchUserResult, error) {
+	return m.SearchUserFn(ctx, search)
+}
+
+func (m *UsersMock) GetUser(ctx context.Context, id string) (*User, error) {
+	return m.GetUserFn(ctx, id)
+}
+
+func (m *UsersMock) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return m.GetUserByEmailFn(ctx, email)
+}
+
+func (m *UsersMock) Authenticate(ctx context.Context, email, password string) (*User, error) {
+	return m.AuthenticateFn(ctx, email, password)
+}
+
+func (m *UsersMock) PatchUser(ctx context.Context, user *PatchUser) (*User, error) {
+	return m.PatchUserFn(ctx, user)
+}
+
+func (m *UsersMock) UndeleteUser(ctx context.Context, id string) (*User, error) {
+	return m.UndeleteUserFn(ctx, id)
 }
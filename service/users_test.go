@@ -2,11 +2,20 @@ package service_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/toncek345/userservice/pagination"
 	"github.com/toncek345/userservice/service"
 	"github.com/toncek345/userservice/storage"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type testingTCtx struct{}
@@ -68,6 +77,44 @@ func TestAddUser(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "uses password cost",
+			userIn: &service.AddUser{
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "email",
+				Country:   "US",
+				Password:  "password",
+			},
+			userOut: &service.User{
+				ID:        "gen_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "email",
+				Country:   "US",
+			},
+			hasherFunc: func(password []byte, cost int) ([]byte, error) {
+				if cost != 12 {
+					return nil, fmt.Errorf("unexpected cost %d", cost)
+				}
+				return []byte("hashed_password"), nil
+			},
+			service: &service.UserServiceImpl{
+				PasswordCost: 12,
+				UserStorage: &storage.MockUser{
+					InsertUserFn: func(ctx context.Context, user *storage.InsertUser) (*storage.UserModel, error) {
+						return &storage.UserModel{
+							ID:        "gen_id",
+							FirstName: "first_name",
+							LastName:  "last_name",
+							Email:     "email",
+							Country:   "US",
+							Password:  user.Password,
+						}, nil
+					},
+				},
+			},
+		},
 		{
 			name: "fails hash gen",
 			userIn: &service.AddUser{
@@ -144,8 +191,8 @@ func TestDeleteUser(t *testing.T) {
 			name: "works",
 			idIn: "id",
 			service: &service.UserServiceImpl{
-				&storage.MockUser{
-					DeleteUserFn: func(ctx context.Context, id string) error {
+				UserStorage: &storage.MockUser{
+					DeleteUserFn: func(ctx context.Context, id string, expectedVersion int64) error {
 						t := testingTFromCtx(ctx)
 						if id != "id" {
 							t.Fatal("wrong id")
@@ -161,8 +208,8 @@ func TestDeleteUser(t *testing.T) {
 			idIn:    "id",
 			isError: true,
 			service: &service.UserServiceImpl{
-				&storage.MockUser{
-					DeleteUserFn: func(ctx context.Context, id string) error {
+				UserStorage: &storage.MockUser{
+					DeleteUserFn: func(ctx context.Context, id string, expectedVersion int64) error {
 						return fmt.Errorf("err")
 					},
 				},
@@ -173,7 +220,7 @@ func TestDeleteUser(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			ctx := context.WithValue(context.Background(), testingTCtx{}, t)
-			if err := test.service.DeleteUser(ctx, test.idIn); err != nil {
+			if err := test.service.DeleteUser(ctx, test.idIn, 0); err != nil {
 				if test.isError {
 					return
 				}
@@ -289,3 +336,466 @@ func TestUpdateUser(t *testing.T) {
 	}
 
 }
+
+func TestGetUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		idIn    string
+		userOut *service.User
+		service service.UserService
+		isError bool
+	}{
+		{
+			name: "works",
+			idIn: "some_id",
+			userOut: &service.User{
+				ID:        "some_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "email",
+				Country:   "US",
+			},
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					GetUserFn: func(ctx context.Context, id string) (*storage.UserModel, error) {
+						t := testingTFromCtx(ctx)
+						if id != "some_id" {
+							t.Fatal("wrong id")
+						}
+
+						return &storage.UserModel{
+							ID:        "some_id",
+							FirstName: "first_name",
+							LastName:  "last_name",
+							Email:     "email",
+							Country:   "US",
+							Password:  "hashed_password!!!",
+						}, nil
+					},
+				},
+			},
+		},
+		{
+			name:    "fails",
+			idIn:    "some_id",
+			isError: true,
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					GetUserFn: func(ctx context.Context, id string) (*storage.UserModel, error) {
+						return nil, storage.ErrNotFound
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), testingTCtx{}, t)
+			u, err := test.service.GetUser(ctx, test.idIn)
+			if err != nil {
+				if test.isError {
+					if !errors.Is(err, storage.ErrNotFound) {
+						t.Fatalf("expected not found, got: %s", err)
+					}
+					return
+				}
+				t.Fatalf("unexpeted error: %s", err)
+			}
+
+			matchUsers(t, u, test.userOut)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		userOut    *service.User
+		service    service.UserService
+		compareFn  func(hashedPassword, password []byte) error
+		compareCnt int
+		err        error
+	}{
+		{
+			name:     "works",
+			password: "password",
+			userOut: &service.User{
+				ID:        "some_id",
+				FirstName: "first_name",
+				LastName:  "last_name",
+				Email:     "email",
+				Country:   "US",
+			},
+			compareFn: func(hashedPassword, password []byte) error {
+				if string(hashedPassword) != "hashed_password!!!" || string(password) != "password" {
+					return fmt.Errorf("mismatch")
+				}
+				return nil
+			},
+			compareCnt: 1,
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					GetUserByEmailFn: func(ctx context.Context, email string) (*storage.UserModel, error) {
+						t := testingTFromCtx(ctx)
+						if email != "email" {
+							t.Fatal("wrong email")
+						}
+
+						return &storage.UserModel{
+							ID:        "some_id",
+							FirstName: "first_name",
+							LastName:  "last_name",
+							Email:     "email",
+							Country:   "US",
+							Password:  "hashed_password!!!",
+						}, nil
+					},
+				},
+			},
+		},
+		{
+			name:     "wrong password",
+			password: "wrong",
+			compareFn: func(hashedPassword, password []byte) error {
+				return fmt.Errorf("mismatch")
+			},
+			compareCnt: 1,
+			err:        service.ErrInvalidCredentials,
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					GetUserByEmailFn: func(ctx context.Context, email string) (*storage.UserModel, error) {
+						return &storage.UserModel{ID: "some_id", Password: "hashed_password!!!"}, nil
+					},
+				},
+			},
+		},
+		{
+			name:     "user doesn't exist",
+			password: "password",
+			compareFn: func(hashedPassword, password []byte) error {
+				return fmt.Errorf("mismatch")
+			},
+			// Hash is still compared so that the timing doesn't reveal whether user exists.
+			compareCnt: 1,
+			err:        service.ErrInvalidCredentials,
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					GetUserByEmailFn: func(ctx context.Context, email string) (*storage.UserModel, error) {
+						return nil, storage.ErrNotFound
+					},
+				},
+			},
+		},
+		{
+			name:     "storage fails",
+			password: "password",
+			compareFn: func(hashedPassword, password []byte) error {
+				return nil
+			},
+			err: errStorage,
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					GetUserByEmailFn: func(ctx context.Context, email string) (*storage.UserModel, error) {
+						return nil, errStorage
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), testingTCtx{}, t)
+
+			compareCnt := 0
+			service.ComparePasswordHash = func(hashedPassword, password []byte) error {
+				compareCnt++
+				return test.compareFn(hashedPassword, password)
+			}
+
+			u, err := test.service.Authenticate(ctx, "email", test.password)
+			if compareCnt != test.compareCnt {
+				t.Fatalf("expected %d hash comparisons, got %d", test.compareCnt, compareCnt)
+			}
+			if err != nil {
+				if test.err != nil {
+					if !errors.Is(err, test.err) {
+						t.Fatalf("expected %s, got: %s", test.err, err)
+					}
+					return
+				}
+				t.Fatalf("unexpeted error: %s", err)
+			}
+
+			matchUsers(t, u, test.userOut)
+		})
+	}
+}
+
+var errStorage = errors.New("storage error")
+
+func TestPatchUser(t *testing.T) {
+	country := "HR"
+	password := "password"
+
+	tests := []struct {
+		name       string
+		userIn     *service.PatchUser
+		hasherFunc func(password []byte, cost int) ([]byte, error)
+		service    service.UserService
+		isError    bool
+	}{
+		{
+			name:   "without password",
+			userIn: &service.PatchUser{ID: "some_id", Country: &country},
+			hasherFunc: func(password []byte, cost int) ([]byte, error) {
+				return nil, fmt.Errorf("password shouldn't be hashed")
+			},
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					PatchUserFn: func(ctx context.Context, user *storage.PatchUser) (*storage.UserModel, error) {
+						t := testingTFromCtx(ctx)
+						if user.ID != "some_id" || user.Country == nil || *user.Country != "HR" ||
+							user.FirstName != nil || user.Password != nil {
+							t.Fatal("user doesn't match patch user")
+						}
+
+						return &storage.UserModel{ID: "some_id", Country: "HR"}, nil
+					},
+				},
+			},
+		},
+		{
+			name:   "with password",
+			userIn: &service.PatchUser{ID: "some_id", Password: &password},
+			hasherFunc: func(password []byte, cost int) ([]byte, error) {
+				return []byte("hashed_password!!!"), nil
+			},
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					PatchUserFn: func(ctx context.Context, user *storage.PatchUser) (*storage.UserModel, error) {
+						t := testingTFromCtx(ctx)
+						if user.Password == nil || *user.Password != "hashed_password!!!" {
+							t.Fatal("password isn't hashed")
+						}
+
+						return &storage.UserModel{ID: "some_id"}, nil
+					},
+				},
+			},
+		},
+		{
+			name:    "fails",
+			userIn:  &service.PatchUser{ID: "some_id", Country: &country},
+			isError: true,
+			service: &service.UserServiceImpl{
+				UserStorage: &storage.MockUser{
+					PatchUserFn: func(ctx context.Context, user *storage.PatchUser) (*storage.UserModel, error) {
+						return nil, storage.ErrNotFound
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), testingTCtx{}, t)
+			service.GeneratePasswordHash = test.hasherFunc
+			u, err := test.service.PatchUser(ctx, test.userIn)
+			if err != nil {
+				if test.isError {
+					return
+				}
+				t.Fatalf("unexpeted error: %s", err)
+			}
+
+			if u.ID != test.userIn.ID {
+				t.Fatal("users do not match")
+			}
+		})
+	}
+}
+
+func TestSearchUser(t *testing.T) {
+	created := time.Date(2023, 1, 2, 3, 4, 5, 6000, time.UTC)
+	storageUsers := func(n int) []*storage.UserModel {
+		users := []*storage.UserModel{}
+		for i := 0; i < n; i++ {
+			users = append(users, &storage.UserModel{ID: fmt.Sprintf("id_%d", i), CreatedAt: created})
+		}
+		return users
+	}
+
+	var nextPageToken string
+	mock := &storage.MockUser{
+		SearchUserFn: func(ctx context.Context, filters *storage.Filters, page *storage.Page) ([]*storage.UserModel, error) {
+			t := testingTFromCtx(ctx)
+			if filters.Country != "US" || page.Limit != 3 {
+				t.Fatalf("unexpected search: %+v %+v", filters, page)
+			}
+
+			if page.After == nil {
+				if page.Offset != 2 {
+					t.Fatalf("unexpected offset %d", page.Offset)
+				}
+				return storageUsers(3), nil
+			}
+
+			if page.After[0] != "2023-01-02 03:04:05.000006" || page.After[1] != "id_1" {
+				t.Fatalf("unexpected cursor %v", page.After)
+			}
+			return storageUsers(1), nil
+		},
+		CountUsersFn: func(ctx context.Context, filters *storage.Filters) (int64, error) {
+			return 42, nil
+		},
+	}
+
+	tests := []struct {
+		name      string
+		searchIn  func() *service.SearchUser
+		users     int
+		totalSize int64
+		hasNext   bool
+		err       error
+	}{
+		{
+			name: "first page by number",
+			searchIn: func() *service.SearchUser {
+				return &service.SearchUser{Country: "US", Page: 2, PageSize: 2, IncludeTotalSize: true}
+			},
+			users:     2,
+			totalSize: 42,
+			hasNext:   true,
+		},
+		{
+			name: "next page by token",
+			searchIn: func() *service.SearchUser {
+				return &service.SearchUser{Country: "US", PageSize: 2, PageToken: nextPageToken}
+			},
+			users: 1,
+		},
+		{
+			name: "token of other filters",
+			searchIn: func() *service.SearchUser {
+				return &service.SearchUser{Country: "HR", PageSize: 2, PageToken: nextPageToken}
+			},
+			err: pagination.ErrInvalidToken,
+		},
+		{
+			name: "bad token values",
+			searchIn: func() *service.SearchUser {
+				return &service.SearchUser{
+					Country:   "US",
+					PageSize:  2,
+					PageToken: pagination.EncodeCursor(&pagination.Cursor{Order: "x", Values: []string{"a"}}),
+				}
+			},
+			err: pagination.ErrInvalidToken,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), testingTCtx{}, t)
+			s := &service.UserServiceImpl{UserStorage: mock}
+
+			res, err := s.SearchUser(ctx, test.searchIn())
+			if err != nil {
+				if test.err != nil && errors.Is(err, test.err) {
+					return
+				}
+				t.Fatalf("unexpeted error: %s", err)
+			}
+			if test.err != nil {
+				t.Fatal("expected error")
+			}
+
+			if len(res.Users) != test.users || res.TotalSize != test.totalSize || (res.NextPageToken != "") != test.hasNext {
+				t.Fatalf("unexpected result: %+v", res)
+			}
+			if res.NextPageToken != "" {
+				nextPageToken = res.NextPageToken
+			}
+		})
+	}
+}
+
+type observer struct {
+	observed []float64
+}
+
+func (o *observer) Observe(v float64) {
+	o.observed = append(o.observed, v)
+}
+
+func TestPasswordHashDuration(t *testing.T) {
+	service.GeneratePasswordHash = bcrypt.GenerateFromPassword
+	service.ComparePasswordHash = bcrypt.CompareHashAndPassword
+
+	o := &observer{}
+	s := &service.UserServiceImpl{
+		PasswordCost:         bcrypt.MinCost,
+		PasswordHashDuration: o,
+		UserStorage: &storage.MockUser{
+			InsertUserFn: func(ctx context.Context, user *storage.InsertUser) (*storage.UserModel, error) {
+				return &storage.UserModel{Email: user.Email, Password: user.Password}, nil
+			},
+			GetUserByEmailFn: func(ctx context.Context, email string) (*storage.UserModel, error) {
+				return nil, storage.ErrNotFound
+			},
+		},
+	}
+
+	if _, err := s.AddUser(context.Background(), &service.AddUser{Password: "password"}); err != nil {
+		t.Fatalf("add user: %s", err)
+	}
+	if _, err := s.Authenticate(context.Background(), "missing@example.com", "password"); !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+
+	if len(o.observed) != 2 {
+		t.Fatalf("expected hashing and comparing to be observed, got %v", o.observed)
+	}
+}
+
+func TestPasswordHashSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	service.GeneratePasswordHash = bcrypt.GenerateFromPassword
+	service.ComparePasswordHash = bcrypt.CompareHashAndPassword
+
+	s := &service.UserServiceImpl{
+		PasswordCost: bcrypt.MinCost,
+		UserStorage: &storage.MockUser{
+			InsertUserFn: func(ctx context.Context, user *storage.InsertUser) (*storage.UserModel, error) {
+				return &storage.UserModel{Email: user.Email, Password: user.Password}, nil
+			},
+			GetUserByEmailFn: func(ctx context.Context, email string) (*storage.UserModel, error) {
+				return nil, storage.ErrNotFound
+			},
+		},
+	}
+
+	if _, err := s.AddUser(context.Background(), &service.AddUser{Password: "password"}); err != nil {
+		t.Fatalf("add user: %s", err)
+	}
+	// Unknown users are compared against a dummy hash, which is traced as well.
+	if _, err := s.Authenticate(context.Background(), "missing@example.com", "password"); !errors.Is(err, service.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials, got %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "bcrypt.GenerateFromPassword" || spans[1].Name() != "bcrypt.CompareHashAndPassword" {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+	attrs := attribute.NewSet(spans[0].Attributes()...)
+	if cost, _ := attrs.Value("bcrypt.cost"); cost.AsInt64() != int64(bcrypt.MinCost) {
+		t.Fatalf("expected bcrypt.cost %d, got %v", bcrypt.MinCost, cost.AsInt64())
+	}
+}
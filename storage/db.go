@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Pinger is implemented by *sqlx.DB and *sql.DB.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// WaitForDB pings db until it responds or ctx is done. Failed pings are retried with
+// exponential backoff which starts at minBackoff and is capped at maxBackoff. Failures are
+// logged with slog.Default.
+func WaitForDB(ctx context.Context, db Pinger, minBackoff, maxBackoff time.Duration) error {
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		slog.Warn("db is not available", "attempt", attempt, "retry_in", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("waiting for db: %w (last error: %s)", ctx.Err(), err)
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
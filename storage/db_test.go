@@ -0,0 +1,72 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/storage"
+)
+
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) PingContext(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestWaitForDB(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		timeout  time.Duration
+		isError  bool
+	}{
+		{
+			name:    "available",
+			timeout: time.Second,
+		},
+		{
+			name:     "retries",
+			failures: 3,
+			timeout:  time.Second,
+		},
+		{
+			name:     "deadline",
+			failures: 1000,
+			timeout:  50 * time.Millisecond,
+			isError:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+
+			pings := 0
+			db := pingerFunc(func(ctx context.Context) error {
+				pings++
+				if pings <= test.failures {
+					return errors.New("connection refused")
+				}
+				return nil
+			})
+
+			err := storage.WaitForDB(ctx, db, time.Millisecond, 4*time.Millisecond)
+			if err != nil {
+				if test.isError {
+					return
+				}
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.isError {
+				t.Fatal("expected error")
+			}
+
+			if pings != test.failures+1 {
+				t.Fatalf("expected %d pings, got %d", test.failures+1, pings)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var _ UserStorage = (*UserStorageMemory)(nil)
+
+// UserStorageMemory is a thread-safe in-memory UserStorage with the same semantics as
+// UserStorageSQL. It is meant for tests and local development. Zero value is ready to use.
+type UserStorageMemory struct {
+	// Now returns current time, time.Now is used if it is nil.
+	Now func() time.Time
+
+	mu    sync.RWMutex
+	users map[string]*UserModel
+}
+
+func (us *UserStorageMemory) now() time.Time {
+	now := time.Now
+	if us.Now != nil {
+		now = us.Now
+	}
+
+	// Postgres stores timestamps with microsecond precision and without time zone.
+	return now().UTC().Truncate(time.Microsecond)
+}
+
+func copyUser(u *UserModel) *UserModel {
+	c := *u
+	if u.DeletedAt != nil {
+		deletedAt := *u.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+
+	return &c
+}
+
+// emailTaken reports whether other not deleted user than id has the email. Callers must hold
+// the lock.
+func (us *UserStorageMemory) emailTaken(email, id string) bool {
+	for _, u := range us.users {
+		if u.ID != id && u.DeletedAt == nil && NormalizeEmail(u.Email) == NormalizeEmail(email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// active returns not deleted user with version check. Callers must hold the lock.
+func (us *UserStorageMemory) active(id string, expectedVersion int64) (*UserModel, error) {
+	u, ok := us.users[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	if expectedVersion != 0 && u.Version != expectedVersion {
+		return nil, ErrVersionMismatch
+	}
+
+	return u, nil
+}
+
+func (us *UserStorageMemory) InsertUser(ctx context.Context, user *InsertUser) (*UserModel, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if us.users == nil {
+		us.users = map[string]*UserModel{}
+	}
+
+	if us.emailTaken(user.Email, "") {
+		return nil, ErrAlreadyExists
+	}
+
+	now := us.now()
+	u := &UserModel{
+		ID:        uuid.NewString(),
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     NormalizeEmail(user.Email),
+		Country:   user.Country,
+		Password:  user.Password,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	us.users[u.ID] = u
+
+	return copyUser(u), nil
+}
+
+func (us *UserStorageMemory) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	u, err := us.active(id, expectedVersion)
+	if err != nil {
+		return err
+	}
+
+	now := us.now()
+	u.DeletedAt = &now
+	u.Version++
+
+	return nil
+}
+
+func (us *UserStorageMemory) UndeleteUser(ctx context.Context, id string) (*UserModel, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	u, ok := us.users[id]
+	if !ok || u.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	if us.emailTaken(u.Email, u.ID) {
+		return nil, ErrAlreadyExists
+	}
+
+	u.DeletedAt = nil
+	u.UpdatedAt = us.now()
+	u.Version++
+
+	return copyUser(u), nil
+}
+
+func (us *UserStorageMemory) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	var purged int64
+	for id, u := range us.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(deletedBefore) {
+			delete(us.users, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (us *UserStorageMemory) UpdateUser(ctx context.Context, user *UpdateUser) (*UserModel, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	u, err := us.active(user.ID, user.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if us.emailTaken(user.Email, u.ID) {
+		return nil, ErrAlreadyExists
+	}
+
+	u.FirstName = user.FirstName
+	u.LastName = user.LastName
+	u.Email = NormalizeEmail(user.Email)
+	u.Country = user.Country
+	u.Password = user.Password
+	u.UpdatedAt = us.now()
+	u.Version++
+
+	return copyUser(u), nil
+}
+
+func (us *UserStorageMemory) PatchUser(ctx context.Context, user *PatchUser) (*UserModel, error) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	u, err := us.active(user.ID, user.ExpectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != nil && us.emailTaken(*user.Email, u.ID) {
+		return nil, ErrAlreadyExists
+	}
+
+	if user.FirstName != nil {
+		u.FirstName = *user.FirstName
+	}
+	if user.LastName != nil {
+		u.LastName = *user.LastName
+	}
+	if user.Email != nil {
+		u.Email = NormalizeEmail(*user.Email)
+	}
+	if user.Country != nil {
+		u.Country = *user.Country
+	}
+	if user.Password != nil {
+		u.Password = *user.Password
+	}
+	u.UpdatedAt = us.now()
+	u.Version++
+
+	return copyUser(u), nil
+}
+
+func (us *UserStorageMemory) GetUser(ctx context.Context, id string) (*UserModel, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	u, err := us.active(id, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyUser(u), nil
+}
+
+func (us *UserStorageMemory) GetUserByEmail(ctx context.Context, email string) (*UserModel, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	for _, u := range us.users {
+		if u.DeletedAt == nil && NormalizeEmail(u.Email) == NormalizeEmail(email) {
+			return copyUser(u), nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func matchesFilters(u *UserModel, filters *Filters) bool {
+	if !filters.ShowDeleted && u.DeletedAt != nil {
+		return false
+	}
+
+	if filters.Country != "" && !strings.EqualFold(u.Country, filters.Country) {
+		return false
+	}
+
+	if filters.Email != "" && NormalizeEmail(u.Email) != NormalizeEmail(filters.Email) {
+		return false
+	}
+
+	if filters.NamePrefix != "" {
+		prefix := strings.ToLower(filters.NamePrefix)
+		if !strings.HasPrefix(strings.ToLower(u.FirstName), prefix) &&
+			!strings.HasPrefix(strings.ToLower(u.LastName), prefix) {
+			return false
+		}
+	}
+
+	for _, word := range strings.Fields(strings.ToLower(filters.Query)) {
+		if !strings.Contains(strings.ToLower(u.FirstName), word) &&
+			!strings.Contains(strings.ToLower(u.LastName), word) &&
+			!strings.Contains(strings.ToLower(u.Email), word) {
+			return false
+		}
+	}
+
+	if !filters.CreatedAfter.IsZero() && u.CreatedAt.Before(filters.CreatedAfter) {
+		return false
+	}
+	if !filters.CreatedBefore.IsZero() && !u.CreatedAt.Before(filters.CreatedBefore) {
+		return false
+	}
+	if !filters.UpdatedAfter.IsZero() && u.UpdatedAt.Before(filters.UpdatedAfter) {
+		return false
+	}
+	if !filters.UpdatedBefore.IsZero() && !u.UpdatedAt.Before(filters.UpdatedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// compareSortValue compares column of u with value formatted as in SortValues. Text is compared
+// byte-wise, which SQL storages match with binary collation.
+func compareSortValue(u *UserModel, column, value string) int {
+	switch column {
+	case "created_at", "updated_at":
+		t, err := time.Parse(sortTimeFormat, value)
+		if err != nil {
+			return 0
+		}
+
+		ut := u.CreatedAt
+		if column == "updated_at" {
+			ut = u.UpdatedAt
+		}
+		switch {
+		case ut.Before(t):
+			return -1
+		case ut.After(t):
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(sortValue(u, column), value)
+}
+
+// compareUsers compares users in order, as compareSortValue compares them with a cursor.
+func compareUsers(a, b *UserModel, order []SortField) int {
+	for _, f := range order {
+		c := compareSortValue(a, f.Column, sortValue(b, f.Column))
+		if f.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// afterCursor reports whether u follows the cursor in order.
+func afterCursor(u *UserModel, order []SortField, after []string) bool {
+	for i, f := range order {
+		c := compareSortValue(u, f.Column, after[i])
+		if f.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c > 0
+		}
+	}
+
+	return false
+}
+
+func (us *UserStorageMemory) SearchUser(ctx context.Context, filters *Filters, page *Page) ([]*UserModel, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	order := page.Order
+	if len(order) == 0 {
+		order = UsersOrder
+	}
+
+	users := []*UserModel{}
+	for _, u := range us.users {
+		if !matchesFilters(u, filters) {
+			continue
+		}
+		if len(page.After) > 0 && !afterCursor(u, order, page.After) {
+			continue
+		}
+		users = append(users, u)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return compareUsers(users[i], users[j], order) < 0 })
+
+	if len(page.After) == 0 {
+		if page.Offset >= int64(len(users)) {
+			users = nil
+		} else {
+			users = users[page.Offset:]
+		}
+	}
+	if int64(len(users)) > page.Limit {
+		users = users[:page.Limit]
+	}
+
+	result := make([]*UserModel, 0, len(users))
+	for _, u := range users {
+		result = append(result, copyUser(u))
+	}
+
+	return result, nil
+}
+
+func (us *UserStorageMemory) CountUsers(ctx context.Context, filters *Filters) (int64, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	var count int64
+	for _, u := range us.users {
+		if matchesFilters(u, filters) {
+			count++
+		}
+	}
+
+	return count, nil
+}
@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/toncek345/userservice/storage"
+	"github.com/toncek345/userservice/storage/storagetest"
+)
+
+func TestUserStorageMemory(t *testing.T) {
+	storagetest.RunUserStorageTests(t, func(t *testing.T) storage.UserStorage {
+		return &storage.UserStorageMemory{}
+	})
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// sortTimeFormat is used for timestamps in sort values. Columns are timestamps without time zone
+// so the zone is omitted.
+const sortTimeFormat = "2006-01-02 15:04:05.999999"
+
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// UsersOrder is the default order in which users are searched. ID is the last column which
+// makes the order total, which is required for keyset pagination.
+var UsersOrder = []SortField{{Column: "created_at"}, {Column: "id"}}
+
+// SortableColumns are columns which users can be ordered by.
+var SortableColumns = []string{"first_name", "last_name", "email", "country", "created_at", "updated_at"}
+
+// ErrInvalidOrderBy is returned when order by expression can't be parsed.
+var ErrInvalidOrderBy = errors.New("invalid order by")
+
+// ParseOrderBy parses expressions like "created_at desc, last_name". ID is appended as the last
+// column so that the order is total. Empty expression returns UsersOrder.
+func ParseOrderBy(orderBy string) ([]SortField, error) {
+	if strings.TrimSpace(orderBy) == "" {
+		return UsersOrder, nil
+	}
+
+	order := []SortField{}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidOrderBy, strings.TrimSpace(part))
+		}
+
+		f := SortField{Column: strings.ToLower(words[0])}
+		if !isSortable(f.Column) {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidOrderBy, words[0])
+		}
+		if seen[f.Column] {
+			return nil, fmt.Errorf("%w: duplicate field %q", ErrInvalidOrderBy, words[0])
+		}
+		seen[f.Column] = true
+
+		if len(words) == 2 {
+			switch strings.ToLower(words[1]) {
+			case "asc":
+			case "desc":
+				f.Desc = true
+			default:
+				return nil, fmt.Errorf("%w: unknown direction %q", ErrInvalidOrderBy, words[1])
+			}
+		}
+
+		order = append(order, f)
+	}
+
+	return append(order, SortField{Column: "id"}), nil
+}
+
+func isSortable(column string) bool {
+	for _, c := range SortableColumns {
+		if c == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Page selects a subset of the search results.
+type Page struct {
+	// Order of the results, UsersOrder if empty.
+	Order  []SortField
+	Offset int64
+	Limit  int64
+	// After contains sort values of the last row of the previous page, as returned by
+	// SortValues. When it is set rows following it are returned and Offset is ignored.
+	After []string
+}
+
+// SortValues returns values of the order columns of u which are used as a keyset pagination
+// cursor.
+func SortValues(u *UserModel, order []SortField) []string {
+	values := make([]string, 0, len(order))
+	for _, f := range order {
+		values = append(values, sortValue(u, f.Column))
+	}
+
+	return values
+}
+
+func sortValue(u *UserModel, column string) string {
+	switch column {
+	case "id":
+		return u.ID
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "email":
+		return u.Email
+	case "country":
+		return u.Country
+	case "created_at":
+		return u.CreatedAt.Format(sortTimeFormat)
+	case "updated_at":
+		return u.UpdatedAt.Format(sortTimeFormat)
+	}
+
+	return ""
+}
+
+// sortExpr returns expression by which column is ordered in d.
+func sortExpr(column string, d *dialect) string {
+	switch column {
+	case "first_name", "last_name", "email", "country":
+		return column + d.byteOrder
+	}
+
+	return column
+}
+
+func orderByClauses(order []SortField, d *dialect) []string {
+	clauses := make([]string, 0, len(order))
+	for _, f := range order {
+		if f.Desc {
+			clauses = append(clauses, sortExpr(f.Column, d)+" DESC")
+		} else {
+			clauses = append(clauses, sortExpr(f.Column, d)+" ASC")
+		}
+	}
+
+	return clauses
+}
+
+// keysetCondition returns condition which selects rows following after in order. For order
+// (a, b DESC) it builds: a > $1 OR (a = $1 AND b < $2).
+func keysetCondition(order []SortField, after []string, d *dialect) sq.Sqlizer {
+	or := sq.Or{}
+	for i, f := range order {
+		and := sq.And{}
+		for j := 0; j < i; j++ {
+			and = append(and, sq.Eq{sortExpr(order[j].Column, d): after[j]})
+		}
+
+		column := sortExpr(f.Column, d)
+		if f.Desc {
+			and = append(and, sq.Lt{column: after[i]})
+		} else {
+			and = append(and, sq.Gt{column: after[i]})
+		}
+		or = append(or, and)
+	}
+
+	return or
+}
+
+// ValidSortValues reports whether values can be used as Page.After for order.
+func ValidSortValues(order []SortField, values []string) bool {
+	if len(values) != len(order) {
+		return false
+	}
+
+	for i, f := range order {
+		if f.Column == "created_at" || f.Column == "updated_at" {
+			if _, err := time.Parse(sortTimeFormat, values[i]); err != nil {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,57 @@
+package storage_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/toncek345/userservice/storage"
+)
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy string
+		order   []storage.SortField
+		isError bool
+	}{
+		{
+			name:  "default",
+			order: storage.UsersOrder,
+		},
+		{
+			name:    "multiple fields",
+			orderBy: "created_at desc, LAST_NAME ASC,email",
+			order: []storage.SortField{
+				{Column: "created_at", Desc: true},
+				{Column: "last_name"},
+				{Column: "email"},
+				{Column: "id"},
+			},
+		},
+		{name: "unknown field", orderBy: "password", isError: true},
+		{name: "id isn't sortable", orderBy: "id", isError: true},
+		{name: "unknown direction", orderBy: "email up", isError: true},
+		{name: "duplicate field", orderBy: "email, email desc", isError: true},
+		{name: "empty part", orderBy: "email,", isError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			order, err := storage.ParseOrderBy(test.orderBy)
+			if err != nil {
+				if test.isError && errors.Is(err, storage.ErrInvalidOrderBy) {
+					return
+				}
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.isError {
+				t.Fatal("expected error")
+			}
+
+			if !reflect.DeepEqual(order, test.order) {
+				t.Fatalf("expected %v, got %v", test.order, order)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/slog"
+)
+
+var _ RefreshTokenStorage = (*RefreshTokenStorageSQL)(nil)
+
+// RefreshTokenStorage stores refresh tokens by their hashes. Used and revoked tokens are kept
+// until they expire, so that their reuse can be detected.
+type RefreshTokenStorage interface {
+	// InsertRefreshToken stores the first token of a new family. It returns ErrAlreadyExists if
+	// the token or the family already exists.
+	InsertRefreshToken(ctx context.Context, token *InsertRefreshToken) (*RefreshTokenModel, error)
+	// RotateRefreshToken marks the token as used and stores next token of its family in one
+	// transaction, and returns the next token. It returns ErrNotFound if the token doesn't exist
+	// in next.FamilyID, was already used or is revoked, so that a token can be used only once
+	// even by concurrent requests. Rotation and RevokeRefreshTokenFamily lock the family, so
+	// next token is revoked if the family is revoked concurrently.
+	RotateRefreshToken(ctx context.Context, tokenHash string, next *InsertRefreshToken) (*RefreshTokenModel, error)
+	// GetRefreshToken returns the token regardless of whether it was used or revoked.
+	GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshTokenModel, error)
+	// RevokeRefreshTokenFamily revokes the family and all its not yet revoked tokens and
+	// returns their number.
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int64, error)
+	// DeleteExpiredRefreshTokens permanently removes tokens which expired before the given time
+	// and returns their number. Families without tokens are removed as well.
+	DeleteExpiredRefreshTokens(ctx context.Context, expiredBefore time.Time) (int64, error)
+}
+
+type RefreshTokenModel struct {
+	TokenHash string `db:"token_hash"`
+	// FamilyID is shared by tokens rotated from the same login.
+	FamilyID  string    `db:"family_id"`
+	UserID    string    `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+	// UsedAt is nil if the token wasn't exchanged for new tokens yet.
+	UsedAt *time.Time `db:"used_at"`
+	// RevokedAt is nil if the token isn't revoked.
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+type InsertRefreshToken struct {
+	TokenHash string
+	FamilyID  string
+	UserID    string
+	ExpiresAt time.Time
+}
+
+type RefreshTokenStorageSQL struct {
+	DB *sqlx.DB
+	// Logger logs failed rollbacks, slog.Default is used if it is nil.
+	Logger *slog.Logger
+}
+
+func (ts *RefreshTokenStorageSQL) traced(q sqlx.ExtContext) *tracedDB {
+	return postgresDialect.traced(q)
+}
+
+func (ts *RefreshTokenStorageSQL) insert(ctx context.Context, q *tracedDB, token *InsertRefreshToken) (*RefreshTokenModel, error) {
+	t := &RefreshTokenModel{}
+
+	if err := q.GetContext(
+		ctx,
+		t,
+		`INSERT INTO refresh_tokens (token_hash, family_id, user_id, created_at, expires_at) VALUES
+		($1, $2, $3, NOW(), $4) RETURNING
+		token_hash, family_id, user_id, created_at, expires_at, used_at, revoked_at`,
+		token.TokenHash, token.FamilyID, token.UserID, token.ExpiresAt.UTC()); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("inserting refresh token: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQL) InsertRefreshToken(ctx context.Context, token *InsertRefreshToken) (*RefreshTokenModel, error) {
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	if _, err := ts.traced(tx).ExecContext(
+		ctx,
+		"INSERT INTO refresh_token_families (id, user_id, created_at) VALUES ($1, $2, NOW())",
+		token.FamilyID, token.UserID); err != nil {
+		rollback(ctx, ts.Logger, tx)
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("inserting refresh token family: %w", err)
+	}
+
+	t, err := ts.insert(ctx, ts.traced(tx), token)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQL) RotateRefreshToken(ctx context.Context, tokenHash string, next *InsertRefreshToken) (*RefreshTokenModel, error) {
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	// Revocation waits for the lock, so it sees and revokes next token. Rotation waiting for
+	// revocation reads the revoked family since locking reads the latest row version.
+	var revoked bool
+	if err := ts.traced(tx).GetContext(
+		ctx,
+		&revoked,
+		"SELECT revoked_at IS NOT NULL FROM refresh_token_families WHERE id = $1 FOR UPDATE",
+		next.FamilyID); err != nil {
+		rollback(ctx, ts.Logger, tx)
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("locking refresh token family: %w", err)
+	}
+	if revoked {
+		rollback(ctx, ts.Logger, tx)
+		return nil, ErrNotFound
+	}
+
+	res, err := ts.traced(tx).ExecContext(
+		ctx,
+		`UPDATE refresh_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND family_id = $2 AND used_at IS NULL AND revoked_at IS NULL`,
+		tokenHash, next.FamilyID)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, fmt.Errorf("using refresh token: %w", err)
+	}
+	used, err := res.RowsAffected()
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, fmt.Errorf("rows affected: %w", err)
+	}
+	if used == 0 {
+		rollback(ctx, ts.Logger, tx)
+		return nil, ErrNotFound
+	}
+
+	t, err := ts.insert(ctx, ts.traced(tx), next)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQL) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshTokenModel, error) {
+	t := &RefreshTokenModel{}
+
+	if err := ts.traced(ts.DB).GetContext(
+		ctx,
+		t,
+		`SELECT token_hash, family_id, user_id, created_at, expires_at, used_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = $1`,
+		tokenHash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting refresh token: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQL) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int64, error) {
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	// Updating the family waits for rotations holding its lock, so tokens they insert are
+	// revoked below.
+	if _, err := ts.traced(tx).ExecContext(
+		ctx,
+		"UPDATE refresh_token_families SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1",
+		familyID); err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return 0, fmt.Errorf("revoking refresh token family: %w", err)
+	}
+
+	res, err := ts.traced(tx).ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL",
+		familyID)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return 0, fmt.Errorf("revoking refresh tokens: %w", err)
+	}
+
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (ts *RefreshTokenStorageSQL) DeleteExpiredRefreshTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	res, err := ts.traced(ts.DB).ExecContext(
+		ctx,
+		"DELETE FROM refresh_tokens WHERE expires_at < $1",
+		expiredBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired refresh tokens: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	if _, err := ts.traced(ts.DB).ExecContext(
+		ctx,
+		`DELETE FROM refresh_token_families f
+		WHERE NOT EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = f.id)`); err != nil {
+		return 0, fmt.Errorf("deleting empty refresh token families: %w", err)
+	}
+
+	return deleted, nil
+}
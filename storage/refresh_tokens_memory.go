@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ RefreshTokenStorage = (*RefreshTokenStorageMemory)(nil)
+
+// RefreshTokenStorageMemory is a thread-safe in-memory RefreshTokenStorage with the same
+// semantics as RefreshTokenStorageSQL. Zero value is ready to use.
+type RefreshTokenStorageMemory struct {
+	// Now returns current time, time.Now is used if it is nil.
+	Now func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]*RefreshTokenModel
+}
+
+func (ts *RefreshTokenStorageMemory) now() time.Time {
+	now := time.Now
+	if ts.Now != nil {
+		now = ts.Now
+	}
+
+	return now().UTC().Truncate(time.Microsecond)
+}
+
+func copyRefreshToken(t *RefreshTokenModel) *RefreshTokenModel {
+	c := *t
+	if t.UsedAt != nil {
+		usedAt := *t.UsedAt
+		c.UsedAt = &usedAt
+	}
+	if t.RevokedAt != nil {
+		revokedAt := *t.RevokedAt
+		c.RevokedAt = &revokedAt
+	}
+
+	return &c
+}
+
+// insert stores token, ts.mu must be held.
+func (ts *RefreshTokenStorageMemory) insert(token *InsertRefreshToken) (*RefreshTokenModel, error) {
+	if ts.tokens == nil {
+		ts.tokens = map[string]*RefreshTokenModel{}
+	}
+	if _, ok := ts.tokens[token.TokenHash]; ok {
+		return nil, ErrAlreadyExists
+	}
+
+	t := &RefreshTokenModel{
+		TokenHash: token.TokenHash,
+		FamilyID:  token.FamilyID,
+		UserID:    token.UserID,
+		CreatedAt: ts.now(),
+		ExpiresAt: token.ExpiresAt.UTC().Truncate(time.Microsecond),
+	}
+	ts.tokens[t.TokenHash] = t
+
+	return copyRefreshToken(t), nil
+}
+
+func (ts *RefreshTokenStorageMemory) InsertRefreshToken(ctx context.Context, token *InsertRefreshToken) (*RefreshTokenModel, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// Families exist as long as they have tokens, like in the SQL storages.
+	for _, t := range ts.tokens {
+		if t.FamilyID == token.FamilyID {
+			return nil, ErrAlreadyExists
+		}
+	}
+
+	return ts.insert(token)
+}
+
+func (ts *RefreshTokenStorageMemory) RotateRefreshToken(ctx context.Context, tokenHash string, next *InsertRefreshToken) (*RefreshTokenModel, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, ok := ts.tokens[tokenHash]
+	if !ok || t.FamilyID != next.FamilyID || t.UsedAt != nil || t.RevokedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	inserted, err := ts.insert(next)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ts.now()
+	t.UsedAt = &now
+
+	return inserted, nil
+}
+
+func (ts *RefreshTokenStorageMemory) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshTokenModel, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, ok := ts.tokens[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyRefreshToken(t), nil
+}
+
+func (ts *RefreshTokenStorageMemory) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int64, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var revoked int64
+	now := ts.now()
+	for _, t := range ts.tokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			revokedAt := now
+			t.RevokedAt = &revokedAt
+			revoked++
+		}
+	}
+
+	return revoked, nil
+}
+
+func (ts *RefreshTokenStorageMemory) DeleteExpiredRefreshTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var deleted int64
+	for hash, t := range ts.tokens {
+		if t.ExpiresAt.Before(expiredBefore) {
+			delete(ts.tokens, hash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
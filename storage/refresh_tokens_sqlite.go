@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/slog"
+)
+
+var _ RefreshTokenStorage = (*RefreshTokenStorageSQLite)(nil)
+
+// RefreshTokenStorageSQLite stores refresh tokens in the SQLite database of
+// UserStorageSQLite. Times are set by the storage and stored as UTC text.
+type RefreshTokenStorageSQLite struct {
+	DB *sqlx.DB
+	// Now returns current time, time.Now is used if it is nil.
+	Now func() time.Time
+	// Logger logs failed rollbacks, slog.Default is used if it is nil.
+	Logger *slog.Logger
+}
+
+func (ts *RefreshTokenStorageSQLite) traced(q sqlx.ExtContext) *tracedDB {
+	return sqliteDialect.traced(q)
+}
+
+func (ts *RefreshTokenStorageSQLite) now() string {
+	if ts.Now != nil {
+		return sqliteTime(ts.Now())
+	}
+
+	return sqliteTime(time.Now())
+}
+
+func (ts *RefreshTokenStorageSQLite) insert(ctx context.Context, q *tracedDB, token *InsertRefreshToken) (*RefreshTokenModel, error) {
+	t := &RefreshTokenModel{}
+
+	if err := q.GetContext(
+		ctx,
+		t,
+		`INSERT INTO refresh_tokens (token_hash, family_id, user_id, created_at, expires_at) VALUES
+		(?, ?, ?, ?, ?) RETURNING
+		token_hash, family_id, user_id, created_at, expires_at, used_at, revoked_at`,
+		token.TokenHash, token.FamilyID, token.UserID, ts.now(), sqliteTime(token.ExpiresAt)); err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("inserting refresh token: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQLite) InsertRefreshToken(ctx context.Context, token *InsertRefreshToken) (*RefreshTokenModel, error) {
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	if _, err := ts.traced(tx).ExecContext(
+		ctx,
+		"INSERT INTO refresh_token_families (id, user_id, created_at) VALUES (?, ?, ?)",
+		token.FamilyID, token.UserID, ts.now()); err != nil {
+		rollback(ctx, ts.Logger, tx)
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("inserting refresh token family: %w", err)
+	}
+
+	t, err := ts.insert(ctx, ts.traced(tx), token)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQLite) RotateRefreshToken(ctx context.Context, tokenHash string, next *InsertRefreshToken) (*RefreshTokenModel, error) {
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	// SQLite has no SELECT FOR UPDATE, writing the family first takes the database write lock
+	// before anything is read, like revocation does.
+	var revoked bool
+	if err := ts.traced(tx).GetContext(
+		ctx,
+		&revoked,
+		"UPDATE refresh_token_families SET revoked_at = revoked_at WHERE id = ? RETURNING revoked_at IS NOT NULL",
+		next.FamilyID); err != nil {
+		rollback(ctx, ts.Logger, tx)
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("locking refresh token family: %w", err)
+	}
+	if revoked {
+		rollback(ctx, ts.Logger, tx)
+		return nil, ErrNotFound
+	}
+
+	res, err := ts.traced(tx).ExecContext(
+		ctx,
+		`UPDATE refresh_tokens SET used_at = ?
+		WHERE token_hash = ? AND family_id = ? AND used_at IS NULL AND revoked_at IS NULL`,
+		ts.now(), tokenHash, next.FamilyID)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, fmt.Errorf("using refresh token: %w", err)
+	}
+	used, err := res.RowsAffected()
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, fmt.Errorf("rows affected: %w", err)
+	}
+	if used == 0 {
+		rollback(ctx, ts.Logger, tx)
+		return nil, ErrNotFound
+	}
+
+	t, err := ts.insert(ctx, ts.traced(tx), next)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQLite) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshTokenModel, error) {
+	t := &RefreshTokenModel{}
+
+	if err := ts.traced(ts.DB).GetContext(
+		ctx,
+		t,
+		`SELECT token_hash, family_id, user_id, created_at, expires_at, used_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = ?`,
+		tokenHash); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting refresh token: %w", err)
+	}
+
+	return t, nil
+}
+
+func (ts *RefreshTokenStorageSQLite) RevokeRefreshTokenFamily(ctx context.Context, familyID string) (int64, error) {
+	tx, err := ts.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	now := ts.now()
+	if _, err := ts.traced(tx).ExecContext(
+		ctx,
+		"UPDATE refresh_token_families SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?",
+		now, familyID); err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return 0, fmt.Errorf("revoking refresh token family: %w", err)
+	}
+
+	res, err := ts.traced(tx).ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL",
+		now, familyID)
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return 0, fmt.Errorf("revoking refresh tokens: %w", err)
+	}
+
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		rollback(ctx, ts.Logger, tx)
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (ts *RefreshTokenStorageSQLite) DeleteExpiredRefreshTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	res, err := ts.traced(ts.DB).ExecContext(
+		ctx,
+		"DELETE FROM refresh_tokens WHERE expires_at < ?",
+		sqliteTime(expiredBefore))
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired refresh tokens: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	if _, err := ts.traced(ts.DB).ExecContext(
+		ctx,
+		`DELETE FROM refresh_token_families
+		WHERE NOT EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = refresh_token_families.id)`); err != nil {
+		return 0, fmt.Errorf("deleting empty refresh token families: %w", err)
+	}
+
+	return deleted, nil
+}
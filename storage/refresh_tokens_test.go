@@ -0,0 +1,32 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/toncek345/userservice/storage"
+	"github.com/toncek345/userservice/storage/storagetest"
+)
+
+func TestRefreshTokenStorageMemory(t *testing.T) {
+	storagetest.RunRefreshTokenStorageTests(t, func(t *testing.T) (storage.RefreshTokenStorage, storage.UserStorage) {
+		return &storage.RefreshTokenStorageMemory{}, &storage.UserStorageMemory{}
+	})
+}
+
+func TestRefreshTokenStorageSQLite(t *testing.T) {
+	storagetest.RunRefreshTokenStorageTests(t, func(t *testing.T) (storage.RefreshTokenStorage, storage.UserStorage) {
+		db := openSQLite(t)
+		return &storage.RefreshTokenStorageSQLite{DB: db}, &storage.UserStorageSQLite{DB: db}
+	})
+}
+
+func TestRefreshTokenStorageSQL(t *testing.T) {
+	db := openPostgres(t)
+	storagetest.RunRefreshTokenStorageTests(t, func(t *testing.T) (storage.RefreshTokenStorage, storage.UserStorage) {
+		if _, err := db.Exec("TRUNCATE users CASCADE"); err != nil {
+			t.Fatalf("truncating users: %s", err)
+		}
+
+		return &storage.RefreshTokenStorageSQL{DB: db}, &storage.UserStorageSQL{DB: db}
+	})
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"golang.org/x/exp/slog"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var _ UserStorage = (*UserStorageSQLite)(nil)
+
+// UserStorageSQLite stores users in SQLite database opened with the "sqlite" driver. Its
+// schema is managed by migrations.EmbeddedSQLite.
+//
+// Unlike Postgres, SQLite generates neither IDs nor timestamps, so they are set by the storage.
+// Times are stored as UTC text which sorts chronologically. Case-insensitive matching of
+// filters works only for ASCII letters.
+type UserStorageSQLite struct {
+	DB *sqlx.DB
+	// Logger logs failed rollbacks, slog.Default is used if it is nil.
+	Logger *slog.Logger
+	// Now returns current time, time.Now is used if it is nil.
+	Now func() time.Time
+}
+
+var sqliteDialect = &dialect{
+	placeholder: sq.Question,
+	ilike: func(column, pattern string) sq.Sqlizer {
+		// LIKE of SQLite is case-insensitive, but has no escape character by default.
+		return sq.Expr(column+` LIKE ? ESCAPE '\'`, pattern)
+	},
+	timeArg: func(t time.Time) interface{} {
+		return sqliteTime(t)
+	},
+	system: semconv.DBSystemSqlite,
+	// Columns use the default BINARY collation, which is byte-wise.
+	byteOrder: "",
+}
+
+func (us *UserStorageSQLite) traced(q sqlx.ExtContext) *tracedDB {
+	return sqliteDialect.traced(q)
+}
+
+// sqliteTime formats t as it is stored in SQLite. It is the format of sort values, so stored
+// times can be compared with page cursors as text.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sortTimeFormat)
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func (us *UserStorageSQLite) now() string {
+	if us.Now != nil {
+		return sqliteTime(us.Now())
+	}
+
+	return sqliteTime(time.Now())
+}
+
+func (us *UserStorageSQLite) InsertUser(ctx context.Context, user *InsertUser) (*UserModel, error) {
+	u := &UserModel{}
+	now := us.now()
+
+	if err := us.traced(us.DB).GetContext(
+		ctx,
+		u,
+		`INSERT INTO users (id, first_name, last_name, email, country, password, created_at, updated_at) VALUES
+		(?, ?, ?, ?, ?, ?, ?, ?) RETURNING
+		id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version`,
+		uuid.NewString(), user.FirstName, user.LastName, NormalizeEmail(user.Email), user.Country, user.Password,
+		now, now); err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("inserting user: %w", err)
+	}
+
+	return u, nil
+}
+
+func (us *UserStorageSQLite) UpdateUser(ctx context.Context, user *UpdateUser) (*UserModel, error) {
+	u := &UserModel{}
+
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	if err := us.traced(tx).GetContext(
+		ctx,
+		u,
+		`UPDATE users SET first_name = ?, last_name = ?, email = ?, country = ?, password = ?, updated_at = ?,
+		version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?) RETURNING
+		id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version`,
+		user.FirstName, user.LastName, NormalizeEmail(user.Email), user.Country, user.Password, us.now(),
+		user.ID, user.ExpectedVersion, user.ExpectedVersion); err != nil {
+		if err == sql.ErrNoRows {
+			err = versionConflict(ctx, us.traced(tx), user.ID, user.ExpectedVersion)
+			rollback(ctx, us.Logger, tx)
+			return nil, err
+		}
+		rollback(ctx, us.Logger, tx)
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("updating user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return u, nil
+}
+
+func (us *UserStorageSQLite) PatchUser(ctx context.Context, user *PatchUser) (*UserModel, error) {
+	query := sq.Update("users").
+		Set("updated_at", us.now()).
+		Set("version", sq.Expr("version + 1")).
+		Where(sq.Eq{"id": user.ID, "deleted_at": nil}).
+		Suffix("RETURNING id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version")
+
+	if user.FirstName != nil {
+		query = query.Set("first_name", *user.FirstName)
+	}
+	if user.LastName != nil {
+		query = query.Set("last_name", *user.LastName)
+	}
+	if user.Email != nil {
+		query = query.Set("email", NormalizeEmail(*user.Email))
+	}
+	if user.Country != nil {
+		query = query.Set("country", *user.Country)
+	}
+	if user.Password != nil {
+		query = query.Set("password", *user.Password)
+	}
+	if user.ExpectedVersion != 0 {
+		query = query.Where(sq.Eq{"version": user.ExpectedVersion})
+	}
+
+	stmt, args, err := query.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("building sql: %w", err)
+	}
+
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+
+	u := &UserModel{}
+	if err := us.traced(tx).GetContext(ctx, u, stmt, args...); err != nil {
+		if err == sql.ErrNoRows {
+			err = versionConflict(ctx, us.traced(tx), user.ID, user.ExpectedVersion)
+			rollback(ctx, us.Logger, tx)
+			return nil, err
+		}
+		rollback(ctx, us.Logger, tx)
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("patching user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return u, nil
+}
+
+// DeleteUser checks expectedVersion against the stored version if it isn't 0.
+func (us *UserStorageSQLite) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	tx, err := us.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+
+	res, err := us.traced(tx).ExecContext(
+		ctx,
+		`UPDATE users SET deleted_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`,
+		us.now(), id, expectedVersion, expectedVersion)
+	if err != nil {
+		rollback(ctx, us.Logger, tx)
+		return fmt.Errorf("sql deleting: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		rollback(ctx, us.Logger, tx)
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		err := versionConflict(ctx, us.traced(tx), id, expectedVersion)
+		rollback(ctx, us.Logger, tx)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("transaction commit: %w", err)
+	}
+
+	return nil
+}
+
+func (us *UserStorageSQLite) UndeleteUser(ctx context.Context, id string) (*UserModel, error) {
+	u := &UserModel{}
+
+	if err := us.traced(us.DB).GetContext(
+		ctx,
+		u,
+		`UPDATE users SET deleted_at = NULL, updated_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NOT NULL RETURNING
+		id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version`,
+		us.now(), id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if isSQLiteUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		return nil, fmt.Errorf("undeleting user: %w", err)
+	}
+
+	return u, nil
+}
+
+func (us *UserStorageSQLite) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	res, err := us.traced(us.DB).ExecContext(
+		ctx,
+		"DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?",
+		sqliteTime(deletedBefore))
+	if err != nil {
+		return 0, fmt.Errorf("purging users: %w", err)
+	}
+
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return purged, nil
+}
+
+func (us *UserStorageSQLite) GetUser(ctx context.Context, id string) (*UserModel, error) {
+	u := &UserModel{}
+
+	if err := us.traced(us.DB).GetContext(
+		ctx,
+		u,
+		`SELECT id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version
+		FROM users WHERE id = ? AND deleted_at IS NULL`,
+		id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+
+	return u, nil
+}
+
+func (us *UserStorageSQLite) GetUserByEmail(ctx context.Context, email string) (*UserModel, error) {
+	u := &UserModel{}
+
+	if err := us.traced(us.DB).GetContext(
+		ctx,
+		u,
+		`SELECT id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version
+		FROM users WHERE lower(email) = ? AND deleted_at IS NULL`,
+		NormalizeEmail(email)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting user by email: %w", err)
+	}
+
+	return u, nil
+}
+
+// SearchUser returns users matching filters ordered by page order.
+func (us *UserStorageSQLite) SearchUser(ctx context.Context, filters *Filters, page *Page) ([]*UserModel, error) {
+	return searchUsers(ctx, us.traced(us.DB), sqliteDialect, filters, page)
+}
+
+// CountUsers returns number of users matching filters.
+func (us *UserStorageSQLite) CountUsers(ctx context.Context, filters *Filters) (int64, error) {
+	return countUsers(ctx, us.traced(us.DB), sqliteDialect, filters)
+}
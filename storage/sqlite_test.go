@@ -0,0 +1,111 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/toncek345/userservice/migrations"
+	"github.com/toncek345/userservice/storage"
+	"github.com/toncek345/userservice/storage/storagetest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite returns a migrated in-memory database which is closed when the test ends.
+func openSQLite(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	ms, err := migrations.EmbeddedSQLite()
+	if err != nil {
+		t.Fatalf("loading migrations: %s", err)
+	}
+
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Every connection has its own in-memory database.
+	db.SetMaxOpenConns(1)
+
+	if err := (&migrations.Migrator{DB: db, Migrations: ms}).Up(context.Background()); err != nil {
+		t.Fatalf("migrating db: %s", err)
+	}
+
+	return db
+}
+
+func TestUserStorageSQLite(t *testing.T) {
+	storagetest.RunUserStorageTests(t, func(t *testing.T) storage.UserStorage {
+		return &storage.UserStorageSQLite{DB: openSQLite(t)}
+	})
+}
+
+func TestUserStorageSQLiteTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ms, err := migrations.EmbeddedSQLite()
+	if err != nil {
+		t.Fatalf("loading migrations: %s", err)
+	}
+	db, err := sqlx.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := (&migrations.Migrator{DB: db, Migrations: ms}).Up(context.Background()); err != nil {
+		t.Fatalf("migrating db: %s", err)
+	}
+
+	s := &storage.UserStorageSQLite{DB: db}
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	u, err := s.InsertUser(ctx, &storage.InsertUser{Email: "a@example.com"})
+	if err != nil {
+		t.Fatalf("insert user: %s", err)
+	}
+	if _, err := s.GetUser(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := s.DeleteUser(ctx, u.ID, 0); err != nil {
+		t.Fatalf("delete user: %s", err)
+	}
+	parent.End()
+
+	operations := []string{}
+	for _, span := range recorder.Ended() {
+		if span.Name() == "request" {
+			continue
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("span %s isn't a child of the request", span.Name())
+		}
+		if span.Status().Code == codes.Error {
+			t.Fatalf("span %s failed: %s", span.Name(), span.Status().Description)
+		}
+		attrs := attribute.NewSet(span.Attributes()...)
+		if v, _ := attrs.Value(semconv.DBSystemKey); v.AsString() != "sqlite" {
+			t.Fatalf("span %s has db.system %q", span.Name(), v.AsString())
+		}
+		if v, _ := attrs.Value(semconv.DBStatementKey); v.AsString() == "" {
+			t.Fatalf("span %s has no statement", span.Name())
+		}
+		operations = append(operations, span.Name())
+	}
+
+	expected := []string{"INSERT", "SELECT", "UPDATE"}
+	if strings.Join(operations, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected spans %v, got %v", expected, operations)
+	}
+}
@@ -0,0 +1,272 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/storage"
+
+	"github.com/google/uuid"
+)
+
+// NewRefreshTokenStorage returns an empty token storage for a single test and a storage of
+// users which the tokens belong to.
+type NewRefreshTokenStorage func(t *testing.T) (storage.RefreshTokenStorage, storage.UserStorage)
+
+// RunRefreshTokenStorageTests runs the conformance suite against storages created by
+// newStorage.
+func RunRefreshTokenStorageTests(t *testing.T, newStorage NewRefreshTokenStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.RefreshTokenStorage, userID string)
+	}{
+		{"InsertAndGet", testInsertAndGetRefreshToken},
+		{"Rotate", testRotateRefreshToken},
+		{"RevokeFamily", testRevokeRefreshTokenFamily},
+		{"RevokeDuringRotation", testRevokeDuringRotation},
+		{"DeleteExpired", testDeleteExpiredRefreshTokens},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, users := newStorage(t)
+			user := insert(t, users, "John", "Doe", "john@example.com", "US")
+			test.test(t, s, user.ID)
+		})
+	}
+}
+
+func insertToken(t *testing.T, s storage.RefreshTokenStorage, hash, familyID, userID string, expiresAt time.Time) *storage.RefreshTokenModel {
+	t.Helper()
+
+	token, err := s.InsertRefreshToken(context.Background(), &storage.InsertRefreshToken{
+		TokenHash: hash,
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("inserting refresh token: %s", err)
+	}
+
+	return token
+}
+
+func testInsertAndGetRefreshToken(t *testing.T, s storage.RefreshTokenStorage, userID string) {
+	ctx := context.Background()
+	familyID := uuid.NewString()
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	inserted := insertToken(t, s, "hash", familyID, userID, expiresAt)
+	if inserted.FamilyID != familyID || inserted.UserID != userID || inserted.CreatedAt.IsZero() {
+		t.Fatalf("unexpected token: %+v", inserted)
+	}
+	if !inserted.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected expiration %s, got %s", expiresAt, inserted.ExpiresAt)
+	}
+	if inserted.UsedAt != nil || inserted.RevokedAt != nil {
+		t.Fatalf("new token is used or revoked: %+v", inserted)
+	}
+
+	got, err := s.GetRefreshToken(ctx, "hash")
+	if err != nil {
+		t.Fatalf("getting refresh token: %s", err)
+	}
+	if got.TokenHash != "hash" || got.FamilyID != familyID || !got.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("unexpected token: %+v", got)
+	}
+
+	_, err = s.InsertRefreshToken(ctx, &storage.InsertRefreshToken{
+		TokenHash: "hash",
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	expectErr(t, err, storage.ErrAlreadyExists)
+
+	// Only the first token of a family is inserted, others are rotated.
+	_, err = s.InsertRefreshToken(ctx, &storage.InsertRefreshToken{
+		TokenHash: "other",
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	expectErr(t, err, storage.ErrAlreadyExists)
+
+	_, err = s.GetRefreshToken(ctx, "missing")
+	expectErr(t, err, storage.ErrNotFound)
+}
+
+func rotateToken(t *testing.T, s storage.RefreshTokenStorage, hash, nextHash, familyID, userID string) *storage.RefreshTokenModel {
+	t.Helper()
+
+	next, err := s.RotateRefreshToken(context.Background(), hash, &storage.InsertRefreshToken{
+		TokenHash: nextHash,
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("rotating refresh token: %s", err)
+	}
+
+	return next
+}
+
+func testRotateRefreshToken(t *testing.T, s storage.RefreshTokenStorage, userID string) {
+	ctx := context.Background()
+	familyID := uuid.NewString()
+	insertToken(t, s, "hash", familyID, userID, time.Now().Add(time.Hour))
+
+	next := rotateToken(t, s, "hash", "next", familyID, userID)
+	if next.TokenHash != "next" || next.FamilyID != familyID || next.UsedAt != nil {
+		t.Fatalf("unexpected next token: %+v", next)
+	}
+
+	got, err := s.GetRefreshToken(ctx, "hash")
+	if err != nil {
+		t.Fatalf("getting used refresh token: %s", err)
+	}
+	if got.UsedAt == nil {
+		t.Fatal("used token isn't marked as used")
+	}
+
+	tests := []struct {
+		name     string
+		hash     string
+		familyID string
+	}{
+		{"used", "hash", familyID},
+		{"missing", "missing", familyID},
+		{"other family", "next", uuid.NewString()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.RotateRefreshToken(ctx, test.hash, &storage.InsertRefreshToken{
+				TokenHash: "rotated",
+				FamilyID:  test.familyID,
+				UserID:    userID,
+				ExpiresAt: time.Now().Add(time.Hour),
+			})
+			expectErr(t, err, storage.ErrNotFound)
+			_, err = s.GetRefreshToken(ctx, "rotated")
+			expectErr(t, err, storage.ErrNotFound)
+		})
+	}
+
+	// Failed insert of the next token doesn't use the token.
+	_, err = s.RotateRefreshToken(ctx, "next", &storage.InsertRefreshToken{
+		TokenHash: "hash",
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	expectErr(t, err, storage.ErrAlreadyExists)
+	rotateToken(t, s, "next", "last", familyID, userID)
+}
+
+func testRevokeRefreshTokenFamily(t *testing.T, s storage.RefreshTokenStorage, userID string) {
+	ctx := context.Background()
+	familyID := uuid.NewString()
+	insertToken(t, s, "used", familyID, userID, time.Now().Add(time.Hour))
+	rotateToken(t, s, "used", "current", familyID, userID)
+	other := insertToken(t, s, "other", uuid.NewString(), userID, time.Now().Add(time.Hour))
+
+	revoked, err := s.RevokeRefreshTokenFamily(ctx, familyID)
+	if err != nil || revoked != 2 {
+		t.Fatalf("expected 2 revoked, got %d: %v", revoked, err)
+	}
+	revoked, err = s.RevokeRefreshTokenFamily(ctx, familyID)
+	if err != nil || revoked != 0 {
+		t.Fatalf("expected nothing revoked again, got %d: %v", revoked, err)
+	}
+
+	got, err := s.GetRefreshToken(ctx, "current")
+	if err != nil {
+		t.Fatalf("getting revoked refresh token: %s", err)
+	}
+	if got.RevokedAt == nil || got.UsedAt != nil {
+		t.Fatalf("expected revoked and unused token, got %+v", got)
+	}
+
+	// Revoked token can't be rotated.
+	_, err = s.RotateRefreshToken(ctx, "current", &storage.InsertRefreshToken{
+		TokenHash: "rotated",
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	expectErr(t, err, storage.ErrNotFound)
+	_, err = s.GetRefreshToken(ctx, "rotated")
+	expectErr(t, err, storage.ErrNotFound)
+
+	// Tokens of other families aren't revoked.
+	rotateToken(t, s, "other", "other next", other.FamilyID, userID)
+}
+
+// testRevokeDuringRotation checks that a token rotated concurrently with revocation of its
+// family is either revoked or not stored.
+func testRevokeDuringRotation(t *testing.T, s storage.RefreshTokenStorage, userID string) {
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		familyID := uuid.NewString()
+		hash, nextHash := fmt.Sprintf("token %d", i), fmt.Sprintf("next %d", i)
+		insertToken(t, s, hash, familyID, userID, time.Now().Add(time.Hour))
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := s.RotateRefreshToken(ctx, hash, &storage.InsertRefreshToken{
+				TokenHash: nextHash,
+				FamilyID:  familyID,
+				UserID:    userID,
+				ExpiresAt: time.Now().Add(time.Hour),
+			})
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				t.Errorf("rotating refresh token: %s", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := s.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+				t.Errorf("revoking refresh tokens: %s", err)
+			}
+		}()
+		wg.Wait()
+
+		next, err := s.GetRefreshToken(ctx, nextHash)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("getting rotated refresh token: %s", err)
+		}
+		if next.RevokedAt == nil {
+			t.Fatalf("token rotated during revocation isn't revoked: %+v", next)
+		}
+	}
+}
+
+func testDeleteExpiredRefreshTokens(t *testing.T, s storage.RefreshTokenStorage, userID string) {
+	ctx := context.Background()
+	insertToken(t, s, "expired", uuid.NewString(), userID, time.Now().Add(-time.Hour))
+	insertToken(t, s, "valid", uuid.NewString(), userID, time.Now().Add(time.Hour))
+
+	deleted, err := s.DeleteExpiredRefreshTokens(ctx, time.Now())
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 deleted, got %d: %v", deleted, err)
+	}
+
+	_, err = s.GetRefreshToken(ctx, "expired")
+	expectErr(t, err, storage.ErrNotFound)
+	if _, err := s.GetRefreshToken(ctx, "valid"); err != nil {
+		t.Fatalf("valid token is deleted: %s", err)
+	}
+}
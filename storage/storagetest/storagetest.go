@@ -0,0 +1,499 @@
+// storagetest package contains conformance tests which every storage.UserStorage
+// implementation has to pass, so that behavior doesn't drift between backends.
+
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/storage"
+)
+
+// NewUserStorage returns an empty storage for a single test.
+type NewUserStorage func(t *testing.T) storage.UserStorage
+
+// RunUserStorageTests runs the conformance suite against storages created by newStorage.
+func RunUserStorageTests(t *testing.T, newStorage NewUserStorage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.UserStorage)
+	}{
+		{"InsertAndGet", testInsertAndGet},
+		{"GetNotFound", testGetNotFound},
+		{"UniqueEmail", testUniqueEmail},
+		{"Update", testUpdate},
+		{"Patch", testPatch},
+		{"Version", testVersion},
+		{"DeleteAndUndelete", testDeleteAndUndelete},
+		{"Purge", testPurge},
+		{"Filters", testFilters},
+		{"Order", testOrder},
+		{"ByteOrder", testByteOrder},
+		{"Pagination", testPagination},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStorage(t))
+		})
+	}
+}
+
+func insert(t *testing.T, s storage.UserStorage, firstName, lastName, email, country string) *storage.UserModel {
+	t.Helper()
+
+	u, err := s.InsertUser(context.Background(), &storage.InsertUser{
+		FirstName: firstName,
+		LastName:  lastName,
+		Email:     email,
+		Country:   country,
+		Password:  "hashed_password",
+	})
+	if err != nil {
+		t.Fatalf("inserting user: %s", err)
+	}
+
+	// Keeps creation time of users distinct, so that default order is the insertion order.
+	time.Sleep(time.Millisecond)
+
+	return u
+}
+
+func expectErr(t *testing.T, err, expected error) {
+	t.Helper()
+
+	if !errors.Is(err, expected) {
+		t.Fatalf("expected %v, got: %v", expected, err)
+	}
+}
+
+func expectIDs(t *testing.T, users []*storage.UserModel, ids ...string) {
+	t.Helper()
+
+	got := make([]string, 0, len(users))
+	for _, u := range users {
+		got = append(got, u.ID)
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Fatalf("expected users %v, got %v", ids, got)
+	}
+}
+
+func search(t *testing.T, s storage.UserStorage, filters *storage.Filters) []*storage.UserModel {
+	t.Helper()
+
+	users, err := s.SearchUser(context.Background(), filters, &storage.Page{Limit: 100})
+	if err != nil {
+		t.Fatalf("searching users: %s", err)
+	}
+
+	return users
+}
+
+func testInsertAndGet(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Minute)
+
+	u := insert(t, s, "John", "Doe", " John.Doe@Example.com ", "US")
+	if u.ID == "" || u.FirstName != "John" || u.LastName != "Doe" || u.Country != "US" || u.Password != "hashed_password" {
+		t.Fatalf("unexpected inserted user: %+v", u)
+	}
+	if u.Email != "john.doe@example.com" {
+		t.Fatalf("email isn't normalized: %q", u.Email)
+	}
+	if u.Version != 1 || u.DeletedAt != nil || u.CreatedAt.Before(before) || u.UpdatedAt.Before(before) {
+		t.Fatalf("unexpected inserted user metadata: %+v", u)
+	}
+
+	got, err := s.GetUser(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("getting user: %s", err)
+	}
+	if got.ID != u.ID || got.Email != u.Email || !got.CreatedAt.Equal(u.CreatedAt) {
+		t.Fatalf("got user %+v, expected %+v", got, u)
+	}
+
+	got, err = s.GetUserByEmail(ctx, "JOHN.DOE@example.COM")
+	if err != nil {
+		t.Fatalf("getting user by email: %s", err)
+	}
+	if got.ID != u.ID {
+		t.Fatalf("got user %s, expected %s", got.ID, u.ID)
+	}
+}
+
+func testGetNotFound(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+
+	_, err := s.GetUserByEmail(ctx, "missing@example.com")
+	expectErr(t, err, storage.ErrNotFound)
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"missing", "00000000-0000-4000-8000-000000000000"},
+		// Storages with UUID columns don't fail on ids which can't be parsed.
+		{"malformed", "not-a-uuid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.GetUser(ctx, test.id)
+			expectErr(t, err, storage.ErrNotFound)
+
+			for _, version := range []int64{0, 1} {
+				_, err = s.UpdateUser(ctx, &storage.UpdateUser{ID: test.id, Email: "missing@example.com", ExpectedVersion: version})
+				expectErr(t, err, storage.ErrNotFound)
+
+				country := "HR"
+				_, err = s.PatchUser(ctx, &storage.PatchUser{ID: test.id, Country: &country, ExpectedVersion: version})
+				expectErr(t, err, storage.ErrNotFound)
+
+				expectErr(t, s.DeleteUser(ctx, test.id, version), storage.ErrNotFound)
+			}
+
+			_, err = s.UndeleteUser(ctx, test.id)
+			expectErr(t, err, storage.ErrNotFound)
+		})
+	}
+}
+
+func testUniqueEmail(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+
+	insert(t, s, "John", "Doe", "john@example.com", "US")
+	jane := insert(t, s, "Jane", "Doe", "jane@example.com", "US")
+
+	_, err := s.InsertUser(ctx, &storage.InsertUser{Email: "JOHN@example.com"})
+	expectErr(t, err, storage.ErrAlreadyExists)
+
+	_, err = s.UpdateUser(ctx, &storage.UpdateUser{ID: jane.ID, Email: "John@Example.com"})
+	expectErr(t, err, storage.ErrAlreadyExists)
+
+	email := "john@EXAMPLE.com"
+	_, err = s.PatchUser(ctx, &storage.PatchUser{ID: jane.ID, Email: &email})
+	expectErr(t, err, storage.ErrAlreadyExists)
+
+	// Keeping own email isn't a conflict.
+	email = "JANE@example.com"
+	if _, err := s.PatchUser(ctx, &storage.PatchUser{ID: jane.ID, Email: &email}); err != nil {
+		t.Fatalf("patching own email: %s", err)
+	}
+}
+
+func testUpdate(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	u := insert(t, s, "John", "Doe", "john@example.com", "US")
+
+	updated, err := s.UpdateUser(ctx, &storage.UpdateUser{
+		ID:        u.ID,
+		FirstName: "Johnny",
+		LastName:  "Dough",
+		Email:     "Johnny@Example.com",
+		Country:   "HR",
+		Password:  "new_hash",
+	})
+	if err != nil {
+		t.Fatalf("updating user: %s", err)
+	}
+
+	if updated.ID != u.ID || updated.FirstName != "Johnny" || updated.LastName != "Dough" ||
+		updated.Email != "johnny@example.com" || updated.Country != "HR" || updated.Password != "new_hash" {
+		t.Fatalf("unexpected updated user: %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(u.CreatedAt) || updated.UpdatedAt.Before(u.UpdatedAt) || updated.Version != u.Version+1 {
+		t.Fatalf("unexpected updated user metadata: %+v", updated)
+	}
+
+	got, err := s.GetUser(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("getting user: %s", err)
+	}
+	if got.FirstName != "Johnny" || got.Version != updated.Version {
+		t.Fatalf("update isn't stored: %+v", got)
+	}
+}
+
+func testPatch(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	u := insert(t, s, "John", "Doe", "john@example.com", "US")
+
+	country := "HR"
+	patched, err := s.PatchUser(ctx, &storage.PatchUser{ID: u.ID, Country: &country})
+	if err != nil {
+		t.Fatalf("patching user: %s", err)
+	}
+
+	if patched.Country != "HR" || patched.FirstName != "John" || patched.LastName != "Doe" ||
+		patched.Email != "john@example.com" || patched.Password != "hashed_password" {
+		t.Fatalf("unexpected patched user: %+v", patched)
+	}
+	if !patched.CreatedAt.Equal(u.CreatedAt) || patched.Version != u.Version+1 {
+		t.Fatalf("unexpected patched user metadata: %+v", patched)
+	}
+}
+
+func testVersion(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	u := insert(t, s, "John", "Doe", "john@example.com", "US")
+
+	country := "HR"
+	_, err := s.PatchUser(ctx, &storage.PatchUser{ID: u.ID, Country: &country, ExpectedVersion: u.Version + 1})
+	expectErr(t, err, storage.ErrVersionMismatch)
+
+	patched, err := s.PatchUser(ctx, &storage.PatchUser{ID: u.ID, Country: &country, ExpectedVersion: u.Version})
+	if err != nil {
+		t.Fatalf("patching user: %s", err)
+	}
+
+	_, err = s.UpdateUser(ctx, &storage.UpdateUser{ID: u.ID, Email: "john@example.com", ExpectedVersion: u.Version})
+	expectErr(t, err, storage.ErrVersionMismatch)
+
+	updated, err := s.UpdateUser(ctx, &storage.UpdateUser{ID: u.ID, Email: "john@example.com", ExpectedVersion: patched.Version})
+	if err != nil {
+		t.Fatalf("updating user: %s", err)
+	}
+
+	expectErr(t, s.DeleteUser(ctx, u.ID, patched.Version), storage.ErrVersionMismatch)
+	if err := s.DeleteUser(ctx, u.ID, updated.Version); err != nil {
+		t.Fatalf("deleting user: %s", err)
+	}
+
+	// Deleted users are reported as missing rather than as a version mismatch.
+	expectErr(t, s.DeleteUser(ctx, u.ID, updated.Version), storage.ErrNotFound)
+}
+
+func testDeleteAndUndelete(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	u := insert(t, s, "John", "Doe", "john@example.com", "US")
+
+	if err := s.DeleteUser(ctx, u.ID, 0); err != nil {
+		t.Fatalf("deleting user: %s", err)
+	}
+	expectErr(t, s.DeleteUser(ctx, u.ID, 0), storage.ErrNotFound)
+
+	_, err := s.GetUser(ctx, u.ID)
+	expectErr(t, err, storage.ErrNotFound)
+	_, err = s.GetUserByEmail(ctx, "john@example.com")
+	expectErr(t, err, storage.ErrNotFound)
+	country := "HR"
+	_, err = s.PatchUser(ctx, &storage.PatchUser{ID: u.ID, Country: &country})
+	expectErr(t, err, storage.ErrNotFound)
+
+	expectIDs(t, search(t, s, &storage.Filters{}))
+	deleted := search(t, s, &storage.Filters{ShowDeleted: true})
+	expectIDs(t, deleted, u.ID)
+	if deleted[0].DeletedAt == nil {
+		t.Fatal("deleted user has no deleted at")
+	}
+
+	count, err := s.CountUsers(ctx, &storage.Filters{})
+	if err != nil || count != 0 {
+		t.Fatalf("expected 0 users, got %d: %v", count, err)
+	}
+
+	// Email of a deleted user can be used again, but then the user can't be restored.
+	other := insert(t, s, "Other", "John", "john@example.com", "US")
+	_, err = s.UndeleteUser(ctx, u.ID)
+	expectErr(t, err, storage.ErrAlreadyExists)
+
+	if err := s.DeleteUser(ctx, other.ID, 0); err != nil {
+		t.Fatalf("deleting user: %s", err)
+	}
+
+	restored, err := s.UndeleteUser(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("undeleting user: %s", err)
+	}
+	if restored.DeletedAt != nil || restored.Version <= u.Version {
+		t.Fatalf("unexpected restored user: %+v", restored)
+	}
+
+	_, err = s.UndeleteUser(ctx, u.ID)
+	expectErr(t, err, storage.ErrNotFound)
+
+	if _, err := s.GetUser(ctx, u.ID); err != nil {
+		t.Fatalf("getting restored user: %s", err)
+	}
+}
+
+func testPurge(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	kept := insert(t, s, "John", "Doe", "john@example.com", "US")
+	deleted := insert(t, s, "Jane", "Doe", "jane@example.com", "US")
+
+	if err := s.DeleteUser(ctx, deleted.ID, 0); err != nil {
+		t.Fatalf("deleting user: %s", err)
+	}
+
+	purged, err := s.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Fatalf("expected nothing purged, got %d: %v", purged, err)
+	}
+
+	purged, err = s.PurgeDeletedUsers(ctx, time.Now().Add(time.Hour))
+	if err != nil || purged != 1 {
+		t.Fatalf("expected 1 purged, got %d: %v", purged, err)
+	}
+
+	_, err = s.UndeleteUser(ctx, deleted.ID)
+	expectErr(t, err, storage.ErrNotFound)
+	expectIDs(t, search(t, s, &storage.Filters{ShowDeleted: true}), kept.ID)
+}
+
+func testFilters(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	john := insert(t, s, "John", "Doe", "john@example.com", "US")
+	jane := insert(t, s, "Jane", "Smith", "jane@example.org", "HR")
+	joe := insert(t, s, "Joe", "Johnson", "joe_100%@example.com", "us")
+
+	tests := []struct {
+		name    string
+		filters *storage.Filters
+		ids     []string
+	}{
+		{"none", &storage.Filters{}, []string{john.ID, jane.ID, joe.ID}},
+		{"country", &storage.Filters{Country: "US"}, []string{john.ID, joe.ID}},
+		{"country isn't a pattern", &storage.Filters{Country: "_S"}, nil},
+		{"email", &storage.Filters{Email: "JANE@example.org"}, []string{jane.ID}},
+		{"name prefix", &storage.Filters{NamePrefix: "jo"}, []string{john.ID, joe.ID}},
+		{"last name prefix", &storage.Filters{NamePrefix: "smi"}, []string{jane.ID}},
+		{"query", &storage.Filters{Query: "example.org"}, []string{jane.ID}},
+		{"query words", &storage.Filters{Query: "john DOE"}, []string{john.ID}},
+		{"query escaping", &storage.Filters{Query: "100%"}, []string{joe.ID}},
+		{"query wildcard", &storage.Filters{Query: "j%e"}, nil},
+		{"created after", &storage.Filters{CreatedAfter: time.Now().Add(-time.Hour)}, []string{john.ID, jane.ID, joe.ID}},
+		{"created before", &storage.Filters{CreatedBefore: time.Now().Add(-time.Hour)}, nil},
+		{"updated after", &storage.Filters{UpdatedAfter: time.Now().Add(time.Hour)}, nil},
+		{"updated before", &storage.Filters{UpdatedBefore: time.Now().Add(time.Hour)}, []string{john.ID, jane.ID, joe.ID}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectIDs(t, search(t, s, test.filters), test.ids...)
+
+			count, err := s.CountUsers(ctx, test.filters)
+			if err != nil {
+				t.Fatalf("counting users: %s", err)
+			}
+			if count != int64(len(test.ids)) {
+				t.Fatalf("expected count %d, got %d", len(test.ids), count)
+			}
+		})
+	}
+}
+
+func testOrder(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	a := insert(t, s, "anna", "smith", "anna@example.com", "US")
+	b := insert(t, s, "bob", "doe", "bob@example.com", "HR")
+	c := insert(t, s, "carl", "doe", "carl@example.com", "US")
+
+	tests := []struct {
+		orderBy string
+		ids     []string
+	}{
+		{"", []string{a.ID, b.ID, c.ID}},
+		{"created_at desc", []string{c.ID, b.ID, a.ID}},
+		{"first_name desc", []string{c.ID, b.ID, a.ID}},
+		{"last_name, first_name desc", []string{c.ID, b.ID, a.ID}},
+		{"country, email", []string{b.ID, a.ID, c.ID}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.orderBy, func(t *testing.T) {
+			order, err := storage.ParseOrderBy(test.orderBy)
+			if err != nil {
+				t.Fatalf("parsing order: %s", err)
+			}
+
+			users, err := s.SearchUser(ctx, &storage.Filters{}, &storage.Page{Order: order, Limit: 10})
+			if err != nil {
+				t.Fatalf("searching users: %s", err)
+			}
+			expectIDs(t, users, test.ids...)
+		})
+	}
+}
+
+// testByteOrder checks that text is ordered byte-wise, e.g. upper case before lower case,
+// regardless of the database collation.
+func testByteOrder(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	a := insert(t, s, "anna", "smith", "anna@example.com", "US")
+	b := insert(t, s, "Bob", "doe", "Bob@example.com", "HR")
+	c := insert(t, s, "carl", "doe", "carl@example.com", "US")
+
+	order, err := storage.ParseOrderBy("first_name")
+	if err != nil {
+		t.Fatalf("parsing order: %s", err)
+	}
+	users, err := s.SearchUser(ctx, &storage.Filters{}, &storage.Page{Order: order, Limit: 10})
+	if err != nil {
+		t.Fatalf("searching users: %s", err)
+	}
+	expectIDs(t, users, b.ID, a.ID, c.ID)
+
+	users, err = s.SearchUser(ctx, &storage.Filters{}, &storage.Page{Order: order, Limit: 10, After: storage.SortValues(b, order)})
+	if err != nil {
+		t.Fatalf("searching users: %s", err)
+	}
+	expectIDs(t, users, a.ID, c.ID)
+}
+
+func testPagination(t *testing.T, s storage.UserStorage) {
+	ctx := context.Background()
+	for i := 0; i < 7; i++ {
+		insert(t, s, fmt.Sprintf("user%d", i), []string{"doe", "smith"}[i%2], fmt.Sprintf("user%d@example.com", i), "US")
+	}
+
+	for _, orderBy := range []string{"", "last_name desc", "last_name, created_at desc"} {
+		t.Run(orderBy, func(t *testing.T) {
+			order, err := storage.ParseOrderBy(orderBy)
+			if err != nil {
+				t.Fatalf("parsing order: %s", err)
+			}
+
+			all, err := s.SearchUser(ctx, &storage.Filters{}, &storage.Page{Order: order, Limit: 100})
+			if err != nil {
+				t.Fatalf("searching users: %s", err)
+			}
+			if len(all) != 7 {
+				t.Fatalf("expected 7 users, got %d", len(all))
+			}
+
+			offsetPage, err := s.SearchUser(ctx, &storage.Filters{}, &storage.Page{Order: order, Offset: 2, Limit: 3})
+			if err != nil {
+				t.Fatalf("searching users: %s", err)
+			}
+			expectIDs(t, offsetPage, all[2].ID, all[3].ID, all[4].ID)
+
+			walked := []*storage.UserModel{}
+			page := &storage.Page{Order: order, Limit: 3}
+			for i := 0; i < 10; i++ {
+				users, err := s.SearchUser(ctx, &storage.Filters{}, page)
+				if err != nil {
+					t.Fatalf("searching users: %s", err)
+				}
+				if len(users) == 0 {
+					break
+				}
+
+				walked = append(walked, users...)
+				page = &storage.Page{Order: order, Limit: 3, After: storage.SortValues(users[len(users)-1], order)}
+			}
+
+			ids := make([]string, 0, len(all))
+			for _, u := range all {
+				ids = append(ids, u.ID)
+			}
+			expectIDs(t, walked, ids...)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/toncek345/userservice/storage")
+
+// tracedDB starts a span around every query run on the wrapped database or transaction.
+// Statements are recorded without their arguments.
+type tracedDB struct {
+	q      sqlx.ExtContext
+	system attribute.KeyValue
+}
+
+var _ sqlx.QueryerContext = (*tracedDB)(nil)
+
+func (d *dialect) traced(q sqlx.ExtContext) *tracedDB {
+	return &tracedDB{q: q, system: d.system}
+}
+
+func (t *tracedDB) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	operation = strings.ToUpper(operation)
+
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			t.system,
+			semconv.DBOperationKey.String(operation),
+			semconv.DBStatementKey.String(query),
+		))
+}
+
+// end records err on span unless it only means that no row was found.
+func end(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *tracedDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := t.start(ctx, query)
+	err := sqlx.GetContext(ctx, t.q, dest, query, args...)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	ctx, span := t.start(ctx, query)
+	err := sqlx.SelectContext(ctx, t.q, dest, query, args...)
+	end(span, err)
+
+	return err
+}
+
+func (t *tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := t.start(ctx, query)
+	res, err := t.q.ExecContext(ctx, query, args...)
+	end(span, err)
+
+	return res, err
+}
+
+// QueryContext and QueryxContext end the span when the query is executed, reading of the rows
+// isn't included.
+func (t *tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := t.start(ctx, query)
+	rows, err := t.q.QueryContext(ctx, query, args...)
+	end(span, err)
+
+	return rows, err
+}
+
+func (t *tracedDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, span := t.start(ctx, query)
+	rows, err := t.q.QueryxContext(ctx, query, args...)
+	end(span, err)
+
+	return rows, err
+}
+
+func (t *tracedDB) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx, span := t.start(ctx, query)
+	row := t.q.QueryRowxContext(ctx, query, args...)
+	end(span, row.Err())
+
+	return row
+}
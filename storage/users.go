@@ -5,10 +5,17 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/toncek345/userservice/logging"
+
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"golang.org/x/exp/slog"
 )
 
 var _ UserStorage = (*UserStorageSQL)(nil)
@@ -16,16 +23,71 @@ var _ UserStorage = (*MockUser)(nil)
 
 type UserStorage interface {
 	InsertUser(ctx context.Context, user *InsertUser) (*UserModel, error)
-	DeleteUser(ctx context.Context, id string) error
+	// DeleteUser marks the user as deleted. Deleted users are hidden from get and search unless
+	// requested otherwise and can be restored with UndeleteUser until they are purged.
+	DeleteUser(ctx context.Context, id string, expectedVersion int64) error
+	UndeleteUser(ctx context.Context, id string) (*UserModel, error)
+	// PurgeDeletedUsers permanently removes users deleted before the given time and returns
+	// their number.
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error)
 	UpdateUser(ctx context.Context, user *UpdateUser) (*UserModel, error)
-	SearchUser(ctx context.Context, filters *Filters, offset, limit int64) ([]*UserModel, error)
+	// PatchUser updates only the fields which are set, others are left unchanged.
+	PatchUser(ctx context.Context, user *PatchUser) (*UserModel, error)
+	SearchUser(ctx context.Context, filters *Filters, page *Page) ([]*UserModel, error)
+	CountUsers(ctx context.Context, filters *Filters) (int64, error)
+	GetUser(ctx context.Context, id string) (*UserModel, error)
+	GetUserByEmail(ctx context.Context, email string) (*UserModel, error)
 }
 
 // ErrNotFound is returned as an error if object doesn't exist in DB.
 var ErrNotFound = errors.New("object doesn't exist in db")
 
+// ErrVersionMismatch is returned if object was modified since the expected version.
+var ErrVersionMismatch = errors.New("object version mismatch")
+
+// ErrAlreadyExists is returned as an error if object violates a uniqueness constraint in DB.
+var ErrAlreadyExists = errors.New("object already exists in db")
+
+// pgUniqueViolation is postgres error code for unique_violation.
+const pgUniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation
+}
+
+// pgInvalidTextRepresentation is postgres error code for invalid_text_representation.
+const pgInvalidTextRepresentation = "22P02"
+
+// isMalformedID reports whether err is caused by an id which isn't a UUID. No user has such
+// id, so it is ErrNotFound like in the other storages.
+func isMalformedID(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgInvalidTextRepresentation
+}
+
+// rollback rolls back tx after a failed statement. Error of the statement is returned to the
+// caller, so rollback errors are only logged.
+func rollback(ctx context.Context, logger *slog.Logger, tx *sqlx.Tx) {
+	if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+		logging.FromContext(ctx, logger).WarnCtx(ctx, "transaction rollback failed", "error", err)
+	}
+}
+
+// NormalizeEmail returns email in a form in which it is stored in DB. Emails are compared
+// case-insensitively so two users can't register with addresses that differ only in case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 type UserStorageSQL struct {
 	DB *sqlx.DB
+	// Logger logs failed rollbacks, slog.Default is used if it is nil.
+	Logger *slog.Logger
+}
+
+func (us *UserStorageSQL) traced(q sqlx.ExtContext) *tracedDB {
+	return postgresDialect.traced(q)
 }
 
 type InsertUser struct {
@@ -44,15 +106,18 @@ func (us *UserStorageSQL) InsertUser(ctx context.Context, user *InsertUser) (*Us
 		return nil, fmt.Errorf("starting transaction: %w", err)
 	}
 
-	if err := tx.GetContext(
+	if err := us.traced(tx).GetContext(
 		ctx,
 		u,
 		`INSERT INTO users (id, first_name, last_name, email, country, password, created_at, updated_at) VALUES
 		(uuid_generate_v4(), $1, $2, $3, $4, $5, NOW(), NOW()) RETURNING
-		id, first_name, last_name, email, country, password, created_at, updated_at`,
-		user.FirstName, user.LastName, user.Email, user.Country, user.Password); err != nil {
+		id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version`,
+		user.FirstName, user.LastName, NormalizeEmail(user.Email), user.Country, user.Password); err != nil {
 
-		tx.Rollback()
+		rollback(ctx, us.Logger, tx)
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
 		return nil, fmt.Errorf("inserting user: %w", err)
 	}
 
@@ -70,6 +135,35 @@ type UpdateUser struct {
 	Email     string
 	Country   string
 	Password  string
+	// ExpectedVersion is checked against the stored version if it isn't 0.
+	ExpectedVersion int64
+}
+
+// versionConflict returns why a modification of a user with expected version didn't affect
+// any row. It is ErrVersionMismatch if the user exists, otherwise ErrNotFound.
+func versionConflict(ctx context.Context, q sqlx.QueryerContext, id string, expectedVersion int64) error {
+	if expectedVersion == 0 {
+		return ErrNotFound
+	}
+
+	var exists bool
+	if err := sqlx.GetContext(
+		ctx,
+		q,
+		&exists,
+		"SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)",
+		id); err != nil {
+		if isMalformedID(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("checking user existence: %w", err)
+	}
+
+	if exists {
+		return ErrVersionMismatch
+	}
+
+	return ErrNotFound
 }
 
 func (us *UserStorageSQL) UpdateUser(ctx context.Context, user *UpdateUser) (*UserModel, error) {
@@ -80,18 +174,28 @@ func (us *UserStorageSQL) UpdateUser(ctx context.Context, user *UpdateUser) (*Us
 		return nil, fmt.Errorf("starting transaction: %w", err)
 	}
 
-	if err := tx.GetContext(
+	if err := us.traced(tx).GetContext(
 		ctx,
 		u,
-		`UPDATE users SET first_name = $1, last_name = $2, email = $3, country = $4, password = $5, updated_at = NOW()
-		WHERE users.id = $6 RETURNING
-		id, first_name, last_name, email, country, password, updated_at,
+		`UPDATE users SET first_name = $1, last_name = $2, email = $3, country = $4, password = $5, updated_at = NOW(),
+		version = version + 1
+		WHERE users.id = $6 AND deleted_at IS NULL AND ($7::bigint = 0 OR version = $7) RETURNING
+		id, first_name, last_name, email, country, password, updated_at, deleted_at, version,
 		(SELECT created_at FROM users WHERE id = $6) AS created_at`,
-		user.FirstName, user.LastName, user.Email, user.Country, user.Password, user.ID); err != nil {
+		user.FirstName, user.LastName, NormalizeEmail(user.Email), user.Country, user.Password, user.ID,
+		user.ExpectedVersion); err != nil {
 		if err == sql.ErrNoRows {
+			err = versionConflict(ctx, us.traced(tx), user.ID, user.ExpectedVersion)
+			rollback(ctx, us.Logger, tx)
+			return nil, err
+		}
+		rollback(ctx, us.Logger, tx)
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		if isMalformedID(err) {
 			return nil, ErrNotFound
 		}
-		tx.Rollback()
 		return nil, fmt.Errorf("updating user: %w", err)
 	}
 
@@ -102,6 +206,67 @@ func (us *UserStorageSQL) UpdateUser(ctx context.Context, user *UpdateUser) (*Us
 	return u, nil
 }
 
+type PatchUser struct {
+	ID string
+	// Nil fields are not updated.
+	FirstName *string
+	LastName  *string
+	Email     *string
+	Country   *string
+	Password  *string
+	// ExpectedVersion is checked against the stored version if it isn't 0.
+	ExpectedVersion int64
+}
+
+func (us *UserStorageSQL) PatchUser(ctx context.Context, user *PatchUser) (*UserModel, error) {
+	query := sq.Update("users").
+		PlaceholderFormat(sq.Dollar).
+		Set("updated_at", sq.Expr("NOW()")).
+		Set("version", sq.Expr("version + 1")).
+		Where(sq.Eq{"id": user.ID, "deleted_at": nil}).
+		Suffix("RETURNING id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version")
+
+	if user.FirstName != nil {
+		query = query.Set("first_name", *user.FirstName)
+	}
+	if user.LastName != nil {
+		query = query.Set("last_name", *user.LastName)
+	}
+	if user.Email != nil {
+		query = query.Set("email", NormalizeEmail(*user.Email))
+	}
+	if user.Country != nil {
+		query = query.Set("country", *user.Country)
+	}
+	if user.Password != nil {
+		query = query.Set("password", *user.Password)
+	}
+	if user.ExpectedVersion != 0 {
+		query = query.Where(sq.Eq{"version": user.ExpectedVersion})
+	}
+
+	stmt, args, err := query.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("building sql: %w", err)
+	}
+
+	u := &UserModel{}
+	if err := us.traced(us.DB).GetContext(ctx, u, stmt, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, versionConflict(ctx, us.traced(us.DB), user.ID, user.ExpectedVersion)
+		}
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		if isMalformedID(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("patching user: %w", err)
+	}
+
+	return u, nil
+}
+
 type UserModel struct {
 	// ID is represented in UUID.
 	ID        string    `db:"id"`
@@ -112,18 +277,43 @@ type UserModel struct {
 	Password  string    `db:"password"`
 	CreatedAt time.Time `db:"created_at"`
 	UpdatedAt time.Time `db:"updated_at"`
+	// DeletedAt is nil if user isn't deleted.
+	DeletedAt *time.Time `db:"deleted_at"`
+	// Version is incremented on every modification.
+	Version int64 `db:"version"`
 }
 
-func (us *UserStorageSQL) DeleteUser(ctx context.Context, id string) error {
+// DeleteUser checks expectedVersion against the stored version if it isn't 0.
+func (us *UserStorageSQL) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
 	tx, err := us.DB.Beginx()
 	if err != nil {
 		return fmt.Errorf("starting transaction: %w", err)
 	}
 
-	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id); err != nil {
+	res, err := us.traced(tx).ExecContext(
+		ctx,
+		`UPDATE users SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ($2::bigint = 0 OR version = $2)`,
+		id, expectedVersion)
+	if err != nil {
+		rollback(ctx, us.Logger, tx)
+		if isMalformedID(err) {
+			return ErrNotFound
+		}
 		return fmt.Errorf("sql deleting: %w", err)
 	}
 
+	affected, err := res.RowsAffected()
+	if err != nil {
+		rollback(ctx, us.Logger, tx)
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if affected == 0 {
+		err := versionConflict(ctx, us.traced(tx), id, expectedVersion)
+		rollback(ctx, us.Logger, tx)
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("transaction commit: %w", err)
 	}
@@ -131,15 +321,202 @@ func (us *UserStorageSQL) DeleteUser(ctx context.Context, id string) error {
 	return nil
 }
 
+func (us *UserStorageSQL) UndeleteUser(ctx context.Context, id string) (*UserModel, error) {
+	u := &UserModel{}
+
+	if err := us.traced(us.DB).GetContext(
+		ctx,
+		u,
+		`UPDATE users SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL RETURNING
+		id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version`,
+		id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if isUniqueViolation(err) {
+			return nil, ErrAlreadyExists
+		}
+		if isMalformedID(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("undeleting user: %w", err)
+	}
+
+	return u, nil
+}
+
+func (us *UserStorageSQL) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	res, err := us.traced(us.DB).ExecContext(
+		ctx,
+		"DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1",
+		deletedBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("purging users: %w", err)
+	}
+
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+
+	return purged, nil
+}
+
+func (us *UserStorageSQL) GetUser(ctx context.Context, id string) (*UserModel, error) {
+	u := &UserModel{}
+
+	if err := us.traced(us.DB).GetContext(
+		ctx,
+		u,
+		`SELECT id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version
+		FROM users WHERE id = $1 AND deleted_at IS NULL`,
+		id); err != nil {
+		if err == sql.ErrNoRows || isMalformedID(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting user: %w", err)
+	}
+
+	return u, nil
+}
+
+func (us *UserStorageSQL) GetUserByEmail(ctx context.Context, email string) (*UserModel, error) {
+	u := &UserModel{}
+
+	if err := us.traced(us.DB).GetContext(
+		ctx,
+		u,
+		`SELECT id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version
+		FROM users WHERE lower(email) = $1 AND deleted_at IS NULL`,
+		NormalizeEmail(email)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("getting user by email: %w", err)
+	}
+
+	return u, nil
+}
+
 type Filters struct {
+	// Country is matched case-insensitively.
 	Country string
+	// Email is matched case-insensitively.
+	Email string
+	// NamePrefix matches start of first or last name case-insensitively.
+	NamePrefix string
+	// Query matches users which contain every word of the query in their first name, last
+	// name or email.
+	Query string
+	// Time ranges are ignored if they are zero. After is inclusive, before is exclusive.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// ShowDeleted includes deleted users in the results.
+	ShowDeleted bool
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// dialect contains differences between databases which are needed to build shared queries.
+type dialect struct {
+	placeholder sq.PlaceholderFormat
+	// ilike returns case-insensitive LIKE condition with backslash as the escape character.
+	ilike func(column, pattern string) sq.Sqlizer
+	// timeArg converts time to a query argument.
+	timeArg func(t time.Time) interface{}
+	// system is the db.system attribute of query spans.
+	system attribute.KeyValue
+	// byteOrder is appended to text columns which users are ordered by, so that they are
+	// compared byte-wise in every storage, as in MemoryUserStorage.
+	byteOrder string
 }
 
-func (us *UserStorageSQL) SearchUser(ctx context.Context, filters *Filters, offset, limit int64) ([]*UserModel, error) {
-	query := sq.Select("*").From("users").Offset(uint64(offset)).Limit(uint64(limit))
+var postgresDialect = &dialect{
+	placeholder: sq.Dollar,
+	ilike: func(column, pattern string) sq.Sqlizer {
+		return sq.ILike{column: pattern}
+	},
+	timeArg: func(t time.Time) interface{} {
+		return t.UTC()
+	},
+	system: semconv.DBSystemPostgreSQL,
+	// Default collation of the database orders by locale, e.g. ignoring case.
+	byteOrder: ` COLLATE "C"`,
+}
+
+func applyFilters(query sq.SelectBuilder, filters *Filters, d *dialect) sq.SelectBuilder {
+	if !filters.ShowDeleted {
+		query = query.Where(sq.Eq{"deleted_at": nil})
+	}
 
 	if filters.Country != "" {
-		query = query.Where("country ILIKE $1", filters.Country)
+		query = query.Where(d.ilike("country", likeEscaper.Replace(filters.Country)))
+	}
+
+	if filters.Email != "" {
+		query = query.Where("lower(email) = ?", NormalizeEmail(filters.Email))
+	}
+
+	if filters.NamePrefix != "" {
+		prefix := likeEscaper.Replace(filters.NamePrefix) + "%"
+		query = query.Where(sq.Or{d.ilike("first_name", prefix), d.ilike("last_name", prefix)})
+	}
+
+	for _, word := range strings.Fields(filters.Query) {
+		pattern := "%" + likeEscaper.Replace(word) + "%"
+		query = query.Where(sq.Or{
+			d.ilike("first_name", pattern),
+			d.ilike("last_name", pattern),
+			d.ilike("email", pattern),
+		})
+	}
+
+	if !filters.CreatedAfter.IsZero() {
+		query = query.Where(sq.GtOrEq{"created_at": d.timeArg(filters.CreatedAfter)})
+	}
+	if !filters.CreatedBefore.IsZero() {
+		query = query.Where(sq.Lt{"created_at": d.timeArg(filters.CreatedBefore)})
+	}
+	if !filters.UpdatedAfter.IsZero() {
+		query = query.Where(sq.GtOrEq{"updated_at": d.timeArg(filters.UpdatedAfter)})
+	}
+	if !filters.UpdatedBefore.IsZero() {
+		query = query.Where(sq.Lt{"updated_at": d.timeArg(filters.UpdatedBefore)})
+	}
+
+	return query
+}
+
+// SearchUser returns users matching filters ordered by page order.
+func (us *UserStorageSQL) SearchUser(ctx context.Context, filters *Filters, page *Page) ([]*UserModel, error) {
+	return searchUsers(ctx, us.traced(us.DB), postgresDialect, filters, page)
+}
+
+// CountUsers returns number of users matching filters.
+func (us *UserStorageSQL) CountUsers(ctx context.Context, filters *Filters) (int64, error) {
+	return countUsers(ctx, us.traced(us.DB), postgresDialect, filters)
+}
+
+func searchUsers(ctx context.Context, db *tracedDB, d *dialect, filters *Filters, page *Page) ([]*UserModel, error) {
+	order := page.Order
+	if len(order) == 0 {
+		order = UsersOrder
+	}
+
+	query := sq.Select("id, first_name, last_name, email, country, password, created_at, updated_at, deleted_at, version").
+		From("users").
+		PlaceholderFormat(d.placeholder).
+		OrderBy(orderByClauses(order, d)...).
+		Limit(uint64(page.Limit))
+	query = applyFilters(query, filters, d)
+
+	if len(page.After) > 0 {
+		query = query.Where(keysetCondition(order, page.After, d))
+	} else {
+		query = query.Offset(uint64(page.Offset))
 	}
 
 	sql, args, err := query.ToSql()
@@ -148,9 +525,25 @@ func (us *UserStorageSQL) SearchUser(ctx context.Context, filters *Filters, offs
 	}
 
 	users := []*UserModel{}
-	if err := us.DB.SelectContext(ctx, &users, sql, args...); err != nil {
+	if err := db.SelectContext(ctx, &users, sql, args...); err != nil {
 		return nil, fmt.Errorf("user searching: %w", err)
 	}
 
 	return users, nil
 }
+
+func countUsers(ctx context.Context, db *tracedDB, d *dialect, filters *Filters) (int64, error) {
+	query := applyFilters(sq.Select("COUNT(*)").From("users").PlaceholderFormat(d.placeholder), filters, d)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("building sql: %w", err)
+	}
+
+	var count int64
+	if err := db.GetContext(ctx, &count, sql, args...); err != nil {
+		return 0, fmt.Errorf("user counting: %w", err)
+	}
+
+	return count, nil
+}
@@ -1,23 +1,50 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type MockUser struct {
-	InsertUserFn func(ctx context.Context, user *InsertUser) (*UserModel, error)
-	DeleteUserFn func(ctx context.Context, id string) error
-	UpdateUserFn func(ctx context.Context, user *UpdateUser) (*UserModel, error)
-	SearchUserFn func(ctx context.Context, filters *Filters, offset, limit int64) ([]*UserModel, error)
+	InsertUserFn        func(ctx context.Context, user *InsertUser) (*UserModel, error)
+	DeleteUserFn        func(ctx context.Context, id string, expectedVersion int64) error
+	UndeleteUserFn      func(ctx context.Context, id string) (*UserModel, error)
+	PurgeDeletedUsersFn func(ctx context.Context, deletedBefore time.Time) (int64, error)
+	UpdateUserFn        func(ctx context.Context, user *UpdateUser) (*UserModel, error)
+	PatchUserFn         func(ctx context.Context, user *PatchUser) (*UserModel, error)
+	SearchUserFn        func(ctx context.Context, filters *Filters, page *Page) ([]*UserModel, error)
+	CountUsersFn        func(ctx context.Context, filters *Filters) (int64, error)
+	GetUserFn           func(ctx context.Context, id string) (*UserModel, error)
+	GetUserByEmailFn    func(ctx context.Context, email string) (*UserModel, error)
 }
 
 func (m *MockUser) InsertUser(ctx context.Context, user *InsertUser) (*UserModel, error) {
 	return m.InsertUserFn(ctx, user)
 }
-func (m *MockUser) DeleteUser(ctx context.Context, id string) error {
-	return m.DeleteUserFn(ctx, id)
+func (m *MockUser) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	return m.DeleteUserFn(ctx, id, expectedVersion)
 }
 func (m *MockUser) UpdateUser(ctx context.Context, user *UpdateUser) (*UserModel, error) {
 	return m.UpdateUserFn(ctx, user)
 }
-func (m *MockUser) SearchUser(ctx context.Context, filters *Filters, offset, limit int64) ([]*UserModel, error) {
-	return m.SearchUserFn(ctx, filters, offset, limit)
+func (m *MockUser) SearchUser(ctx context.Context, filters *Filters, page *Page) ([]*UserModel, error) {
+	return m.SearchUserFn(ctx, filters, page)
+}
+func (m *MockUser) CountUsers(ctx context.Context, filters *Filters) (int64, error) {
+	return m.CountUsersFn(ctx, filters)
+}
+func (m *MockUser) GetUser(ctx context.Context, id string) (*UserModel, error) {
+	return m.GetUserFn(ctx, id)
+}
+func (m *MockUser) GetUserByEmail(ctx context.Context, email string) (*UserModel, error) {
+	return m.GetUserByEmailFn(ctx, email)
+}
+func (m *MockUser) PatchUser(ctx context.Context, user *PatchUser) (*UserModel, error) {
+	return m.PatchUserFn(ctx, user)
+}
+func (m *MockUser) UndeleteUser(ctx context.Context, id string) (*UserModel, error) {
+	return m.UndeleteUserFn(ctx, id)
+}
+func (m *MockUser) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	return m.PurgeDeletedUsersFn(ctx, deletedBefore)
 }
@@ -0,0 +1,59 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/toncek345/userservice/migrations"
+	"github.com/toncek345/userservice/storage"
+	"github.com/toncek345/userservice/storage/storagetest"
+)
+
+// PostgresDSNEnv names environment variable with DSN of a database used by SQL storage tests.
+// Tests are skipped when it isn't set, except in CI where they fail. All users in the database
+// are deleted.
+const PostgresDSNEnv = "USERSERVICE_TEST_POSTGRES_DSN"
+
+// openPostgres returns a migrated database from PostgresDSNEnv or skips the test.
+func openPostgres(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv(PostgresDSNEnv)
+	if dsn == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatalf("%s is required in CI", PostgresDSNEnv)
+		}
+		t.Skipf("%s is not set", PostgresDSNEnv)
+	}
+
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("opening db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ms, err := migrations.Embedded()
+	if err != nil {
+		t.Fatalf("loading migrations: %s", err)
+	}
+	if err := (&migrations.Migrator{DB: db, Migrations: ms}).Up(context.Background()); err != nil {
+		t.Fatalf("migrating db: %s", err)
+	}
+
+	return db
+}
+
+func TestUserStorageSQL(t *testing.T) {
+	db := openPostgres(t)
+	storagetest.RunUserStorageTests(t, func(t *testing.T) storage.UserStorage {
+		// Refresh tokens reference users.
+		if _, err := db.Exec("TRUNCATE users CASCADE"); err != nil {
+			t.Fatalf("truncating users: %s", err)
+		}
+
+		return &storage.UserStorageSQL{DB: db}
+	})
+}
@@ -0,0 +1,9 @@
+package tokens
+
+import "github.com/golang-jwt/jwt/v4"
+
+// Claims of access tokens. Subject is ID of the user.
+type Claims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email,omitempty"`
+}
@@ -0,0 +1,56 @@
+package tokens
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// JWKSPath is the well-known path of the JWKS.
+const JWKSPath = "/.well-known/jwks.json"
+
+// JSONWebKey is a public key as defined by RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// Curve and X are set for EdDSA keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// N and E are set for RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns public keys which verify tokens, the signing key is the last one.
+func (ks *KeySet) JWKS() *JSONWebKeySet {
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range ks.current() {
+		set.Keys = append(set.Keys, key.jwk)
+	}
+
+	return set
+}
+
+// jwksMaxAge is how long clients may cache the key set. Tokens signed by a new key can't be
+// verified with a cached key set, so clients should fetch it again on unknown key IDs.
+const jwksMaxAge = time.Minute
+
+// ServeHTTP responds with the JWKS, it is served on JWKSPath.
+func (ks *KeySet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(ks.JWKS())
+}
@@ -0,0 +1,353 @@
+// tokens package signs and verifies JWT access tokens with rotating keys and publishes their
+// public keys as a JSON Web Key Set.
+
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/exp/slog"
+)
+
+// Algorithms of signing keys.
+const (
+	EdDSA = "EdDSA"
+	RS256 = "RS256"
+)
+
+// DefaultCheckInterval is how often the key directory is checked for changes if
+// KeySet.CheckInterval is 0.
+const DefaultCheckInterval = 10 * time.Second
+
+// rsaKeyBits is size of generated RSA keys and the minimum size of loaded ones.
+const rsaKeyBits = 2048
+
+type signingKey struct {
+	// id is RFC 7638 thumbprint of the public key.
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	jwk     JSONWebKey
+	// retiredAt is when the key stopped signing, it is zero for the signing key.
+	retiredAt time.Time
+}
+
+func newSigningKey(private crypto.Signer) (*signingKey, error) {
+	key := &signingKey{private: private}
+
+	var thumbprint string
+	switch pub := private.Public().(type) {
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.jwk = JSONWebKey{KeyType: "OKP", Curve: "Ed25519", X: encode(pub)}
+		thumbprint = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":%q}`, key.jwk.X)
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < rsaKeyBits {
+			return nil, fmt.Errorf("rsa key has %d bits, at least %d are required", pub.N.BitLen(), rsaKeyBits)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.jwk = JSONWebKey{KeyType: "RSA", N: encode(pub.N.Bytes()), E: encode(big.NewInt(int64(pub.E)).Bytes())}
+		thumbprint = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, key.jwk.E, key.jwk.N)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	sum := sha256.Sum256([]byte(thumbprint))
+	key.id = encode(sum[:])
+	key.jwk.KeyID = key.id
+	key.jwk.Use = "sig"
+	key.jwk.Algorithm = key.method.Alg()
+
+	return key, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func generateKey(algorithm string) (*signingKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case EdDSA, "":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	return newSigningKey(private)
+}
+
+// KeySet signs tokens with its newest key and verifies them with all of its keys. Keys are
+// generated in memory and rotated every RotationInterval, or loaded from Dir.
+type KeySet struct {
+	// Algorithm of generated keys, EdDSA or RS256. EdDSA is used if it is empty.
+	Algorithm string
+	// RotationInterval is how often a new key is generated, keys aren't rotated if it is 0.
+	RotationInterval time.Duration
+	// Retention is how long a replaced key verifies tokens signed before the rotation. It
+	// should be at least the lifetime of the tokens.
+	Retention time.Duration
+	// Dir contains PEM encoded PKCS #8 Ed25519 or RSA private keys in .pem files, which are
+	// used instead of generated keys, e.g. to share them between replicas. Tokens are signed by
+	// the key whose file name is last in lexical order, other keys only verify tokens. Changed
+	// files are reloaded, so keys are rotated by adding a new file.
+	Dir string
+	// CheckInterval is how often Dir is checked for changes, DefaultCheckInterval is used if it
+	// is 0.
+	CheckInterval time.Duration
+	// Logger logs rotations and keys which fail to reload, slog.Default is used if it is nil.
+	Logger *slog.Logger
+	// Now returns current time, time.Now is used if it is nil.
+	Now func() time.Time
+
+	mu sync.Mutex
+	// keys are ordered from the oldest, the last one signs tokens.
+	keys      []*signingKey
+	rotatedAt time.Time
+	// stamp identifies names, sizes and modification times of files in Dir.
+	stamp   string
+	checked time.Time
+}
+
+func (ks *KeySet) logger() *slog.Logger {
+	if ks.Logger == nil {
+		return slog.Default()
+	}
+
+	return ks.Logger
+}
+
+func (ks *KeySet) now() time.Time {
+	if ks.Now != nil {
+		return ks.Now()
+	}
+
+	return time.Now()
+}
+
+func (ks *KeySet) checkInterval() time.Duration {
+	if ks.CheckInterval == 0 {
+		return DefaultCheckInterval
+	}
+
+	return ks.CheckInterval
+}
+
+// Load loads keys from Dir or generates the first key. It has to be called before the key set
+// is used.
+func (ks *KeySet) Load() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.Dir != "" {
+		return ks.loadDir()
+	}
+
+	key, err := generateKey(ks.Algorithm)
+	if err != nil {
+		return err
+	}
+	ks.keys = []*signingKey{key}
+	ks.rotatedAt = ks.now()
+
+	return nil
+}
+
+func (ks *KeySet) dirStamp() (string, []string, error) {
+	files, err := filepath.Glob(filepath.Join(ks.Dir, "*.pem"))
+	if err != nil {
+		return "", nil, fmt.Errorf("listing keys: %w", err)
+	}
+	sort.Strings(files)
+
+	stamp := ""
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", nil, fmt.Errorf("stat key: %w", err)
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+
+	return stamp, files, nil
+}
+
+func loadKeyFile(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no PEM encoded private key", file)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: parsing key: %w", file, err)
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", file, private)
+	}
+
+	key, err := newSigningKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	return key, nil
+}
+
+// loadDir replaces keys with the ones in Dir. Callers must hold the lock.
+func (ks *KeySet) loadDir() error {
+	stamp, files, err := ks.dirStamp()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no .pem keys in %s", ks.Dir)
+	}
+
+	keys := make([]*signingKey, 0, len(files))
+	for _, file := range files {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	ks.keys = keys
+	ks.stamp = stamp
+	ks.checked = ks.now()
+	return nil
+}
+
+// current reloads or rotates keys if it is due and returns them. Keys which fail to reload are
+// logged and the previous keys are kept.
+func (ks *KeySet) current() []*signingKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := ks.now()
+	if ks.Dir != "" {
+		if now.Sub(ks.checked) < ks.checkInterval() {
+			return ks.keys
+		}
+		ks.checked = now
+
+		stamp, _, err := ks.dirStamp()
+		if err == nil && stamp == ks.stamp {
+			return ks.keys
+		}
+		if err == nil {
+			err = ks.loadDir()
+		}
+		if err != nil {
+			ks.logger().Error("reloading signing keys failed", "dir", ks.Dir, "error", err)
+			return ks.keys
+		}
+
+		ks.logger().Info("signing keys reloaded", "key_id", ks.keys[len(ks.keys)-1].id, "keys", len(ks.keys))
+		return ks.keys
+	}
+
+	if ks.RotationInterval > 0 && now.Sub(ks.rotatedAt) >= ks.RotationInterval {
+		key, err := generateKey(ks.Algorithm)
+		if err != nil {
+			ks.logger().Error("rotating signing key failed", "error", err)
+		} else {
+			ks.keys[len(ks.keys)-1].retiredAt = now
+			ks.keys = append(ks.keys, key)
+			ks.rotatedAt = now
+			ks.logger().Info("signing key rotated", "key_id", key.id)
+		}
+	}
+
+	// Retired keys are dropped once tokens signed by them expired. Callers use the returned
+	// slice without the lock, so it isn't modified in place.
+	keys := make([]*signingKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		if k.retiredAt.IsZero() || now.Sub(k.retiredAt) < ks.Retention {
+			keys = append(keys, k)
+		}
+	}
+	ks.keys = keys
+
+	return ks.keys
+}
+
+// Sign returns token with claims signed by the current key, whose ID is in the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	keys := ks.current()
+	if len(keys) == 0 {
+		return "", errors.New("key set isn't loaded")
+	}
+	key := keys[len(keys)-1]
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// ErrInvalidToken is returned by Parse when the token is malformed, its signature doesn't
+// match any key or it expired.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Parse verifies signature and expiration of the token and returns its claims. Issuer and
+// audience are checked by the caller.
+func (ks *KeySet) Parse(token string) (*Claims, error) {
+	keys := ks.current()
+	claims := &Claims{}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{EdDSA, RS256}), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		for _, key := range keys {
+			if key.id == kid && key.method.Alg() == t.Method.Alg() {
+				return key.private.Public(), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	now := ks.now()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return nil, fmt.Errorf("%w: token isn't valid yet", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,261 @@
+package tokens_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toncek345/userservice/tokens"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func claims(subject string, expiresAt time.Time) *tokens.Claims {
+	return &tokens.Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}}
+}
+
+func keyID(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &tokens.Claims{})
+	if err != nil {
+		t.Fatalf("parsing token: %s", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+
+	return kid
+}
+
+func sign(t *testing.T, ks *tokens.KeySet, c *tokens.Claims) string {
+	t.Helper()
+
+	token, err := ks.Sign(c)
+	if err != nil {
+		t.Fatalf("signing token: %s", err)
+	}
+
+	return token
+}
+
+func TestKeySetRotation(t *testing.T) {
+	for _, algorithm := range []string{tokens.EdDSA, tokens.RS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			c := &clock{now: time.Now()}
+			ks := &tokens.KeySet{
+				Algorithm:        algorithm,
+				RotationInterval: time.Hour,
+				Retention:        15 * time.Minute,
+				Now:              c.Now,
+			}
+			if err := ks.Load(); err != nil {
+				t.Fatalf("load: %s", err)
+			}
+
+			old := sign(t, ks, claims("user", c.now.Add(10*time.Hour)))
+			parsed, err := ks.Parse(old)
+			if err != nil {
+				t.Fatalf("parse: %s", err)
+			}
+			if parsed.Subject != "user" {
+				t.Fatalf("expected subject user, got %s", parsed.Subject)
+			}
+
+			c.now = c.now.Add(time.Hour)
+			current := sign(t, ks, claims("user", c.now.Add(10*time.Hour)))
+			if keyID(t, old) == keyID(t, current) {
+				t.Fatal("key isn't rotated")
+			}
+			if _, err := ks.Parse(old); err != nil {
+				t.Fatalf("token of the retired key isn't valid: %s", err)
+			}
+			if keys := ks.JWKS().Keys; len(keys) != 2 || keys[1].KeyID != keyID(t, current) || keys[1].Algorithm != algorithm {
+				t.Fatalf("unexpected keys: %+v", keys)
+			}
+
+			c.now = c.now.Add(15 * time.Minute)
+			if _, err := ks.Parse(old); !errors.Is(err, tokens.ErrInvalidToken) {
+				t.Fatalf("expected invalid token after retention, got %v", err)
+			}
+			if _, err := ks.Parse(current); err != nil {
+				t.Fatalf("parse: %s", err)
+			}
+			if keys := ks.JWKS().Keys; len(keys) != 1 {
+				t.Fatalf("expected only the signing key, got %+v", keys)
+			}
+		})
+	}
+}
+
+func TestKeySetParse(t *testing.T) {
+	c := &clock{now: time.Now()}
+	ks := &tokens.KeySet{Now: c.Now}
+	if err := ks.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	other := &tokens.KeySet{}
+	if err := other.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+
+	valid := sign(t, ks, claims("user", c.now.Add(time.Minute)))
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims("user", c.now.Add(time.Minute))).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("none token: %s", err)
+	}
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", sign(t, ks, claims("user", c.now.Add(-time.Second)))},
+		{"no expiration", sign(t, ks, &tokens.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "user"}})},
+		{"other key", sign(t, other, claims("user", c.now.Add(time.Minute)))},
+		{"unsigned", unsigned},
+		{"modified", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]},
+		{"malformed", "token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ks.Parse(test.token); !errors.Is(err, tokens.ErrInvalidToken) {
+				t.Fatalf("expected invalid token, got %v", err)
+			}
+		})
+	}
+}
+
+func writeKey(t *testing.T, dir, name string) ed25519.PublicKey {
+	t.Helper()
+
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("encoding key: %s", err)
+	}
+	writeFile(t, filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	return pub
+}
+
+// writeFile writes data with modification time in the future, so that changes of files written
+// in quick succession are detected.
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing %s: %s", path, err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("touch: %s", err)
+	}
+}
+
+func TestKeySetDir(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-01.pem")
+	second := writeKey(t, dir, "2026-02.pem")
+
+	ks := &tokens.KeySet{Dir: dir, CheckInterval: time.Nanosecond}
+	if err := ks.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	old := sign(t, ks, claims("user", expiresAt))
+	keys := ks.JWKS().Keys
+	if len(keys) != 2 || keys[1].KeyID != keyID(t, old) {
+		t.Fatalf("token isn't signed by the last key: %+v", keys)
+	}
+	if keys[1].X != base64.RawURLEncoding.EncodeToString(second) {
+		t.Fatal("last key isn't the key of the last file")
+	}
+
+	writeKey(t, dir, "2026-03.pem")
+	current := sign(t, ks, claims("user", expiresAt))
+	if keyID(t, current) == keyID(t, old) {
+		t.Fatal("added key isn't used")
+	}
+	if _, err := ks.Parse(old); err != nil {
+		t.Fatalf("token of the previous key isn't valid: %s", err)
+	}
+
+	// Broken files are ignored.
+	writeFile(t, filepath.Join(dir, "2026-04.pem"), []byte("garbage"))
+	if keyID(t, sign(t, ks, claims("user", expiresAt))) != keyID(t, current) {
+		t.Fatal("signing key changed after a broken file")
+	}
+
+	if err := (&tokens.KeySet{Dir: t.TempDir()}).Load(); err == nil {
+		t.Fatal("expected error of an empty directory")
+	}
+}
+
+func TestKeySetServeHTTP(t *testing.T) {
+	ks := &tokens.KeySet{}
+	if err := ks.Load(); err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	token := sign(t, ks, claims("user", time.Now().Add(time.Hour)))
+
+	rec := httptest.NewRecorder()
+	ks.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tokens.JWKSPath, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+
+	set := &tokens.JSONWebKeySet{}
+	if err := json.NewDecoder(rec.Body).Decode(set); err != nil {
+		t.Fatalf("decoding jwks: %s", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].KeyID != keyID(t, token) || set.Keys[0].KeyType != "OKP" {
+		t.Fatalf("unexpected keys: %+v", set.Keys)
+	}
+
+	// The published key verifies the token.
+	x, err := base64.RawURLEncoding.DecodeString(set.Keys[0].X)
+	if err != nil {
+		t.Fatalf("decoding key: %s", err)
+	}
+	parts := strings.Split(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("decoding signature: %s", err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(x), []byte(parts[0]+"."+parts[1]), signature) {
+		t.Fatal("published key doesn't verify the token")
+	}
+
+	rec = httptest.NewRecorder()
+	ks.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tokens.JWKSPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+// tracing package configures OpenTelemetry tracing of the service.
+//
+// Packages create spans with tracers of the global provider, which is set by Setup. Trace
+// context is propagated in W3C traceparent and baggage headers, so traces continue from
+// callers of the HTTP gateway through gRPC down to SQL queries.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// Exporters of spans.
+const (
+	// ExporterNone doesn't record spans, trace context is still propagated.
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+type Options struct {
+	// Exporter is one of ExporterNone, ExporterStdout and ExporterOTLP.
+	Exporter string
+	// OTLPEndpoint is host:port of the OTLP gRPC collector.
+	OTLPEndpoint string
+	// OTLPInsecure connects to the collector without TLS.
+	OTLPInsecure bool
+	// SampleRatio is fraction of traces started by the service which are sampled. Sampling
+	// decisions of callers are respected.
+	SampleRatio float64
+	// ServiceName is service.name of the spans.
+	ServiceName string
+	// Stdout is written to by ExporterStdout, os.Stdout is used if it is nil.
+	Stdout io.Writer
+}
+
+// Setup sets the global tracer provider and W3C trace context propagator. Returned shutdown
+// exports buffered spans and stops the provider.
+func Setup(ctx context.Context, opts *Options) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	switch opts.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w := opts.Stdout
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(opts.OTLPEndpoint)}
+		if opts.OTLPInsecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, clientOpts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s trace exporter: %w", opts.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(opts.ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,64 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/toncek345/userservice/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestSetup(t *testing.T) {
+	t.Run("unknown exporter", func(t *testing.T) {
+		if _, err := tracing.Setup(context.Background(), &tracing.Options{Exporter: "jaeger"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("none propagates trace context", func(t *testing.T) {
+		shutdown, err := tracing.Setup(context.Background(), &tracing.Options{Exporter: tracing.ExporterNone})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer shutdown(context.Background())
+
+		traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+		ctx := otel.GetTextMapPropagator().Extract(context.Background(),
+			propagation.MapCarrier{"traceparent": traceparent})
+		_, span := otel.Tracer("test").Start(ctx, "span")
+		span.End()
+
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(ctx, carrier)
+		if carrier["traceparent"] != traceparent {
+			t.Fatalf("expected traceparent %q, got %q", traceparent, carrier["traceparent"])
+		}
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		shutdown, err := tracing.Setup(context.Background(), &tracing.Options{
+			Exporter:    tracing.ExporterStdout,
+			SampleRatio: 1,
+			ServiceName: "userservice",
+			Stdout:      out,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		_, span := otel.Tracer("test").Start(context.Background(), "exported span")
+		span.End()
+		if err := shutdown(context.Background()); err != nil {
+			t.Fatalf("shutdown: %s", err)
+		}
+
+		if !strings.Contains(out.String(), `"Name":"exported span"`) {
+			t.Fatalf("span isn't exported: %s", out.String())
+		}
+	})
+}